@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"iter"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 
+	"zipget/internal/config"
 	"zipget/internal/loader"
 	"zipget/internal/model"
 )
@@ -24,66 +27,151 @@ var (
 	statusFile = flag.String("s", "", "Save status to file, use '-' for stdout.")
 	verbose    = flag.Bool("v", false, "Enable debug mode and output status to stderr.")
 	nothing    = flag.Bool("n", false, "Don't download anything, check only with HEAD requests.")
+	concurrent = flag.Int("c", 4, "Maximum number of concurrent requests, 0 for unlimited.")
+	batchSize  = flag.Int("b", 1000, "Process URLs in batches of this size, the URL file is read as a stream.")
+	format     = flag.String("f", loader.FormatZip, "Archive format: zip or targz.")
 )
 
 func main() {
 	flag.Parse()
 
-	urls := flag.Args()
-	if *urlsFile != "" {
-		var err error
-		urls, err = loadURLsFromFile(*urlsFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if *urlsFile == "" && flag.NArg() == 0 {
+		usage("URLs required")
 	}
-
-	if len(urls) == 0 {
-		fmt.Fprintln(os.Stderr, "URLs required")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if !*nothing && *outputFile == "" {
+		usage("output file required")
+	}
+	if *batchSize <= 0 {
+		usage("batch size must be positive")
+	}
+	if *format != loader.FormatZip && *format != loader.FormatTarGz {
+		usage("format must be zip or targz")
 	}
 
 	setupLogger()
 
-	var (
-		files []model.File
-		err   error
-	)
+	status, closeStatus, err := openStatus()
+	if err != nil {
+		log.Fatalf("create status file failed: %v", err)
+	}
+
+	input := io.Reader(strings.NewReader(strings.Join(flag.Args(), "\n")))
+	if *urlsFile != "" {
+		f, err := openURLs(*urlsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		input = f
+	}
 
+	sw := &statusWriter{w: status}
+	var n int
 	if *nothing {
-		files, err = checkOnly(urls)
+		n, err = checkOnly(input, sw)
 	} else {
-		files, err = download(urls)
+		n, err = download(input, sw)
 	}
 
+	if err == nil {
+		err = sw.close()
+	}
+	if cerr := closeStatus(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	if *verbose || *statusFile != "" {
-		buf, _ := json.MarshalIndent(files, "", "    ")
-		if *verbose {
-			os.Stderr.Write(buf)
-		}
-		if *statusFile != "" {
-			if err := os.WriteFile(*statusFile, buf, 0666); err != nil {
-				log.Fatalf("write status failed: %v", err)
-			}
+	if n == 0 {
+		usage("URLs required")
+	}
+}
+
+func usage(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// openStatus возвращает получателя JSON-статуса: stderr (-v) и/или файл (-s).
+func openStatus() (io.Writer, func() error, error) {
+	var ws []io.Writer
+	if *verbose {
+		ws = append(ws, os.Stderr)
+	}
+
+	closeFile := func() error { return nil }
+	switch *statusFile {
+	case "":
+	case "-":
+		ws = append(ws, os.Stdout)
+	default:
+		f, err := os.Create(*statusFile)
+		if err != nil {
+			return nil, nil, err
 		}
+		ws = append(ws, f)
+		closeFile = f.Close
+	}
+
+	return io.MultiWriter(ws...), closeFile, nil
+}
+
+func newLoader() *loader.Loader {
+	// в загрузчике 0 - последовательная загрузка архива, в флаге - без ограничений
+	downloadConcurrency := *concurrent
+	if downloadConcurrency == 0 {
+		downloadConcurrency = -1
 	}
+	return loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes:      validMIMETypes,
+		Concurrency:         *concurrent,
+		DownloadConcurrency: downloadConcurrency,
+		PartialContent:      loader.PartialFull,
+		DetectHTMLPages:     true,
+		FinalizeOnCancel:    true,
+		EntryMode:           0o644,
+	})
 }
 
-func checkOnly(urls []string) ([]model.File, error) {
-	ldr := loader.New(http.DefaultClient, validMIMETypes)
-	return ldr.Check(context.Background(), urls)
+// checkOnly проверяет URL из input пачками по -b штук, записывая статус каждой пачки сразу после
+// проверки: в памяти не держится больше одной пачки. Возвращает количество проверенных URL.
+func checkOnly(input io.Reader, sw *statusWriter) (int, error) {
+	ldr := newLoader()
+	n := 0
+	err := batches(input, *batchSize, func(urls []string) error {
+		n += len(urls)
+		files, err := ldr.Check(context.Background(), urls)
+		if err != nil {
+			return err
+		}
+		return sw.write(files)
+	})
+	return n, err
 }
 
-func download(urls []string) ([]model.File, error) {
-	if *outputFile == "" {
-		fmt.Fprintln(os.Stderr, "output file required")
-		flag.PrintDefaults()
-		os.Exit(1)
+// download скачивает все URL из input в один архив. URL читаются потоком: загрузчик берёт их
+// пачками по -b штук по мере загрузки. В памяти держатся только результаты файлов: они нужны
+// для status.json, а центральный каталог ZIP - для всех записей.
+func download(input io.Reader, sw *statusWriter) (int, error) {
+	var readErr error
+	next, stop := iter.Pull(readURLs(input, &readErr))
+	defer stop()
+
+	// без URL выходной файл не создаётся
+	first, ok := next()
+	if !ok {
+		return 0, readErr
+	}
+	n := 0
+	urls := func(yield func(string) bool) {
+		for uri, ok := first, true; ok; uri, ok = next() {
+			n++
+			if !yield(uri) {
+				return
+			}
+		}
 	}
 
 	output := os.Stdout
@@ -99,8 +187,15 @@ func download(urls []string) ([]model.File, error) {
 	w := bufio.NewWriter(output)
 	defer w.Flush()
 
-	ldr := loader.New(http.DefaultClient, validMIMETypes)
-	return ldr.Download(context.Background(), urls, w)
+	ldr := newLoader()
+	files, err := ldr.DownloadSeq(context.Background(), urls, *batchSize, w, model.Archive{Format: *format})
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, sw.write(files)
 }
 
 func setupLogger() {
@@ -115,27 +210,105 @@ func setupLogger() {
 	log.Printf("logging level %v", level)
 }
 
-func loadURLsFromFile(fileName string) ([]string, error) {
-	input := os.Stdin
-	if fileName != "-" {
-		var err error
-		input, err = os.Open(fileName)
-		if err != nil {
-			return nil, err
+func openURLs(fileName string) (io.ReadCloser, error) {
+	if fileName == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(fileName)
+}
+
+// readURLs возвращает последовательность URL из r: по одному на строку, пустые строки и
+// строки-комментарии с '#' пропускаются, из строк удаляются BOM и символы нулевой ширины
+// (см. cleanLine). Ошибка чтения r записывается в *errp по окончании последовательности.
+func readURLs(r io.Reader, errp *error) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			line := cleanLine(sc.Text())
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			if !yield(line) {
+				return
+			}
 		}
-		defer input.Close()
+		*errp = sc.Err()
 	}
+}
 
-	sc := bufio.NewScanner(input)
-	var urls []string
+// batches читает URL из r (см. readURLs) и передаёт их в fn пачками не больше size.
+// Срез пачки переиспользуется.
+func batches(r io.Reader, size int, fn func(urls []string) error) error {
+	var readErr error
+	batch := make([]string, 0, size)
 
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if len(line) == 0 || line[0] == '#' {
-			continue
+	for uri := range readURLs(r, &readErr) {
+		batch = append(batch, uri)
+		if len(batch) == size {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
 		}
-		urls = append(urls, line)
+	}
+	if readErr != nil {
+		return readErr
 	}
 
-	return urls, sc.Err()
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// invisibleChars удаляет из строки BOM (U+FEFF) и символы нулевой ширины, которые оставляют
+// редакторы (особенно в Windows): в URL они не допускаются, а в тексте их не видно.
+var invisibleChars = strings.NewReplacer(
+	"\uFEFF", "", // BOM (в начале файла) / неразрывный пробел нулевой ширины
+	"\u200B", "", // пробел нулевой ширины
+	"\u200C", "", // разъединитель нулевой ширины
+	"\u200D", "", // соединитель нулевой ширины
+	"\u2060", "", // соединитель слов
+)
+
+// cleanLine возвращает строку файла URL без невидимых символов и пробелов по краям.
+func cleanLine(line string) string {
+	return strings.TrimSpace(invisibleChars.Replace(line))
+}
+
+// statusWriter пишет статусы файлов JSON-массивом по мере их поступления.
+type statusWriter struct {
+	w io.Writer
+	n int // количество записанных элементов
+}
+
+func (sw *statusWriter) write(files []model.File) error {
+	for i := range files {
+		buf, err := json.MarshalIndent(files[i], "    ", "    ")
+		if err != nil {
+			return err
+		}
+		sep := ",\n    "
+		if sw.n == 0 {
+			sep = "[\n    "
+		}
+		if _, err := io.WriteString(sw.w, sep); err != nil {
+			return err
+		}
+		if _, err := sw.w.Write(buf); err != nil {
+			return err
+		}
+		sw.n++
+	}
+	return nil
+}
+
+// close завершает массив (пустой массив, если статусов не было).
+func (sw *statusWriter) close() error {
+	end := "\n]\n"
+	if sw.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(sw.w, end)
+	return err
 }
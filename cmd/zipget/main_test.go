@@ -0,0 +1,165 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"zipget/internal/model"
+
+	"github.com/nalgeon/be"
+)
+
+// urlSource отдаёт n строк с URL (base/<номер>.jpg, по умолчанию https://example.com),
+// формируя их по мере чтения, и считает прочитанные байты.
+type urlSource struct {
+	n, next int
+	base    string
+	pending []byte
+	read    atomic.Int64
+}
+
+func (s *urlSource) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.next == s.n {
+			return 0, io.EOF
+		}
+		s.pending = fmt.Appendf(nil, "%s/%d.jpg\n", cmp.Or(s.base, "https://example.com"), s.next)
+		s.next++
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	s.read.Add(int64(n))
+	return n, nil
+}
+
+func TestBatches(t *testing.T) {
+	input := "# comment\nhttp://a\n\n  http://b  \nhttp://c\n"
+
+	var got [][]string
+	err := batches(strings.NewReader(input), 2, func(urls []string) error {
+		got = append(got, append([]string(nil), urls...))
+		return nil
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, got, [][]string{{"http://a", "http://b"}, {"http://c"}})
+}
+
+func TestBatches_BOM(t *testing.T) {
+	// файл, сохранённый в Windows: BOM в начале, CRLF, символы нулевой ширины
+	input := "\uFEFFhttp://a\r\n\u200Bhttp://b\u200D\r\n\uFEFF# comment\r\n \u2060 \r\n"
+
+	var got []string
+	err := batches(strings.NewReader(input), 10, func(urls []string) error {
+		got = append(got, urls...)
+		return nil
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, got, []string{"http://a", "http://b"})
+}
+
+func TestBatches_LargeInput(t *testing.T) {
+	const (
+		total   = 1_000_000
+		size    = 1000
+		lineLen = 32   // не меньше длины строки urlSource
+		scanBuf = 4096 // начальный буфер bufio.Scanner
+	)
+	src := &urlSource{n: total}
+
+	var count, calls int
+	err := batches(src, size, func(urls []string) error {
+		be.True(t, len(urls) <= size)
+		// вход читается потоком: к обработке пачки прочитано не больше пачки и буфера сканера
+		be.True(t, src.read.Load() <= int64((calls+1)*size*lineLen+scanBuf))
+		count += len(urls)
+		calls++
+		return nil
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, count, total)
+	be.Equal(t, calls, total/size)
+}
+
+func TestDownload(t *testing.T) {
+	const (
+		total   = 500
+		size    = 50
+		lineLen = 32   // не меньше длины строки urlSource
+		scanBuf = 4096 // начальный буфер bufio.Scanner
+	)
+	src := &urlSource{n: total}
+
+	// к запросу i-го файла прочитано не больше его пачки и буфера сканера
+	var overrun atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".jpg"))
+		if src.read.Load() > int64((i/size+1)*size*lineLen+scanBuf) {
+			overrun.Add(1)
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xFF\xD8\xFF\xE0 jpeg"))
+	}))
+	defer srv.Close()
+	src.base = srv.URL
+
+	out := filepath.Join(t.TempDir(), "out.zip")
+	defer func(o string, b int) { *outputFile, *batchSize = o, b }(*outputFile, *batchSize)
+	*outputFile, *batchSize = out, size
+
+	var status bytes.Buffer
+	sw := &statusWriter{w: &status}
+	n, err := download(src, sw)
+	be.Err(t, err, nil)
+	be.Equal(t, n, total)
+	be.Equal(t, sw.n, total)
+	be.Equal(t, overrun.Load(), int32(0))
+
+	zr, err := zip.OpenReader(out)
+	be.Err(t, err, nil)
+	defer zr.Close()
+	be.Equal(t, len(zr.File), total+1) // файлы и status.json
+	be.Equal(t, zr.File[total-1].Name, fmt.Sprintf("unnamed-%d.jpg", total))
+
+	// без URL архив не создаётся
+	os.Remove(out)
+	n, err = download(strings.NewReader("# comment\n"), &statusWriter{w: io.Discard})
+	be.Err(t, err, nil)
+	be.Equal(t, n, 0)
+	_, err = os.Stat(out)
+	be.True(t, os.IsNotExist(err))
+}
+
+func TestStatusWriter(t *testing.T) {
+	files := []model.File{
+		{URL: "http://a", Status: 200},
+		{URL: "http://b", Status: 404},
+		{URL: "http://c", Status: 200},
+	}
+
+	var buf bytes.Buffer
+	sw := &statusWriter{w: &buf}
+	be.Err(t, sw.write(files[:2]), nil)
+	be.Err(t, sw.write(files[2:]), nil)
+	be.Err(t, sw.close(), nil)
+
+	want, err := json.MarshalIndent(files, "", "    ")
+	be.Err(t, err, nil)
+	be.Equal(t, buf.String(), string(want)+"\n")
+
+	buf.Reset()
+	sw = &statusWriter{w: &buf}
+	be.Err(t, sw.close(), nil)
+	be.Equal(t, buf.String(), "[]\n")
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,7 +18,10 @@ import (
 	"zipget/internal/logger"
 	"zipget/internal/manager"
 	"zipget/internal/memstor"
+	"zipget/internal/metrics"
 	"zipget/internal/protect"
+	"zipget/internal/taskid"
+	"zipget/internal/webhook"
 
 	"github.com/joho/godotenv"
 )
@@ -26,6 +30,8 @@ const (
 	shutdownTimeout = 30 * time.Second
 	apiBasePath     = "/api"
 	filesBasePath   = "/files"
+	adminBasePath   = "/admin"
+	metricsPath     = "/metrics"
 )
 
 func main() {
@@ -40,17 +46,40 @@ func main() {
 
 	slog.Debug("server config", "cfg", cfg)
 
-	client := newHTTPClient()
+	client := newHTTPClient(cfg.Loader)
 	stor := memstor.New(memstor.Config{
-		MaxTotal: cfg.Manager.MaxTotal,
-		MaxFiles: cfg.Manager.MaxFiles,
-		TaskTTL:  cfg.Manager.TaskTTL,
+		MaxTotal:  cfg.Manager.MaxTotal,
+		MaxFiles:  cfg.Manager.MaxFiles,
+		TaskTTL:   cfg.Manager.TaskTTL,
+		DedupURLs: cfg.Manager.DedupURLs,
+		Metrics:   cfg.Manager.StorageMetrics,
 	})
 	defer stor.Cancel()
-	loader := loader.New(client, cfg.Loader.AllowMIMETypes)
-	manager := manager.New(cfg.Manager, stor, loader)
+	loader := loader.New(client, cfg.Loader)
+
+	// уведомления доставляются тем же клиентом, что и загрузка файлов (с защитой от SSRF)
+	var notifier manager.Notifier
+	var ntf *webhook.Notifier
+	if cfg.Manager.Webhooks {
+		ntf = webhook.New(client, cfg.Manager.WebhookRetries, cfg.Manager.WebhookBackoff, cfg.Manager.WebhookTimeout)
+		notifier = ntf
+	}
+
+	// ID задач выдаются клиентам в одном формате и в API, и в уведомлениях
+	ids, err := taskid.New(cfg.API.TaskIDFormat, cfg.API.TaskIDKey)
+	if err != nil {
+		log.Fatalf("load config failed: %v", err)
+	}
 
-	handler := logger.HTTPLogging(slog.Default(), api.New(manager, apiBasePath, filesBasePath))
+	manager := manager.New(cfg.Manager, stor, loader, notifier)
+	manager.SetTaskIDs(ids)
+
+	mux := api.New(cfg.API, manager, ids, apiBasePath, filesBasePath, adminBasePath)
+	if cfg.Server.Metrics {
+		mux.Handle("GET "+metricsPath, metrics.Handler())
+	}
+
+	handler := logger.HTTPLogging(slog.Default(), cfg.Logger.HTTPTiming, mux)
 	server := newServer(cfg.Server.Addr, handler)
 
 	done := make(chan int)
@@ -68,6 +97,13 @@ func main() {
 			slog.Error("sutdown failed", "error", err)
 		}
 
+		// дожидаемся доставки начатых уведомлений, пока не истёк таймаут остановки
+		if ntf != nil {
+			if err := ntf.Shutdown(ctx); err != nil {
+				slog.Warn("webhook deliveries cancelled", "error", err)
+			}
+		}
+
 		close(done)
 	}()
 
@@ -81,28 +117,47 @@ func main() {
 }
 
 // newHTTPClient создаёт клиент с разумными таймаутами для загрузки файлов и защитой от SSRF.
-func newHTTPClient() *http.Client {
+func newHTTPClient(cfg config.Loader) *http.Client {
 	dialer := &net.Dialer{
 		Timeout:   5 * time.Second,
 		KeepAlive: 30 * time.Second,
 	}
-	return &http.Client{
-		Transport: &http.Transport{
-			// SSRF protect
-			// FIXME: это решение "на коленке"
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				addr, err := protect.ReplaceHostToIP(addr)
-				if err != nil {
-					return nil, err
-				}
-				return dialer.DialContext(ctx, network, addr)
-			},
-			TLSHandshakeTimeout:   10 * time.Second,
-			ResponseHeaderTimeout: 10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
+	transport := &http.Transport{
+		// SSRF protect
+		// FIXME: это решение "на коленке"
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			addr, err := protect.ReplaceHostToIP(addr, cfg.IPFamily)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, addr)
 		},
+		// Время ожидания ответа ограничивается загрузчиком отдельно для проверки и загрузки
+		// (LOADER_CHECK_TIMEOUT, LOADER_DOWNLOAD_HEADER_TIMEOUT)
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	// дополнительные схемы (LOADER_ALLOW_SCHEMES) обслуживает прокси LOADER_SCHEME_PROXY
+	registered := make(map[string]bool)
+	for _, scheme := range cfg.AllowSchemes {
+		scheme = strings.ToLower(scheme)
+		if config.IsHTTPScheme(scheme) || registered[scheme] {
+			continue
+		}
+		transport.RegisterProtocol(scheme, protect.SchemeProxy(cfg.SchemeProxy, cfg.IPFamily))
+		registered[scheme] = true
+	}
+
+	return &http.Client{
+		CheckRedirect: protect.RedirectPolicy{
+			MaxRedirects:   cfg.MaxRedirects,
+			AllowDowngrade: cfg.AllowRedirectDowngrade,
+			BlockPrivate:   true,
+		}.CheckRedirect,
+		Transport: transport,
 	}
 }
 
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"zipget/internal/model"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// adminAuth пропускает запрос, только если он содержит заголовок "Authorization: Bearer <token>".
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			h := newHelper(w, r, "AdminAuth")
+			h.log.Warn("admin access denied")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			h.WriteError(&httpError{http.StatusUnauthorized, "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type findTasksResponse struct {
+	Tasks []model.Task `json:"tasks"`
+	Total int          `json:"total"`
+}
+
+// FindTasks возвращает задачи с полным состоянием файлов.
+//
+// Параметры запроса:
+//   - has_failed=true - только задачи, в которых есть файлы с ошибкой;
+//   - older_than=1h - созданные раньше, чем указано;
+//   - newer_than=10m - созданные позже, чем указано;
+//   - limit, offset - пагинация (по умолчанию 100 задач).
+func FindTasks(m Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "FindTasks")
+
+		filter, err := h.taskFilter()
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		limit, offset, err := h.Page(defaultPageLimit, maxPageLimit)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		tasks, total, err := m.FindTasks(h.Ctx(), filter, limit, offset)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		h.WriteResponse(findTasksResponse{Tasks: tasks, Total: total}, http.StatusOK)
+	}
+}
+
+// ExportTasks выгружает все задачи с полным состоянием файлов в формате JSON Lines
+// (одна задача на строку, от старых к новым) для резервного копирования.
+func ExportTasks(m Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "ExportTasks")
+
+		tasks, err := m.ExportTasks(h.Ctx())
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		w.Header().Set("content-type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for i := range tasks {
+			if err := enc.Encode(tasks[i]); err != nil {
+				h.log.Error("write respose failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+type importTasksResponse struct {
+	Imported int `json:"imported"`
+}
+
+// ImportTasks восстанавливает задачи из выгрузки ExportTasks (JSON Lines).
+// Задачи с существующими ID заменяются, устаревшие пропускаются.
+func ImportTasks(m Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "ImportTasks")
+
+		var tasks []model.Task
+		dec := json.NewDecoder(r.Body)
+		for {
+			var task model.Task
+			if err := dec.Decode(&task); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				msg := "can't parse request body"
+				h.log.Error(msg, "error", err)
+				h.WriteError(&httpError{http.StatusBadRequest, msg})
+				return
+			}
+			tasks = append(tasks, task)
+		}
+
+		n, err := m.ImportTasks(h.Ctx(), tasks)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		h.log.Info("tasks imported", "count", n)
+		h.WriteResponse(importTasksResponse{Imported: n}, http.StatusOK)
+	}
+}
+
+type cleanupResponse struct {
+	Removed int `json:"removed"`
+}
+
+// Cleanup немедленно удаляет устаревшие задачи, не дожидаясь очистки по таймеру.
+func Cleanup(m Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "Cleanup")
+
+		n, err := m.CleanExpiredTasks(h.Ctx())
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		h.log.Info("expired tasks removed", "count", n)
+		h.WriteResponse(cleanupResponse{Removed: n}, http.StatusOK)
+	}
+}
+
+func (h *helper) taskFilter() (model.TaskFilter, error) {
+	var filter model.TaskFilter
+	var err error
+
+	if filter.HasFailed, err = h.QueryBool("has_failed", false); err != nil {
+		return filter, err
+	}
+
+	now := time.Now()
+
+	olderThan, err := h.QueryDuration("older_than")
+	if err != nil {
+		return filter, err
+	}
+	if olderThan > 0 {
+		filter.CreatedBefore = now.Add(-olderThan)
+	}
+
+	newerThan, err := h.QueryDuration("newer_than")
+	if err != nil {
+		return filter, err
+	}
+	if newerThan > 0 {
+		filter.CreatedAfter = now.Add(-newerThan)
+	}
+
+	return filter, nil
+}
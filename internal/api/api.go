@@ -2,41 +2,87 @@ package api
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
-	"errors"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"zipget/internal/config"
+	"zipget/internal/loader"
 	"zipget/internal/logger"
 	"zipget/internal/model"
-)
+	"zipget/internal/taskid"
+	"zipget/internal/urlutil"
+	"zipget/internal/webui"
 
-const (
-	numberOfFilesToShowArchiveURL = 3
+	"golang.org/x/time/rate"
 )
 
 type Manager interface {
-	CreateTask(ctx context.Context) (int64, error)
+	CreateTask(ctx context.Context, opts model.TaskOptions) (int64, error)
 	DeleteTask(ctx context.Context, taskID int64) error
 	AddFileToTask(ctx context.Context, taskID int64, url string) error
+	GetTask(ctx context.Context, taskID int64) (model.Task, error)
 	GetTaskStatus(ctx context.Context, taskID int64) (model.Task, error)
-	ProcessTask(ctx context.Context, taskID int64, out io.Writer) error
+	PrepareTask(ctx context.Context, taskID int64) (model.Task, error)
+	EstimateArchiveSize(ctx context.Context, taskID int64) (int64, error)
+	CheckURL(ctx context.Context, url string) (model.File, error)
+	FindTasks(ctx context.Context, filter model.TaskFilter, limit, offset int) ([]model.Task, int, error)
+	ExportTasks(ctx context.Context) ([]model.Task, error)
+	ImportTasks(ctx context.Context, tasks []model.Task) (int, error)
+	CleanExpiredTasks(ctx context.Context) (int, error)
+	ProcessTask(ctx context.Context, taskID int64, format string, out io.Writer, onFile func(model.File)) error
 }
 
-func New(manager Manager, apiBasePath, filesBasePath string) *http.ServeMux {
+// New создаёт обработчик API. ids задаёт формат ID задачи в запросах и ответах (nil - числовой).
+func New(cfg config.API, manager Manager, ids *taskid.Codec, apiBasePath, filesBasePath, adminBasePath string) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST " /****/ +apiBasePath+"/tasks", CreateTask(manager))
-	mux.HandleFunc("DELETE " /**/ +apiBasePath+"/tasks/{id}", DeleteTask(manager))
-	mux.HandleFunc("GET " /*****/ +apiBasePath+"/tasks/{id}", GetTaskStatus(manager, filesBasePath))
-	mux.HandleFunc("POST " /****/ +apiBasePath+"/tasks/{id}/files", AddFileToTask(manager))
-	mux.HandleFunc("GET " /*****/ +apiBasePath+"/tasks/{id}/archive", ProcessTask(manager))
-
-	mux.Handle("GET "+filesBasePath+"/", GetArchive(filesBasePath))
-	mux.Handle(apiBasePath+"/ping", Pong())
+	rt := router{mux: mux, trailingSlash: cfg.TrailingSlash}
+
+	rt.Handle("POST " /****/ +apiBasePath+"/tasks", CreateTask(manager, ids))
+	rt.Handle("DELETE " /**/ +apiBasePath+"/tasks/{id}", DeleteTask(manager, ids))
+	rt.Handle("GET " /*****/ +apiBasePath+"/tasks/{id}", GetTaskStatus(manager, ids, filesBasePath, cfg.ArchiveLinkFiles, cfg.MaskQueryParams))
+	rt.Handle("POST " /****/ +apiBasePath+"/tasks/{id}/files", AddFileToTask(manager, ids))
+	rt.Handle("POST " /****/ +apiBasePath+"/tasks/{id}/prepare", PrepareTask(manager, ids, cfg.MaskQueryParams))
+	rt.Handle("HEAD " /****/ +apiBasePath+"/tasks/{id}/archive", ArchiveSize(manager, ids))
+	rt.Handle("GET " /*****/ +apiBasePath+"/tasks/{id}/archive", ProcessTask(manager, ids, cfg.VersionedArchiveName, cfg.Base64MaxSize, cfg.ArchiveDisposition, cfg.ProgressTrailers))
+	rt.Handle("POST " /****/ +apiBasePath+"/check", CheckURL(manager, newRateLimiter(cfg.CheckRate, cfg.CheckBurst), cfg.MaskQueryParams))
+
+	// ID задачи служит ключом доступа к ней, поэтому список задач включается явно
+	if cfg.ListTasks {
+		rt.Handle("GET " /*****/ +apiBasePath+"/tasks", ListTasks(manager, ids))
+	}
+
+	mux.Handle("GET "+filesBasePath+"/", GetArchive(filesBasePath, cfg.FilesIndex, ids))
+	rt.Handle(apiBasePath+"/ping", Pong())
+
+	// веб-интерфейс включается явно
+	if cfg.UIPath != "" {
+		uiPath := strings.TrimSuffix(cfg.UIPath, "/")
+		mux.Handle("GET "+uiPath+"/", http.StripPrefix(uiPath, webui.Handler(apiBasePath)))
+	}
+
+	// все незарегистрированные пути
+	mux.Handle("/", Unmatched(mux))
+
+	// административные методы доступны только при заданном токене
+	if cfg.AdminToken != "" {
+		rt.Handle("GET " /*****/ +adminBasePath+"/tasks", adminAuth(cfg.AdminToken, FindTasks(manager)))
+		rt.Handle("GET " /*****/ +adminBasePath+"/tasks/export", adminAuth(cfg.AdminToken, ExportTasks(manager)))
+		rt.Handle("POST " /****/ +adminBasePath+"/tasks/import", adminAuth(cfg.AdminToken, ImportTasks(manager)))
+		rt.Handle("POST " /****/ +adminBasePath+"/cleanup", adminAuth(cfg.AdminToken, Cleanup(manager)))
+	}
+
 	return mux
 }
 
@@ -44,30 +90,95 @@ func Pong() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) { http.Error(w, "pong", http.StatusOK) }
 }
 
+// NotFound отвечает 404 в формате ошибок API.
+func NotFound() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "NotFound")
+		h.WriteError(&httpError{http.StatusNotFound, "not found"})
+	}
+}
+
+type createTaskRequest struct {
+	CallbackURL string `json:"callback_url,omitempty"`
+	Name        string `json:"name,omitempty"` // имя архива при скачивании
+}
+
 type createTaskResponse struct {
-	TaskID int64 `json:"task_id"`
+	TaskID taskid.Ref `json:"task_id"`
 }
 
-func CreateTask(m Manager) http.HandlerFunc {
+func CreateTask(m Manager, ids *taskid.Codec) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h := newHelper(w, r, "CreateTask")
 
-		taskID, err := m.CreateTask(h.Ctx())
+		// тело необязательно
+		var req createTaskRequest
+		if err := h.ReadOptionalRequest(&req); err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		// имя архива очищается как имена файлов архива, поэтому безопасно и в Content-Disposition
+		opts := model.TaskOptions{CallbackURL: req.CallbackURL, Name: loader.ArchiveBaseName(req.Name)}
+		taskID, err := m.CreateTask(h.Ctx(), opts)
 		if err != nil {
 			h.WriteError(err)
 			return
 		}
 
-		resp := createTaskResponse{TaskID: taskID}
+		resp := createTaskResponse{TaskID: ids.Ref(taskID)}
 		h.WriteResponse(resp, http.StatusCreated)
 	}
 }
 
-func DeleteTask(m Manager) http.HandlerFunc {
+type taskSummary struct {
+	ID        taskid.Ref `json:"id"`
+	Files     int        `json:"files"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+type listTasksResponse struct {
+	Tasks []taskSummary `json:"tasks"`
+	Total int           `json:"total"`
+}
+
+// ListTasks возвращает краткие сведения о задачах (от новых к старым) и общее количество задач.
+// Параметры запроса limit и offset задают страницу (по умолчанию 100 задач).
+func ListTasks(m Manager, ids *taskid.Codec) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "ListTasks")
+
+		limit, offset, err := h.Page(defaultPageLimit, maxPageLimit)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		tasks, total, err := m.FindTasks(h.Ctx(), model.TaskFilter{}, limit, offset)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		resp := listTasksResponse{Tasks: make([]taskSummary, 0, len(tasks)), Total: total}
+		for _, task := range tasks {
+			resp.Tasks = append(resp.Tasks, taskSummary{
+				ID:        ids.Ref(task.ID),
+				Files:     len(task.Files),
+				CreatedAt: task.CreatedAt,
+				ExpiresAt: task.ExpiresAt,
+			})
+		}
+		h.WriteResponse(resp, http.StatusOK)
+	}
+}
+
+func DeleteTask(m Manager, ids *taskid.Codec) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h := newHelper(w, r, "DeleteTask")
 
-		taskID, err := h.GetID()
+		taskID, err := h.GetID(ids)
 		if err != nil {
 			h.WriteError(err)
 			return
@@ -86,11 +197,11 @@ type addFileToTaskRequest struct {
 	URL string `json:"url,omitempty"`
 }
 
-func AddFileToTask(m Manager) http.HandlerFunc {
+func AddFileToTask(m Manager, ids *taskid.Codec) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h := newHelper(w, r, "AddFileToTask")
 
-		taskID, err := h.GetID()
+		taskID, err := h.GetID(ids)
 		if err != nil {
 			h.WriteError(err)
 			return
@@ -120,102 +231,531 @@ func AddFileToTask(m Manager) http.HandlerFunc {
 }
 
 type getTaskStatusResponse struct {
-	Task    model.Task `json:"task"`
-	Archive string     `json:"archive,omitempty"`
+	Task       model.Task `json:"task"`
+	FilesTotal int        `json:"files_total"` // количество файлов задачи (task.files может содержать только страницу)
+	ValidFiles int        `json:"valid_files"` // количество доступных файлов задачи (статус 200)
+	Ready      bool       `json:"archive_ready"`
+	Archive    string     `json:"archive,omitempty"`
 }
 
-func GetTaskStatus(m Manager, filesBasePath string) http.HandlerFunc {
+// GetTaskStatus возвращает статус задачи.
+//
+// Параметры запроса files_limit и files_offset позволяют получить только страницу файлов задачи
+// (по умолчанию возвращаются все файлы). Значения параметров maskParams в URL файлов скрываются.
+//
+// Ссылка на архив возвращается, когда в задаче не меньше linkFiles файлов и хотя бы один из них
+// доступен: иначе архив содержал бы только status.json.
+//
+// Ответ содержит ETag - версию сохранённого состояния задачи. If-None-Match сравнивается с ней
+// до проверки файлов: если клиент уже получил это состояние, ответ 304 без проверки (файлы
+// с временной ошибкой перепроверяются запросом без If-None-Match или с устаревшим ETag).
+// Файлы в окончательном состоянии повторно не проверяются, поэтому опрос неизменной задачи дёшев.
+func GetTaskStatus(m Manager, ids *taskid.Codec, filesBasePath string, linkFiles int, maskParams []string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h := newHelper(w, r, "GetTaskStatus")
 
-		taskID, err := h.GetID()
+		taskID, err := h.GetID(ids)
 		if err != nil {
 			h.WriteError(err)
 			return
 		}
 
+		filesLimit, filesOffset, err := h.page("files_limit", "files_offset", math.MaxInt, 0)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		response := func(task model.Task) getTaskStatusResponse {
+			resp := getTaskStatusResponse{Task: publicTask(task, ids), FilesTotal: len(task.Files)}
+			for _, f := range task.Files {
+				if f.Status == http.StatusOK {
+					resp.ValidFiles++
+				}
+			}
+			resp.Task.Files = maskFiles(paginate(task.Files, filesLimit, filesOffset), maskParams)
+
+			// XXX чтобы удовлетворить требовние ТЗ:
+			// "Как только число добавляемых файлов в задачу будет равно трем, метод получения
+			// статуса должен, вместе со статусом, вернуть ссылку на архив."
+			// (порог - API_ARCHIVE_LINK_FILES, по умолчанию 3)
+			resp.Ready = len(task.Files) >= linkFiles && resp.ValidFiles > 0
+			if resp.Ready {
+				resp.Archive = fmt.Sprintf("%s/task_%s.zip", filesBasePath, ids.Encode(taskID))
+			}
+			return resp
+		}
+
+		// клиент уже получил сохранённое состояние - файлы не перепроверяются
+		if h.r.Header.Get("If-None-Match") != "" {
+			stored, err := m.GetTask(h.Ctx(), taskID)
+			if err != nil {
+				h.WriteError(err)
+				return
+			}
+			if h.NotModified(response(stored)) {
+				return
+			}
+		}
+
 		task, err := m.GetTaskStatus(h.Ctx(), taskID)
 		if err != nil {
 			h.WriteError(err)
 			return
 		}
 
-		resp := getTaskStatusResponse{Task: task}
+		h.WriteConditional(response(task))
+	}
+}
+
+// publicTask скрывает числовой ID задачи, если клиентам выдаются непрозрачные ID
+// (клиент и так знает ID задачи, которую запросил).
+func publicTask(task model.Task, ids *taskid.Codec) model.Task {
+	if ids.Opaque() {
+		task.ID = 0
+	}
+	return task
+}
+
+type prepareTaskResponse struct {
+	Task model.Task `json:"task"`
+}
+
+func PrepareTask(m Manager, ids *taskid.Codec, maskParams []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "PrepareTask")
+
+		taskID, err := h.GetID(ids)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
 
-		// XXX чтобы удовлетворить требовние ТЗ:
-		// "Как только число добавляемых файлов в задачу будет равно трем, метод получения
-		// статуса должен, вместе со статусом, вернуть ссылку на архив."
-		if len(task.Files) >= numberOfFilesToShowArchiveURL {
-			resp.Archive = fmt.Sprintf("%s/task_%d.zip", filesBasePath, taskID)
+		task, err := m.PrepareTask(h.Ctx(), taskID)
+		if err != nil {
+			h.WriteError(err)
+			return
 		}
 
-		h.WriteResponse(resp, http.StatusOK)
+		task.Files = maskFiles(task.Files, maskParams)
+		h.WriteResponse(prepareTaskResponse{Task: publicTask(task, ids)}, http.StatusOK)
 	}
 }
 
-func ProcessTask(m Manager) http.HandlerFunc {
+type checkURLRequest struct {
+	URL string `json:"url,omitempty"`
+}
+
+type checkURLResponse struct {
+	File model.File `json:"file"`
+}
+
+// newRateLimiter создаёт ограничитель perMinute запросов в минуту (nil, если perMinute <= 0).
+func newRateLimiter(perMinute, burst int) *rate.Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), max(1, burst))
+}
+
+// CheckURL проверяет один URL без создания задачи (SSRF-защита и список MIME-типов применяются
+// как обычно). Частота запросов ограничена limiter (nil - без ограничений).
+func CheckURL(m Manager, limiter *rate.Limiter, maskParams []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "CheckURL")
+
+		if limiter != nil && !limiter.Allow() {
+			h.WriteError(model.ErrRateLimited)
+			return
+		}
+
+		var req checkURLRequest
+		if err := h.ReadRequest(&req); err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		if req.URL == "" {
+			h.WriteError(&httpError{
+				StatusCode: http.StatusBadRequest,
+				StatusMsg:  "url is required",
+			})
+			return
+		}
+
+		file, err := m.CheckURL(h.Ctx(), req.URL)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		file.URL = urlutil.MaskQuery(file.URL, maskParams)
+		h.WriteResponse(checkURLResponse{File: file}, http.StatusOK)
+	}
+}
+
+// maskFiles возвращает копию files, в URL которых скрыты значения параметров maskParams.
+// Сохранённые в задаче URL не меняются: файлы загружаются по исходным адресам.
+func maskFiles(files []model.File, maskParams []string) []model.File {
+	if len(maskParams) == 0 {
+		return files
+	}
+	masked := slices.Clone(files)
+	for i := range masked {
+		masked[i].URL = urlutil.MaskQuery(masked[i].URL, maskParams)
+	}
+	return masked
+}
+
+// archiveFileName возвращает имя архива для Content-Disposition. Имя не зависит от времени,
+// поэтому повторные и возобновлённые загрузки неизменной задачи получают одно и то же имя.
+// Базовое имя задаётся при создании задачи (name), по умолчанию - task_<id>.
+// Если versioned, в имя добавляется версия содержимого задачи: task_<id>-<version>.zip.
+// Расширение соответствует формату архива (.zip или .tar.gz).
+func archiveFileName(task model.Task, ids *taskid.Codec, versioned bool, format string) string {
+	name := cmp.Or(task.Name, "task_"+ids.Encode(task.ID))
+	if versioned {
+		name = fmt.Sprintf("%s-%s", name, task.ContentVersion())
+	}
+	return name + loader.ArchiveExtension(format)
+}
+
+// archiveFormat возвращает формат архива из параметра ?format=zip|targz, а без него - из
+// заголовка Accept: первый известный тип (application/zip или application/gzip,
+// application/x-gzip, application/x-gtar, application/x-tgz для tar.gz). По умолчанию - ZIP.
+func archiveFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if format != loader.FormatZip && format != loader.FormatTarGz {
+			return "", &httpError{http.StatusBadRequest, fmt.Sprintf("unsupported format %q", format)}
+		}
+		return format, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/zip":
+			return loader.FormatZip, nil
+		case "application/gzip", "application/x-gzip", "application/x-gtar", "application/x-tgz":
+			return loader.FormatTarGz, nil
+		}
+	}
+	return loader.FormatZip, nil
+}
+
+// contentDisposition возвращает значение Content-Disposition для файла name. Параметр filename
+// содержит ASCII-версию имени (прочие символы заменяются на "_"), а для имени с не-ASCII
+// символами добавляется filename* в кодировке RFC 5987, который браузеры предпочитают filename.
+func contentDisposition(disposition, name string) string {
+	var ascii strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r > 0x7E || r == '"' || r == '\\' {
+			r = '_'
+		}
+		ascii.WriteRune(r)
+	}
+
+	v := fmt.Sprintf(`%s; filename="%s"`, disposition, ascii.String())
+	if ascii.String() != name {
+		v += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return v
+}
+
+// encodeRFC5987 кодирует значение расширенного параметра (RFC 5987): символы вне attr-char
+// заменяются на %XX по байтам UTF-8.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("!#$&+-.^_`|~", c) != -1:
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0x0F])
+		}
+	}
+	return sb.String()
+}
+
+// archiveBase64Response - архив в JSON (?encoding=base64).
+type archiveBase64Response struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Data        string `json:"data"` // архив в base64 (стандартный алфавит, с выравниванием)
+}
+
+// ArchiveSize отвечает на HEAD-запрос архива его приблизительным размером в Content-Length,
+// не скачивая файлы (см. Manager.EstimateArchiveSize). Размер оценивается по заявленным
+// размерам файлов, доступных по последней проверке, без учёта сжатия, поэтому реальный архив
+// может оказаться и меньше (сжатие), и больше (источник отдал больше заявленного).
+// Размер оценивается только для ZIP: для tar.gz (см. archiveFormat) Content-Length не возвращается.
+func ArchiveSize(m Manager, ids *taskid.Codec) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHelper(w, r, "ArchiveSize")
+
+		taskID, err := h.GetID(ids)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		format, err := archiveFormat(r)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		size, err := m.EstimateArchiveSize(h.Ctx(), taskID)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", loader.ArchiveContentType(format))
+		if format == loader.FormatZip {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Способы отдачи архива в Content-Disposition (config.API.ArchiveDisposition, ?disposition=)
+const (
+	DispositionAttachment = "attachment" // сохранить файл
+	DispositionInline     = "inline"     // показать в браузере
+)
+
+// ProcessTask отдаёт архив задачи потоком. С параметром ?encoding=base64 архив собирается
+// в памяти (не больше base64MaxSize байт, иначе 413) и возвращается в JSON.
+// Параметр ?disposition=inline|attachment переопределяет disposition по умолчанию.
+// Формат архива (ZIP или tar.gz) выбирается параметром ?format= или заголовком Accept (см. archiveFormat).
+// При progressTrailers потоковый ответ завершается трейлерами с итогами сборки (см. archiveProgress).
+func ProcessTask(m Manager, ids *taskid.Codec, versionedName bool, base64MaxSize int64, defaultDisposition string, progressTrailers bool) http.HandlerFunc {
+	defaultDisposition = cmp.Or(defaultDisposition, DispositionAttachment)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		h := newHelper(w, r, "DownloadTaskFiles")
 
-		taskID, err := h.GetID()
+		taskID, err := h.GetID(ids)
 		if err != nil {
 			h.WriteError(err)
 			return
 		}
 
-		if _, err := m.GetTaskStatus(h.Ctx(), taskID); err != nil {
+		disposition := cmp.Or(r.URL.Query().Get("disposition"), defaultDisposition)
+		if disposition != DispositionAttachment && disposition != DispositionInline {
+			h.WriteError(&httpError{http.StatusBadRequest, fmt.Sprintf("unsupported disposition %q", disposition)})
+			return
+		}
+
+		format, err := archiveFormat(r)
+		if err != nil {
 			h.WriteError(err)
 			return
 		}
+		if r.URL.Query().Get("format") == "" {
+			w.Header().Set("Vary", "Accept")
+		}
 
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="task_%d.zip"`, taskID))
+		var encodeBase64 bool
+		switch encoding := r.URL.Query().Get("encoding"); encoding {
+		case "":
+		case "base64":
+			encodeBase64 = true
+		default:
+			h.WriteError(&httpError{http.StatusBadRequest, fmt.Sprintf("unsupported encoding %q", encoding)})
+			return
+		}
 
-		bw := bufio.NewWriterSize(w, 64*1024)
-		defer bw.Flush()
+		task, err := m.GetTaskStatus(h.Ctx(), taskID)
+		if err != nil {
+			h.WriteError(err)
+			return
+		}
 
-		if err := m.ProcessTask(h.Ctx(), taskID, bw); err != nil {
-			if errors.Is(err, model.ErrServerBusy) {
+		if encodeBase64 {
+			buf := &limitedBuffer{max: base64MaxSize}
+			if err := m.ProcessTask(h.Ctx(), taskID, format, buf, nil); err != nil {
+				h.WriteError(err)
+				return
+			}
+			h.WriteResponse(archiveBase64Response{
+				Name:        archiveFileName(task, ids, versionedName, format),
+				ContentType: loader.ArchiveContentType(format),
+				Size:        buf.Len(),
+				Data:        base64.StdEncoding.EncodeToString(buf.Bytes()),
+			}, http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", loader.ArchiveContentType(format))
+		w.Header().Set("Content-Disposition", contentDisposition(disposition, archiveFileName(task, ids, versionedName, format)))
+
+		var progress *archiveProgress
+		var onFile func(model.File)
+		if progressTrailers {
+			progress = &archiveProgress{total: len(task.Files)}
+			onFile = progress.add
+			w.Header().Set("Trailer", strings.Join(progressTrailerNames, ", "))
+		}
+
+		sw := &startWriter{w: w}
+		bw := bufio.NewWriterSize(sw, 64*1024)
+
+		if err := m.ProcessTask(h.Ctx(), taskID, format, bw, onFile); err != nil {
+			// пока клиенту ничего не отправлено, можно ответить ошибкой вместо архива
+			if !sw.started {
+				bw.Reset(sw) // отбрасываем начало архива
 				h.WriteError(err)
 				return
 			}
 			h.log.Error("process task failed", "error", err)
 		}
+
+		if err := bw.Flush(); err != nil {
+			h.log.Debug("write archive failed", "error", err)
+		}
+		if progress != nil {
+			progress.setTrailers(w.Header(), sw.written)
+		}
+	}
+}
+
+// Трейлеры ответа с архивом (config.API.ProgressTrailers)
+var progressTrailerNames = []string{"X-Files-Total", "X-Files-Completed", "X-Bytes-Written"}
+
+// archiveProgress считает файлы, записанные в архив, по мере их загрузки.
+type archiveProgress struct {
+	total     int          // файлы задачи
+	completed atomic.Int64 // файлы, записанные в архив
+}
+
+func (p *archiveProgress) add(file model.File) {
+	if file.Status == http.StatusOK {
+		p.completed.Add(1)
 	}
 }
 
-func GetArchive(filesBasePath string) http.HandlerFunc {
+// setTrailers заполняет трейлеры: количество файлов задачи и записанных в архив файлов
+// и количество байт архива, отправленных клиенту.
+func (p *archiveProgress) setTrailers(header http.Header, written int64) {
+	header.Set("X-Files-Total", strconv.Itoa(p.total))
+	header.Set("X-Files-Completed", strconv.FormatInt(p.completed.Load(), 10))
+	header.Set("X-Bytes-Written", strconv.FormatInt(written, 10))
+}
+
+// errArchiveTooLarge - архив не помещается в ответ ?encoding=base64.
+var errArchiveTooLarge = &httpError{http.StatusRequestEntityTooLarge, "archive too large for base64 encoding"}
+
+// limitedBuffer накапливает не больше max байт (max <= 0 - без ограничений).
+type limitedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if lb.max > 0 && int64(lb.Len()+len(p)) > lb.max {
+		return 0, errArchiveTooLarge
+	}
+	return lb.Buffer.Write(p)
+}
+
+// startWriter запоминает, началась ли запись ответа, и считает записанные байты.
+type startWriter struct {
+	w       io.Writer
+	started bool
+	written int64
+}
+
+func (sw *startWriter) Write(p []byte) (int, error) {
+	sw.started = true
+	n, err := sw.w.Write(p)
+	sw.written += int64(n)
+	return n, err
+}
+
+// Ответ на запрос корня каталога архивов (config.API.FilesIndex)
+const (
+	FilesIndexNotFound  = "not_found" // 404, как для любого неизвестного пути
+	FilesIndexInfo      = "info"      // 200 с описанием формата ссылки (неверное имя архива - 404 с описанием)
+	FilesIndexForbidden = "forbidden" // 403
+)
+
+type filesIndexResponse struct {
+	Message string `json:"message"`
+	Archive string `json:"archive"` // формат ссылки на архив
+}
+
+// GetArchive перенаправляет ссылку на архив задачи ({filesBasePath}/task_{id}.zip) на метод
+// скачивания архива. Просмотр каталога недоступен: ответ на запрос корня каталога определяет
+// filesIndex (FilesIndexNotFound, FilesIndexInfo, FilesIndexForbidden).
+func GetArchive(filesBasePath, filesIndex string, ids *taskid.Codec) http.HandlerFunc {
+	const noListing = "directory listing is not available"
+	archiveFormat := filesBasePath + "/task_{id}.zip"
+
+	// notFound отвечает на запрос неверного имени архива
+	notFound := func(w http.ResponseWriter, r *http.Request) {
+		if filesIndex != FilesIndexInfo {
+			NotFound()(w, r)
+			return
+		}
+		h := newHelper(w, r, "GetArchive")
+		h.WriteError(&httpError{http.StatusNotFound, "not found: archive link must look like " + archiveFormat})
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := logger.FromContext(r.Context())
 
+		if r.URL.Path == filesBasePath+"/" {
+			h := newHelper(w, r, "GetArchive")
+			switch filesIndex {
+			case FilesIndexInfo:
+				h.WriteResponse(filesIndexResponse{Message: noListing, Archive: archiveFormat}, http.StatusOK)
+			case FilesIndexForbidden:
+				h.WriteError(&httpError{http.StatusForbidden, noListing})
+			default:
+				NotFound()(w, r)
+			}
+			return
+		}
+
 		if dir := path.Dir(r.URL.Path); dir != filesBasePath {
 			log.Debug("invalid dir", "dir", dir)
-			http.NotFound(w, r)
+			notFound(w, r)
 			return
 		}
 		taskStr := path.Base(r.URL.Path)
 
 		if !strings.HasSuffix(taskStr, ".zip") {
 			log.Debug("must be suffix .zip", "taskStr", taskStr)
-			http.NotFound(w, r)
+			notFound(w, r)
 			return
 		}
 		taskStr = strings.TrimSuffix(taskStr, ".zip")
 
 		if !strings.HasPrefix(taskStr, "task_") {
 			log.Debug("must be prefix task_", "taskStr", taskStr)
-			http.NotFound(w, r)
+			notFound(w, r)
 			return
 		}
 		taskStr = strings.TrimPrefix(taskStr, "task_")
 
-		taskID, err := strconv.ParseInt(taskStr, 10, 64)
-		if err != nil {
+		if _, err := ids.Decode(taskStr); err != nil {
 			log.Debug("can't parse taskID", "taskID", taskStr)
-			http.NotFound(w, r)
+			notFound(w, r)
 			return
 		}
 
-		http.Redirect(w, r, fmt.Sprintf("/api/tasks/%d/archive", taskID), http.StatusTemporaryRedirect)
+		target := "/api/tasks/" + taskStr + "/archive"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery // например, ?disposition=inline
+		}
+		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
 	}
 }
@@ -0,0 +1,1209 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+	"zipget/internal/loader"
+	"zipget/internal/manager"
+	"zipget/internal/memstor"
+	"zipget/internal/model"
+	"zipget/internal/taskid"
+
+	"github.com/nalgeon/be"
+)
+
+// fakeLoader отвечает на проверку по заранее заданным статусам (по умолчанию 200).
+type fakeLoader struct {
+	status map[string]int
+	checks atomic.Int32 // количество вызовов Check (в т.ч. из Download)
+}
+
+func (l *fakeLoader) Check(ctx context.Context, urls []string) ([]model.File, error) {
+	l.checks.Add(1)
+	files := make([]model.File, len(urls))
+	for i, url := range urls {
+		files[i] = model.File{URL: url, Status: http.StatusOK, ContentType: "application/pdf"}
+		if s, ok := l.status[url]; ok {
+			files[i].Status = s
+		}
+	}
+	return files, nil
+}
+
+// Download пишет архив формата arch.Format с записью status.json, содержащей urls.
+func (l *fakeLoader) Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]model.File, error) {
+	files, err := l.Check(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := loader.NewArchiver(arch.Format, out, 0)
+	if err != nil {
+		return nil, err
+	}
+	fw, err := ar.CreateEntry(&loader.EntryHeader{Name: "status.json", Size: -1})
+	if err != nil {
+		return files, err
+	}
+	if err := json.NewEncoder(fw).Encode(urls); err != nil {
+		return files, err
+	}
+	if arch.OnFile != nil {
+		for _, f := range files {
+			arch.OnFile(f)
+		}
+	}
+	return files, ar.Close()
+}
+
+// Plan оценивает архив в 100 байт на каждый доступный файл и 22 байта на конец архива.
+func (l *fakeLoader) Plan(files []model.File) model.Preview {
+	preview := model.Preview{Size: 22}
+	for _, f := range files {
+		if f.Status == http.StatusOK {
+			preview.Size += 100
+		}
+	}
+	return preview
+}
+
+type testAPI struct {
+	*httptest.Server
+	stor *memstor.Memstor
+}
+
+func newTestAPI(t *testing.T, cfg config.API, ldr *fakeLoader) *testAPI {
+	t.Helper()
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+	t.Cleanup(stor.Cancel)
+	ids, err := taskid.New(cfg.TaskIDFormat, cfg.TaskIDKey)
+	be.Err(t, err, nil)
+	m := manager.New(config.Manager{MaxActive: 1}, stor, ldr, nil)
+	m.SetTaskIDs(ids)
+	srv := httptest.NewServer(New(cfg, m, ids, "/api", "/files", "/admin"))
+	t.Cleanup(srv.Close)
+	return &testAPI{Server: srv, stor: stor}
+}
+
+func (a *testAPI) do(t *testing.T, method, path, body string, header ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, a.URL+path, strings.NewReader(body))
+	be.Err(t, err, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	be.Err(t, err, nil)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (a *testAPI) createTask(t *testing.T, urls ...string) int64 {
+	t.Helper()
+	resp := a.do(t, "POST", "/api/tasks", "")
+	be.Equal(t, resp.StatusCode, http.StatusCreated)
+	var created createTaskResponse
+	be.Err(t, json.NewDecoder(resp.Body).Decode(&created), nil)
+	taskID, err := strconv.ParseInt(created.TaskID.String(), 10, 64)
+	be.Err(t, err, nil)
+
+	for _, url := range urls {
+		resp := a.do(t, "POST", "/api/tasks/"+itoa(taskID)+"/files", `{"url":"`+url+`"}`)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+	}
+	return taskID
+}
+
+func decode[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+	var v T
+	be.Err(t, json.NewDecoder(resp.Body).Decode(&v), nil)
+	return v
+}
+
+func itoa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func TestAdminFindTasks(t *testing.T) {
+	const token = "secret"
+	ldr := &fakeLoader{status: map[string]int{"http://example.com/404": http.StatusNotFound}}
+	a := newTestAPI(t, config.API{AdminToken: token}, ldr)
+
+	okID := a.createTask(t, "http://example.com/ok")
+	failedID := a.createTask(t, "http://example.com/ok", "http://example.com/404")
+	for _, id := range []int64{okID, failedID} {
+		be.Equal(t, a.do(t, "GET", "/api/tasks/"+itoa(id), "").StatusCode, http.StatusOK)
+	}
+
+	auth := []string{"Authorization", "Bearer " + token}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		be.Equal(t, a.do(t, "GET", "/admin/tasks", "").StatusCode, http.StatusUnauthorized)
+		be.Equal(t, a.do(t, "GET", "/admin/tasks", "", "Authorization", "Bearer wrong").StatusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("all", func(t *testing.T) {
+		resp := a.do(t, "GET", "/admin/tasks", "", auth...)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		got := decode[findTasksResponse](t, resp)
+		be.Equal(t, got.Total, 2)
+	})
+
+	t.Run("has_failed", func(t *testing.T) {
+		resp := a.do(t, "GET", "/admin/tasks?has_failed=true", "", auth...)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		got := decode[findTasksResponse](t, resp)
+		be.Equal(t, got.Total, 1)
+		be.Equal(t, got.Tasks[0].ID, failedID)
+		be.Equal(t, got.Tasks[0].Files[1].Status, http.StatusNotFound)
+	})
+
+	t.Run("by_age", func(t *testing.T) {
+		resp := a.do(t, "GET", "/admin/tasks?older_than=1h", "", auth...)
+		be.Equal(t, decode[findTasksResponse](t, resp).Total, 0)
+
+		resp = a.do(t, "GET", "/admin/tasks?newer_than=1h&limit=1", "", auth...)
+		got := decode[findTasksResponse](t, resp)
+		be.Equal(t, got.Total, 2)
+		be.Equal(t, len(got.Tasks), 1)
+	})
+
+	t.Run("bad_params", func(t *testing.T) {
+		be.Equal(t, a.do(t, "GET", "/admin/tasks?older_than=yesterday", "", auth...).StatusCode, http.StatusBadRequest)
+		be.Equal(t, a.do(t, "GET", "/admin/tasks?limit=-1", "", auth...).StatusCode, http.StatusBadRequest)
+	})
+}
+
+func TestAdminExportImport(t *testing.T) {
+	const token = "secret"
+	auth := []string{"Authorization", "Bearer " + token}
+	ldr := &fakeLoader{status: map[string]int{"http://example.com/404": http.StatusNotFound}}
+
+	src := newTestAPI(t, config.API{AdminToken: token}, ldr)
+	okID := src.createTask(t, "http://example.com/ok")
+	failedID := src.createTask(t, "http://example.com/ok", "http://example.com/404")
+	be.Equal(t, src.do(t, "GET", "/api/tasks/"+itoa(failedID), "").StatusCode, http.StatusOK)
+
+	resp := src.do(t, "GET", "/admin/tasks/export", "", auth...)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/x-ndjson")
+	dump, err := io.ReadAll(resp.Body)
+	be.Err(t, err, nil)
+	be.Equal(t, bytes.Count(dump, []byte("\n")), 2)
+
+	dst := newTestAPI(t, config.API{AdminToken: token}, ldr)
+	resp = dst.do(t, "POST", "/admin/tasks/import", string(dump), auth...)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, decode[importTasksResponse](t, resp).Imported, 2)
+
+	want, _, err := src.stor.FindTasks(context.Background(), model.TaskFilter{}, -1, 0)
+	be.Err(t, err, nil)
+	got, _, err := dst.stor.FindTasks(context.Background(), model.TaskFilter{}, -1, 0)
+	be.Err(t, err, nil)
+	be.Equal(t, len(got), len(want))
+	for i := range want {
+		be.Equal(t, got[i].ID, want[i].ID)
+		be.Equal(t, got[i].Files, want[i].Files)
+		be.True(t, got[i].CreatedAt.Equal(want[i].CreatedAt))
+		be.True(t, got[i].ExpiresAt.Equal(want[i].ExpiresAt))
+	}
+
+	// восстановленная задача доступна через API
+	resp = dst.do(t, "GET", "/api/tasks/"+itoa(okID), "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+
+	t.Run("bad_body", func(t *testing.T) {
+		resp := dst.do(t, "POST", "/admin/tasks/import", "{", auth...)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		be.Equal(t, dst.do(t, "GET", "/admin/tasks/export", "").StatusCode, http.StatusUnauthorized)
+		be.Equal(t, dst.do(t, "POST", "/admin/tasks/import", string(dump)).StatusCode, http.StatusUnauthorized)
+	})
+}
+
+func TestListTasks(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		a := newTestAPI(t, config.API{}, &fakeLoader{})
+		// путь есть только у создания задачи
+		resp := a.do(t, "GET", "/api/tasks", "")
+		be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+		be.Equal(t, resp.Header.Get("Allow"), "POST")
+	})
+
+	a := newTestAPI(t, config.API{ListTasks: true}, &fakeLoader{})
+
+	t.Run("empty", func(t *testing.T) {
+		resp := a.do(t, "GET", "/api/tasks", "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		got := decode[listTasksResponse](t, resp)
+		be.Equal(t, got.Total, 0)
+		be.Equal(t, got.Tasks, []taskSummary{})
+	})
+
+	var ids []int64
+	for i := range 5 {
+		urls := make([]string, i)
+		for j := range urls {
+			urls[j] = "http://example.com/" + strconv.Itoa(j)
+		}
+		ids = append(ids, a.createTask(t, urls...))
+		time.Sleep(time.Millisecond) // различное время создания
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var got []int64
+		for offset := 0; offset < 6; offset += 2 {
+			resp := a.do(t, "GET", "/api/tasks?limit=2&offset="+strconv.Itoa(offset), "")
+			be.Equal(t, resp.StatusCode, http.StatusOK)
+			page := decode[listTasksResponse](t, resp)
+			be.Equal(t, page.Total, 5)
+			be.True(t, len(page.Tasks) <= 2)
+			for _, task := range page.Tasks {
+				id, err := strconv.ParseInt(task.ID.String(), 10, 64)
+				be.Err(t, err, nil)
+				be.Equal(t, task.Files, slices.Index(ids, id))
+				be.True(t, task.ExpiresAt.After(task.CreatedAt))
+				got = append(got, id)
+			}
+		}
+
+		// от новых к старым
+		want := slices.Clone(ids)
+		slices.Reverse(want)
+		be.Equal(t, got, want)
+	})
+
+	t.Run("bad_params", func(t *testing.T) {
+		be.Equal(t, a.do(t, "GET", "/api/tasks?limit=-1", "").StatusCode, http.StatusBadRequest)
+		be.Equal(t, a.do(t, "GET", "/api/tasks?offset=x", "").StatusCode, http.StatusBadRequest)
+	})
+}
+
+func TestAdminCleanup(t *testing.T) {
+	const token = "secret"
+	auth := []string{"Authorization", "Bearer " + token}
+	a := newTestAPI(t, config.API{AdminToken: token}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/ok")
+
+	resp := a.do(t, "POST", "/admin/cleanup", "", auth...)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, decode[cleanupResponse](t, resp).Removed, 0)
+
+	// действующая задача не удаляется
+	be.Equal(t, a.do(t, "GET", "/api/tasks/"+itoa(taskID), "").StatusCode, http.StatusOK)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		be.Equal(t, a.do(t, "POST", "/admin/cleanup", "").StatusCode, http.StatusUnauthorized)
+	})
+}
+
+func TestAdminDisabled(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	resp := a.do(t, "GET", "/admin/tasks", "", "Authorization", "Bearer ")
+	be.Equal(t, resp.StatusCode, http.StatusNotFound)
+}
+
+func TestNotFound(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+
+	for _, path := range []string{"/bogus", "/api/bogus", "/files/bogus.zip", "/api/tasks/1/bogus"} {
+		resp := a.do(t, "GET", path, "")
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		be.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+		be.Equal(t, decode[errorResponse](t, resp).Error, "not found")
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"PUT", "/api/tasks", "POST"},
+		{"PATCH", "/api/tasks/1", "GET, HEAD, DELETE"},
+		{"POST", "/api/tasks/1/archive", "GET, HEAD"},
+		{"DELETE", "/files/task_1.zip", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		resp := a.do(t, tt.method, tt.path, "")
+		be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+		be.Equal(t, resp.Header.Get("Allow"), tt.allow)
+		be.Equal(t, decode[errorResponse](t, resp).Error, "method not allowed")
+	}
+
+	// путь без маршрутов - 404 при любом методе
+	resp := a.do(t, "PUT", "/api/bogus", "")
+	be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	be.Equal(t, resp.Header.Get("Allow"), "")
+}
+
+func TestFilesIndex(t *testing.T) {
+	const format = "/files/task_{id}.zip"
+
+	tests := []struct {
+		name      string
+		mode      string
+		path      string
+		wantCode  int
+		wantError string
+	}{
+		{"default_root", "", "/files/", http.StatusNotFound, "not found"},
+		{"default_junk", "", "/files/junk", http.StatusNotFound, "not found"},
+		{"not_found_root", FilesIndexNotFound, "/files/", http.StatusNotFound, "not found"},
+		{"info_root", FilesIndexInfo, "/files/", http.StatusOK, ""},
+		{"info_junk", FilesIndexInfo, "/files/junk", http.StatusNotFound, "not found: archive link must look like " + format},
+		{"info_bad_id", FilesIndexInfo, "/files/task_x.zip", http.StatusNotFound, "not found: archive link must look like " + format},
+		{"forbidden_root", FilesIndexForbidden, "/files/", http.StatusForbidden, "directory listing is not available"},
+		{"forbidden_junk", FilesIndexForbidden, "/files/junk", http.StatusNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t, config.API{FilesIndex: tt.mode}, &fakeLoader{})
+			resp := a.do(t, "GET", tt.path, "")
+			be.Equal(t, resp.StatusCode, tt.wantCode)
+			be.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+			if tt.wantCode == http.StatusOK {
+				be.Equal(t, decode[filesIndexResponse](t, resp), filesIndexResponse{
+					Message: "directory listing is not available",
+					Archive: format,
+				})
+			} else {
+				be.Equal(t, decode[errorResponse](t, resp).Error, tt.wantError)
+			}
+		})
+	}
+
+	// правильная ссылка на архив перенаправляется на скачивание при любом режиме
+	for _, mode := range []string{FilesIndexNotFound, FilesIndexInfo, FilesIndexForbidden} {
+		t.Run("archive_"+mode, func(t *testing.T) {
+			a := newTestAPI(t, config.API{FilesIndex: mode}, &fakeLoader{})
+			taskID := a.createTask(t, "http://example.com/a")
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			resp, err := client.Get(a.URL + "/files/task_" + itoa(taskID) + ".zip")
+			be.Err(t, err, nil)
+			defer resp.Body.Close()
+			be.Equal(t, resp.StatusCode, http.StatusTemporaryRedirect)
+			be.Equal(t, resp.Header.Get("Location"), "/api/tasks/"+itoa(taskID)+"/archive")
+		})
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+
+	resp := a.do(t, "GET", "/api/tasks/100500", "")
+	be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+	be.Equal(t, decode[errorResponse](t, resp).Error, model.ErrTaskNotFound.Error())
+}
+
+func TestProcessTask_StableFileName(t *testing.T) {
+	archiveName := func(t *testing.T, a *testAPI, taskID int64) string {
+		t.Helper()
+		resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		io.Copy(io.Discard, resp.Body)
+		return resp.Header.Get("Content-Disposition")
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		a := newTestAPI(t, config.API{}, &fakeLoader{})
+		taskID := a.createTask(t, "http://example.com/1.pdf")
+		want := `attachment; filename="task_` + itoa(taskID) + `.zip"`
+		be.Equal(t, archiveName(t, a, taskID), want)
+		be.Equal(t, archiveName(t, a, taskID), want)
+	})
+
+	t.Run("versioned", func(t *testing.T) {
+		a := newTestAPI(t, config.API{VersionedArchiveName: true}, &fakeLoader{})
+		taskID := a.createTask(t, "http://example.com/1.pdf")
+
+		first := archiveName(t, a, taskID)
+		be.True(t, strings.HasPrefix(first, `attachment; filename="task_`+itoa(taskID)+"-"))
+		be.Equal(t, archiveName(t, a, taskID), first)
+
+		resp := a.do(t, "POST", "/api/tasks/"+itoa(taskID)+"/files", `{"url":"http://example.com/2.pdf"}`)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		be.True(t, archiveName(t, a, taskID) != first)
+	})
+}
+
+func TestTrailingSlash(t *testing.T) {
+	const token = "secret"
+
+	type route struct {
+		method, path, body string
+		want               int
+	}
+	routes := func(taskID int64) []route {
+		id := itoa(taskID)
+		return []route{
+			{"POST", "/api/tasks", "", http.StatusCreated},
+			{"POST", "/api/tasks/" + id + "/files", `{"url":"http://example.com/1.pdf"}`, http.StatusOK},
+			{"GET", "/api/tasks/" + id, "", http.StatusOK},
+			{"POST", "/api/tasks/" + id + "/prepare", "", http.StatusOK},
+			{"GET", "/api/tasks/" + id + "/archive", "", http.StatusOK},
+			{"GET", "/api/ping", "", http.StatusOK},
+			{"GET", "/admin/tasks", "", http.StatusOK},
+			{"DELETE", "/api/tasks/" + id, "", http.StatusOK},
+		}
+	}
+
+	for _, mode := range []string{TrailingSlashStrict, TrailingSlashMatch, TrailingSlashRedirect} {
+		t.Run(mode, func(t *testing.T) {
+			a := newTestAPI(t, config.API{AdminToken: token, TrailingSlash: mode}, &fakeLoader{})
+			auth := []string{"Authorization", "Bearer " + token}
+
+			// канонические пути работают всегда
+			for _, r := range routes(a.createTask(t)) {
+				resp := a.do(t, r.method, r.path, r.body, auth...)
+				be.Equal(t, resp.StatusCode, r.want)
+			}
+
+			// пути со слешем - в зависимости от режима (клиент следует перенаправлениям)
+			for _, r := range routes(a.createTask(t)) {
+				want := r.want
+				if mode == TrailingSlashStrict {
+					want = http.StatusNotFound
+				}
+				resp := a.do(t, r.method, r.path+"/", r.body, auth...)
+				be.Equal(t, resp.StatusCode, want)
+			}
+		})
+	}
+}
+
+func TestTrailingSlash_RedirectLocation(t *testing.T) {
+	a := newTestAPI(t, config.API{TrailingSlash: TrailingSlashRedirect}, &fakeLoader{})
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Post(a.URL+"/api/tasks/?x=1", "application/json", nil)
+	be.Err(t, err, nil)
+	defer resp.Body.Close()
+	be.Equal(t, resp.StatusCode, http.StatusPermanentRedirect)
+	be.Equal(t, resp.Header.Get("Location"), "/api/tasks?x=1")
+}
+
+func TestProcessTask_Format(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/a", "http://example.com/b")
+	path := "/api/tasks/" + itoa(taskID) + "/archive"
+
+	tests := []struct {
+		name     string
+		query    string
+		accept   string
+		wantType string
+		wantExt  string
+		wantVary string
+	}{
+		{"default", "", "", "application/zip", ".zip", "Accept"},
+		{"query_targz", "?format=targz", "application/zip", "application/gzip", ".tar.gz", ""},
+		{"query_zip", "?format=zip", "application/gzip", "application/zip", ".zip", ""},
+		{"accept_gzip", "", "application/gzip", "application/gzip", ".tar.gz", "Accept"},
+		{"accept_first_known", "", "text/html, application/x-gtar;q=0.9, application/zip", "application/gzip", ".tar.gz", "Accept"},
+		{"accept_any", "", "*/*", "application/zip", ".zip", "Accept"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := a.do(t, "GET", path+tt.query, "", "Accept", tt.accept)
+			be.Equal(t, resp.StatusCode, http.StatusOK)
+			be.Equal(t, resp.Header.Get("Content-Type"), tt.wantType)
+			be.Equal(t, resp.Header.Get("Content-Disposition"), `attachment; filename="task_`+itoa(taskID)+tt.wantExt+`"`)
+			be.Equal(t, resp.Header.Get("Vary"), tt.wantVary)
+
+			data, err := io.ReadAll(resp.Body)
+			be.Err(t, err, nil)
+			var status string
+			if tt.wantExt == ".zip" {
+				zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				be.Err(t, err, nil)
+				rc, err := zr.File[0].Open()
+				be.Err(t, err, nil)
+				b, _ := io.ReadAll(rc)
+				status = string(b)
+			} else {
+				gz, err := gzip.NewReader(bytes.NewReader(data))
+				be.Err(t, err, nil)
+				tr := tar.NewReader(gz)
+				th, err := tr.Next()
+				be.Err(t, err, nil)
+				be.Equal(t, th.Name, "status.json")
+				b, _ := io.ReadAll(tr)
+				status = string(b)
+			}
+			be.True(t, strings.Contains(status, "http://example.com/a"))
+		})
+	}
+
+	// base64: тип и имя архива в JSON
+	resp := a.do(t, "GET", path+"?format=targz&encoding=base64", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	got := decode[archiveBase64Response](t, resp)
+	be.Equal(t, got.ContentType, "application/gzip")
+	be.Equal(t, got.Name, "task_"+itoa(taskID)+".tar.gz")
+
+	// HEAD: размер оценивается только для ZIP
+	resp = a.do(t, "HEAD", path+"?format=targz", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/gzip")
+	be.Equal(t, resp.ContentLength, int64(-1))
+
+	// неизвестный формат
+	be.Equal(t, a.do(t, "GET", path+"?format=rar", "").StatusCode, http.StatusBadRequest)
+	be.Equal(t, a.do(t, "HEAD", path+"?format=rar", "").StatusCode, http.StatusBadRequest)
+}
+
+func TestArchiveSize(t *testing.T) {
+	ldr := &fakeLoader{status: map[string]int{"http://example.com/404": http.StatusNotFound}}
+	a := newTestAPI(t, config.API{}, ldr)
+	taskID := a.createTask(t, "http://example.com/a", "http://example.com/404", "http://example.com/b")
+	path := "/api/tasks/" + itoa(taskID) + "/archive"
+
+	head := func(t *testing.T, path string) *http.Response {
+		t.Helper()
+		resp := a.do(t, "HEAD", path, "")
+		body, err := io.ReadAll(resp.Body)
+		be.Err(t, err, nil)
+		be.Equal(t, len(body), 0)
+		return resp
+	}
+
+	// файлы ещё не проверены: только служебные структуры
+	resp := head(t, path)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.ContentLength, int64(22))
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/zip")
+
+	// после проверки учитываются доступные файлы
+	be.Equal(t, a.do(t, "GET", "/api/tasks/"+itoa(taskID), "").StatusCode, http.StatusOK)
+	resp = head(t, path)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.ContentLength, int64(2*100+22))
+
+	be.Equal(t, head(t, "/api/tasks/999/archive").StatusCode, http.StatusNotFound)
+	be.Equal(t, head(t, "/api/tasks/x/archive").StatusCode, http.StatusBadRequest)
+}
+
+func TestGetTaskStatus_ArchiveReady(t *testing.T) {
+	ldr := &fakeLoader{status: map[string]int{
+		"http://example.com/404":     http.StatusNotFound,
+		"http://example.com/blocked": http.StatusForbidden,
+		"http://example.com/502":     http.StatusBadGateway,
+	}}
+	a := newTestAPI(t, config.API{ArchiveLinkFiles: 3}, ldr)
+
+	tests := []struct {
+		name  string
+		urls  []string
+		valid int
+		ready bool
+	}{
+		{"not_enough_files", []string{"http://example.com/a", "http://example.com/b"}, 2, false},
+		{"all_failed", []string{"http://example.com/404", "http://example.com/blocked", "http://example.com/502"}, 0, false},
+		{"one_valid", []string{"http://example.com/404", "http://example.com/a", "http://example.com/blocked"}, 1, true},
+		{"all_valid", []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := a.createTask(t, tt.urls...)
+			resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID), "")
+			be.Equal(t, resp.StatusCode, http.StatusOK)
+			got := decode[getTaskStatusResponse](t, resp)
+			be.Equal(t, got.ValidFiles, tt.valid)
+			be.Equal(t, got.Ready, tt.ready)
+			if tt.ready {
+				be.Equal(t, got.Archive, "/files/task_"+itoa(taskID)+".zip")
+			} else {
+				be.Equal(t, got.Archive, "")
+			}
+		})
+	}
+}
+
+func TestGetTaskStatus_FilesPagination(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+
+	urls := make([]string, 7)
+	for i := range urls {
+		urls[i] = "http://example.com/" + strconv.Itoa(i) + ".pdf"
+	}
+	taskID := a.createTask(t, urls...)
+
+	// постранично
+	var got []string
+	for offset := 0; offset < len(urls); offset += 3 {
+		resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"?files_limit=3&files_offset="+strconv.Itoa(offset), "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		page := decode[getTaskStatusResponse](t, resp)
+		be.Equal(t, page.FilesTotal, len(urls))
+		be.True(t, page.Archive != "")
+		be.True(t, len(page.Task.Files) <= 3)
+		for _, f := range page.Task.Files {
+			got = append(got, f.URL)
+		}
+	}
+	be.Equal(t, got, urls)
+
+	// без параметров - все файлы
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID), "")
+	be.Equal(t, len(decode[getTaskStatusResponse](t, resp).Task.Files), len(urls))
+
+	// за пределами списка
+	resp = a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"?files_offset=100", "")
+	page := decode[getTaskStatusResponse](t, resp)
+	be.Equal(t, len(page.Task.Files), 0)
+	be.Equal(t, page.FilesTotal, len(urls))
+
+	for _, query := range []string{"files_limit=-1", "files_offset=-1", "files_limit=x"} {
+		resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"?"+query, "")
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	const blocked = "http://127.0.0.1/secret.pdf"
+	a := newTestAPI(t, config.API{}, &fakeLoader{status: map[string]int{blocked: http.StatusForbidden}})
+
+	resp := a.do(t, "POST", "/api/check", `{"url":"http://example.com/1.pdf"}`)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	file := decode[checkURLResponse](t, resp).File
+	be.Equal(t, file.URL, "http://example.com/1.pdf")
+	be.Equal(t, file.Status, http.StatusOK)
+
+	resp = a.do(t, "POST", "/api/check", `{"url":"`+blocked+`"}`)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, decode[checkURLResponse](t, resp).File.Status, http.StatusForbidden)
+
+	resp = a.do(t, "POST", "/api/check", `{}`)
+	be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	// задачи не создаются
+	tasks, total, err := a.stor.FindTasks(context.Background(), model.TaskFilter{}, -1, 0)
+	be.Err(t, err, nil)
+	be.Equal(t, len(tasks), 0)
+	be.Equal(t, total, 0)
+}
+
+func TestCheckURL_RateLimited(t *testing.T) {
+	a := newTestAPI(t, config.API{CheckRate: 1, CheckBurst: 2}, &fakeLoader{})
+
+	for range 2 {
+		resp := a.do(t, "POST", "/api/check", `{"url":"http://example.com/1.pdf"}`)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+	}
+
+	resp := a.do(t, "POST", "/api/check", `{"url":"http://example.com/1.pdf"}`)
+	be.Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+	be.Equal(t, decode[errorResponse](t, resp).Error, model.ErrRateLimited.Error())
+}
+
+func TestCreateTask_Body(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+
+	resp := a.do(t, "POST", "/api/tasks", `{}`)
+	be.Equal(t, resp.StatusCode, http.StatusCreated)
+
+	resp = a.do(t, "POST", "/api/tasks", `{`)
+	be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	// уведомления в тестовом сервере отключены
+	resp = a.do(t, "POST", "/api/tasks", `{"callback_url":"https://example.com/hook"}`)
+	be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	be.True(t, strings.HasPrefix(decode[errorResponse](t, resp).Error, model.ErrInvalidCallback.Error()))
+}
+
+func TestGetTaskStatus_MaskQuery(t *testing.T) {
+	const presigned = "http://example.com/a.pdf?X-Amz-Expires=300&X-Amz-Signature=abc123"
+	a := newTestAPI(t, config.API{MaskQueryParams: []string{"x-amz-signature"}}, &fakeLoader{})
+	taskID := a.createTask(t, presigned)
+
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID), "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	files := decode[getTaskStatusResponse](t, resp).Task.Files
+	be.Equal(t, files[0].URL, "http://example.com/a.pdf?X-Amz-Expires=300&X-Amz-Signature=REDACTED")
+
+	resp = a.do(t, "POST", "/api/check", `{"url":"`+presigned+`"}`)
+	be.Equal(t, decode[checkURLResponse](t, resp).File.URL, "http://example.com/a.pdf?X-Amz-Expires=300&X-Amz-Signature=REDACTED")
+
+	// в задаче хранится исходный URL
+	stored, err := a.stor.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, stored[0].URL, presigned)
+}
+
+func TestGetTaskStatus_EmptyFiles(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	taskID := a.createTask(t)
+
+	for _, path := range []string{"/api/tasks/" + itoa(taskID), "/api/tasks/" + itoa(taskID) + "?files_offset=100"} {
+		resp := a.do(t, "GET", path, "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		body, err := io.ReadAll(resp.Body)
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(string(body), `"files":[]`))
+	}
+}
+
+func TestProcessTask_ProgressTrailers(t *testing.T) {
+	ldr := &fakeLoader{status: map[string]int{"http://example.com/404": http.StatusNotFound}}
+
+	t.Run("enabled", func(t *testing.T) {
+		a := newTestAPI(t, config.API{ProgressTrailers: true}, ldr)
+		taskID := a.createTask(t, "http://example.com/ok", "http://example.com/404")
+
+		resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_, declared := resp.Trailer["X-Bytes-Written"] // объявлен до тела ответа
+		be.True(t, declared)
+		body, err := io.ReadAll(resp.Body)
+		be.Err(t, err, nil)
+
+		be.Equal(t, resp.Trailer.Get("X-Files-Total"), "2")
+		be.Equal(t, resp.Trailer.Get("X-Files-Completed"), "1")
+		be.Equal(t, resp.Trailer.Get("X-Bytes-Written"), strconv.Itoa(len(body)))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		a := newTestAPI(t, config.API{}, ldr)
+		taskID := a.createTask(t, "http://example.com/ok")
+
+		resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_, err := io.ReadAll(resp.Body)
+		be.Err(t, err, nil)
+		be.Equal(t, len(resp.Trailer), 0)
+	})
+}
+
+func TestProcessTask_NotEnoughFiles(t *testing.T) {
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+	t.Cleanup(stor.Cancel)
+	m := manager.New(config.Manager{MaxActive: 1, MinFiles: 2}, stor, &fakeLoader{}, nil)
+	srv := httptest.NewServer(New(config.API{}, m, nil, "/api", "/files", "/admin"))
+	t.Cleanup(srv.Close)
+	a := &testAPI{Server: srv, stor: stor}
+
+	taskID := a.createTask(t, "http://example.com/1.pdf")
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+	be.Equal(t, resp.StatusCode, http.StatusUnprocessableEntity)
+	be.Equal(t, resp.Header.Get("Content-Disposition"), "")
+	be.True(t, strings.HasPrefix(decode[errorResponse](t, resp).Error, model.ErrNotEnoughFiles.Error()))
+}
+
+func TestProcessTask_EmptyTask(t *testing.T) {
+	tests := []struct {
+		mode string
+		want int
+	}{
+		{manager.EmptyTaskBuild, http.StatusOK},
+		{manager.EmptyTaskNotFound, http.StatusNotFound},
+		{manager.EmptyTaskUnprocessable, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+			t.Cleanup(stor.Cancel)
+			// MinFiles по умолчанию не мешает собрать пустую задачу в режиме build
+			m := manager.New(config.Manager{MaxActive: 1, MinFiles: 1, EmptyTask: tt.mode}, stor, &fakeLoader{}, nil)
+			srv := httptest.NewServer(New(config.API{}, m, nil, "/api", "/files", "/admin"))
+			t.Cleanup(srv.Close)
+			a := &testAPI{Server: srv, stor: stor}
+
+			taskID := a.createTask(t)
+			resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+			be.Equal(t, resp.StatusCode, tt.want)
+			if tt.want == http.StatusOK {
+				be.Equal(t, resp.Header.Get("Content-Type"), "application/zip")
+			} else {
+				be.Equal(t, resp.Header.Get("Content-Disposition"), "")
+			}
+		})
+	}
+}
+
+func TestProcessTask_Base64(t *testing.T) {
+	a := newTestAPI(t, config.API{Base64MaxSize: 10 << 10}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/1.pdf")
+
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive?encoding=base64", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+	be.Equal(t, resp.Header.Get("Content-Disposition"), "")
+
+	archive := decode[archiveBase64Response](t, resp)
+	be.Equal(t, archive.Name, "task_"+itoa(taskID)+".zip")
+	data, err := base64.StdEncoding.DecodeString(archive.Data)
+	be.Err(t, err, nil)
+	be.Equal(t, archive.Size, len(data))
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	be.Err(t, err, nil)
+	be.Equal(t, zr.File[0].Name, "status.json")
+
+	resp = a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive?encoding=hex", "")
+	be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+}
+
+func TestProcessTask_Base64TooLarge(t *testing.T) {
+	a := newTestAPI(t, config.API{Base64MaxSize: 64}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/1.pdf")
+
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive?encoding=base64", "")
+	be.Equal(t, resp.StatusCode, http.StatusRequestEntityTooLarge)
+	be.Equal(t, decode[errorResponse](t, resp).Error, errArchiveTooLarge.StatusMsg)
+
+	// без кодирования архив отдаётся как обычно
+	resp = a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/zip")
+}
+
+func TestProcessTask_Base64StopsDownload(t *testing.T) {
+	// источник отдаёт большой файл медленно, пока его читают
+	var sent atomic.Int64
+	handlers := make(chan struct{}, 10)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { handlers <- struct{}{} }()
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Length", strconv.Itoa(9+64<<20))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write([]byte("%PDF-1.4\n"))
+		// случайные данные больше окна deflate: архив растёт вместе с загруженным
+		chunk := make([]byte, 64<<10)
+		rand.Read(chunk)
+		for range 1 << 10 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			sent.Add(int64(len(chunk)))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	defer origin.Close()
+
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+	t.Cleanup(stor.Cancel)
+	ldr := loader.New(http.DefaultClient, config.Loader{AllowMIMETypes: []string{"application/pdf"}})
+	m := manager.New(config.Manager{MaxActive: 1}, stor, ldr, nil)
+	srv := httptest.NewServer(New(config.API{Base64MaxSize: 1 << 10}, m, nil, "/api", "/files", "/admin"))
+	t.Cleanup(srv.Close)
+	a := &testAPI{Server: srv, stor: stor}
+	taskID := a.createTask(t, origin.URL+"/big.pdf")
+
+	resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive?encoding=base64", "")
+	be.Equal(t, resp.StatusCode, http.StatusRequestEntityTooLarge)
+
+	// сборка прекращена: источник больше не читается (проверка статуса и загрузка - два запроса)
+	for range 2 {
+		select {
+		case <-handlers:
+		case <-time.After(5 * time.Second):
+			t.Fatal("origin is still read after the size limit was hit")
+		}
+	}
+	be.True(t, sent.Load() < 8<<20)
+}
+
+func TestProcessTask_Disposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		dflt    string
+		query   string
+		status  int
+		wantHdr string
+	}{
+		{"default", "", "", http.StatusOK, "attachment"},
+		{"inline", "", "?disposition=inline", http.StatusOK, "inline"},
+		{"config inline", DispositionInline, "", http.StatusOK, "inline"},
+		{"override config", DispositionInline, "?disposition=attachment", http.StatusOK, "attachment"},
+		{"invalid", "", "?disposition=preview", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t, config.API{ArchiveDisposition: tt.dflt}, &fakeLoader{})
+			taskID := a.createTask(t, "http://example.com/1.pdf")
+
+			resp := a.do(t, "GET", "/api/tasks/"+itoa(taskID)+"/archive"+tt.query, "")
+			be.Equal(t, resp.StatusCode, tt.status)
+			want := ""
+			if tt.wantHdr != "" {
+				want = tt.wantHdr + `; filename="task_` + itoa(taskID) + `.zip"`
+			}
+			be.Equal(t, resp.Header.Get("Content-Disposition"), want)
+		})
+	}
+
+	// ссылка на архив передаёт параметры запроса
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/1.pdf")
+	resp := a.do(t, "GET", "/files/task_"+itoa(taskID)+".zip?disposition=inline", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.True(t, strings.HasPrefix(resp.Header.Get("Content-Disposition"), "inline;"))
+}
+
+func TestProcessTask_ArchiveName(t *testing.T) {
+	tests := []struct {
+		name      string
+		taskName  string
+		versioned bool
+		want      string // {id} - ID задачи
+	}{
+		{"default", "", false, `attachment; filename="task_{id}.zip"`},
+		{"named", "invoices-2025.zip", false, `attachment; filename="invoices-2025.zip"`},
+		{"without_ext", "invoices 2025", false, `attachment; filename="invoices-2025.zip"`},
+		{"header_injection", "x.zip\"\r\nSet-Cookie: a=b", false, `attachment; filename="x-zip-Set-Cookie-a=b.zip"`},
+		{"non_ascii", "счета", false, `attachment; filename="_____.zip"; filename*=UTF-8''%D1%81%D1%87%D0%B5%D1%82%D0%B0.zip`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t, config.API{}, &fakeLoader{})
+			body, err := json.Marshal(createTaskRequest{Name: tt.taskName})
+			be.Err(t, err, nil)
+			resp := a.do(t, "POST", "/api/tasks", string(body))
+			be.Equal(t, resp.StatusCode, http.StatusCreated)
+			taskID := decode[createTaskResponse](t, resp).TaskID.String()
+			want := strings.ReplaceAll(tt.want, "{id}", taskID)
+
+			// и напрямую, и по ссылке на архив
+			for _, path := range []string{"/api/tasks/" + taskID + "/archive", "/files/task_" + taskID + ".zip"} {
+				resp := a.do(t, "GET", path, "")
+				be.Equal(t, resp.StatusCode, http.StatusOK)
+				be.Equal(t, resp.Header.Get("Content-Disposition"), want)
+			}
+		})
+	}
+
+	t.Run("versioned", func(t *testing.T) {
+		a := newTestAPI(t, config.API{VersionedArchiveName: true}, &fakeLoader{})
+		resp := a.do(t, "POST", "/api/tasks", `{"name":"invoices"}`)
+		taskID := decode[createTaskResponse](t, resp).TaskID.String()
+		resp = a.do(t, "GET", "/api/tasks/"+taskID+"/archive", "")
+		be.True(t, strings.HasPrefix(resp.Header.Get("Content-Disposition"), `attachment; filename="invoices-`))
+
+		// имя возвращается в статусе задачи
+		resp = a.do(t, "GET", "/api/tasks/"+taskID, "")
+		be.Equal(t, decode[getTaskStatusResponse](t, resp).Task.Name, "invoices")
+	})
+}
+
+func TestOpaqueTaskIDs(t *testing.T) {
+	const key = "000102030405060708090a0b0c0d0e0f"
+	a := newTestAPI(t, config.API{TaskIDFormat: taskid.FormatOpaque, TaskIDKey: key, ListTasks: true}, &fakeLoader{})
+
+	// создание: ID - строка
+	resp := a.do(t, "POST", "/api/tasks", "")
+	be.Equal(t, resp.StatusCode, http.StatusCreated)
+	var raw map[string]any
+	be.Err(t, json.NewDecoder(resp.Body).Decode(&raw), nil)
+	id, ok := raw["task_id"].(string)
+	be.True(t, ok)
+	be.Equal(t, len(id), 22)
+
+	ids, err := taskid.New(taskid.FormatOpaque, key)
+	be.Err(t, err, nil)
+	numericID, err := ids.Decode(id)
+	be.Err(t, err, nil)
+
+	for _, url := range []string{"http://example.com/1.pdf", "http://example.com/2.pdf", "http://example.com/3.pdf"} {
+		resp := a.do(t, "POST", "/api/tasks/"+id+"/files", `{"url":"`+url+`"}`)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+	}
+
+	// статус: числовой ID не раскрывается, ссылка на архив - с непрозрачным ID
+	resp = a.do(t, "GET", "/api/tasks/"+id, "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	status := decode[getTaskStatusResponse](t, resp)
+	be.Equal(t, status.Task.ID, int64(0))
+	be.Equal(t, len(status.Task.Files), 3)
+	be.Equal(t, status.Archive, "/files/task_"+id+".zip")
+
+	// список задач
+	resp = a.do(t, "GET", "/api/tasks", "")
+	list := decode[listTasksResponse](t, resp)
+	be.Equal(t, len(list.Tasks), 1)
+	be.Equal(t, list.Tasks[0].ID.String(), id)
+
+	// архив: напрямую, по ссылке и HEAD
+	resp = a.do(t, "GET", "/api/tasks/"+id+"/archive", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Disposition"), `attachment; filename="task_`+id+`.zip"`)
+	resp = a.do(t, "GET", status.Archive, "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "application/zip")
+	be.Equal(t, a.do(t, "HEAD", "/api/tasks/"+id+"/archive", "").StatusCode, http.StatusOK)
+
+	// числовой и подделанный ID не принимаются
+	forged := []byte(id)
+	forged[0] = map[bool]byte{true: '1', false: '0'}[forged[0] == '0']
+	for _, bad := range []string{itoa(numericID), string(forged), "junk"} {
+		resp := a.do(t, "GET", "/api/tasks/"+bad, "")
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		resp = a.do(t, "GET", "/files/task_"+bad+".zip", "")
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	}
+
+	// удаление
+	be.Equal(t, a.do(t, "DELETE", "/api/tasks/"+id, "").StatusCode, http.StatusOK)
+	be.Equal(t, a.do(t, "GET", "/api/tasks/"+id, "").StatusCode, http.StatusNotFound)
+}
+
+func TestGetTaskStatus_ConditionalGet(t *testing.T) {
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	taskID := a.createTask(t, "http://example.com/1.pdf")
+	path := "/api/tasks/" + itoa(taskID)
+
+	resp := a.do(t, "GET", path, "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	be.True(t, etag != "")
+
+	// задача не менялась
+	resp = a.do(t, "GET", path, "", "If-None-Match", etag)
+	be.Equal(t, resp.StatusCode, http.StatusNotModified)
+	be.Equal(t, resp.Header.Get("ETag"), etag)
+	body, err := io.ReadAll(resp.Body)
+	be.Err(t, err, nil)
+	be.Equal(t, len(body), 0)
+
+	resp = a.do(t, "GET", path, "", "If-None-Match", `"other", W/`+etag)
+	be.Equal(t, resp.StatusCode, http.StatusNotModified)
+
+	// другое представление (страница файлов) имеет свой ETag
+	resp = a.do(t, "GET", path+"?files_limit=0", "", "If-None-Match", etag)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+
+	// добавление файла меняет ETag
+	resp = a.do(t, "POST", path+"/files", `{"url":"http://example.com/2.pdf"}`)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	resp = a.do(t, "GET", path, "", "If-None-Match", etag)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.True(t, resp.Header.Get("ETag") != etag)
+	be.Equal(t, len(decode[getTaskStatusResponse](t, resp).Task.Files), 2)
+}
+
+func TestGetTaskStatus_ConditionalSkipsRecheck(t *testing.T) {
+	ldr := &fakeLoader{status: map[string]int{"http://example.com/bad.pdf": http.StatusBadGateway}}
+	a := newTestAPI(t, config.API{}, ldr)
+	taskID := a.createTask(t, "http://example.com/bad.pdf")
+	path := "/api/tasks/" + itoa(taskID)
+
+	resp := a.do(t, "GET", path, "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	be.Equal(t, ldr.checks.Load(), int32(1))
+
+	// клиент уже получил это состояние: 304 без повторной проверки файла с ошибкой 502
+	resp = a.do(t, "GET", path, "", "If-None-Match", etag)
+	be.Equal(t, resp.StatusCode, http.StatusNotModified)
+	be.Equal(t, resp.Header.Get("ETag"), etag)
+	be.Equal(t, ldr.checks.Load(), int32(1))
+
+	// без If-None-Match файл перепроверяется, но тот же результат не меняет ETag
+	resp = a.do(t, "GET", path, "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, ldr.checks.Load(), int32(2))
+	be.Equal(t, resp.Header.Get("ETag"), etag)
+
+	// устаревший ETag - файл перепроверяется, результат изменился
+	delete(ldr.status, "http://example.com/bad.pdf")
+	resp = a.do(t, "GET", path, "", "If-None-Match", `"stale"`)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, ldr.checks.Load(), int32(3))
+	be.True(t, resp.Header.Get("ETag") != etag)
+	be.Equal(t, decode[getTaskStatusResponse](t, resp).ValidFiles, 1)
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantASCII string
+		wantExt   string // filename* (пусто - не добавляется)
+	}{
+		{"task_1.zip", "task_1.zip", ""},
+		{"отчёт 2025.zip", "_____ 2025.zip", "UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82%202025.zip"},
+		{`a"b\c.zip`, "a_b_c.zip", `UTF-8''a%22b%5Cc.zip`},
+	}
+	for _, tt := range tests {
+		hdr := contentDisposition(DispositionAttachment, tt.name)
+
+		want := `attachment; filename="` + tt.wantASCII + `"`
+		if tt.wantExt != "" {
+			want += "; filename*=" + tt.wantExt
+		}
+		be.Equal(t, hdr, want)
+
+		// разбор заголовка возвращает исходное имя
+		disposition, params, err := mime.ParseMediaType(hdr)
+		be.Err(t, err, nil)
+		be.Equal(t, disposition, DispositionAttachment)
+		be.Equal(t, params["filename"], tt.name)
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	// по умолчанию интерфейс отключён
+	a := newTestAPI(t, config.API{}, &fakeLoader{})
+	for _, path := range []string{"/ui/", "/ui/app.js"} {
+		resp := a.do(t, "GET", path, "")
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	}
+
+	a = newTestAPI(t, config.API{UIPath: "/ui/"}, &fakeLoader{})
+
+	// страница знает путь API
+	resp := a.do(t, "GET", "/ui/", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Header.Get("Content-Type"), "text/html; charset=utf-8")
+	body, err := io.ReadAll(resp.Body)
+	be.Err(t, err, nil)
+	be.True(t, strings.Contains(string(body), `data-api="/api"`))
+
+	resp = a.do(t, "GET", "/ui/app.js", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/javascript"))
+
+	resp = a.do(t, "GET", "/ui/missing.js", "")
+	be.Equal(t, resp.StatusCode, http.StatusNotFound)
+
+	// путь без слеша перенаправляется на корень интерфейса
+	resp = a.do(t, "GET", "/ui", "")
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	be.Equal(t, resp.Request.URL.Path, "/ui/")
+
+	// API работает как обычно
+	resp = a.do(t, "POST", "/api/tasks", "")
+	be.Equal(t, resp.StatusCode, http.StatusCreated)
+}
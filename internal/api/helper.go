@@ -1,7 +1,10 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,9 +12,12 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"zipget/internal/logger"
 	"zipget/internal/model"
+	"zipget/internal/taskid"
 )
 
 type httpError struct {
@@ -48,9 +54,16 @@ func (h *helper) Logger() *slog.Logger {
 	return h.log
 }
 
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// WriteError пишет ошибку в едином для всего API формате: {"error": "<сообщение>"}.
 func (h *helper) WriteError(err error) {
 	httpErr := h.mapError(err)
-	http.Error(h.w, httpErr.StatusMsg, httpErr.StatusCode)
+	h.w.Header().Del("Content-Disposition") // ошибка могла возникнуть перед отдачей архива
+	h.w.Header().Del("Trailer")
+	h.WriteResponse(errorResponse{Error: httpErr.StatusMsg}, httpErr.StatusCode)
 }
 
 func (h *helper) mapError(err error) *httpError {
@@ -64,6 +77,16 @@ func (h *helper) mapError(err error) *httpError {
 		return &httpError{http.StatusNotFound, err.Error()}
 	case errors.Is(err, model.ErrMaxFilesExceeded):
 		return &httpError{http.StatusConflict, err.Error()}
+	case errors.Is(err, model.ErrInvalidCallback):
+		return &httpError{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, model.ErrDuplicateURL):
+		return &httpError{http.StatusConflict, err.Error()}
+	case errors.Is(err, model.ErrNotEnoughFiles):
+		return &httpError{http.StatusUnprocessableEntity, err.Error()}
+	case errors.Is(err, model.ErrNoFiles):
+		return &httpError{http.StatusNotFound, err.Error()}
+	case errors.Is(err, model.ErrRateLimited):
+		return &httpError{http.StatusTooManyRequests, err.Error()}
 	case errors.Is(err, model.ErrServerBusy):
 		return &httpError{http.StatusServiceUnavailable, err.Error()}
 	case errors.Is(err, model.ErrServerCancelled):
@@ -74,8 +97,64 @@ func (h *helper) mapError(err error) *httpError {
 	return &httpError{500, "internal error"}
 }
 
+// WriteConditional пишет ответ 200 с ETag, вычисленным по содержимому ответа.
+// Если клиент прислал совпадающий If-None-Match, отвечает 304 без тела.
+func (h *helper) WriteConditional(resp any) {
+	body, etag, err := encodeETag(resp)
+	if err != nil {
+		h.WriteError(err)
+		return
+	}
+
+	h.w.Header().Set("ETag", etag)
+	if etagMatch(h.r.Header.Get("If-None-Match"), etag) {
+		h.w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.w.Header().Set("content-type", "application/json")
+	h.w.WriteHeader(http.StatusOK)
+	if _, err := h.w.Write(body); err != nil {
+		h.log.Error("write respose failed", "error", err)
+	}
+}
+
+// NotModified отвечает 304 с ETag, если If-None-Match совпадает с ETag ответа resp
+// (как у WriteConditional), и сообщает, что ответ записан.
+func (h *helper) NotModified(resp any) bool {
+	_, etag, err := encodeETag(resp)
+	if err != nil || !etagMatch(h.r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	h.w.Header().Set("ETag", etag)
+	h.w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// encodeETag сериализует ответ и вычисляет его ETag.
+func encodeETag(resp any) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), `"` + hex.EncodeToString(sum[:8]) + `"`, nil
+}
+
+// etagMatch проверяет, есть ли etag в значении If-None-Match (список или "*").
+// Сравнение слабое: префикс W/ не учитывается.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *helper) WriteResponse(resp any, statusCode int) {
-	h.w.Header().Add("content-type", "application/json")
+	h.w.Header().Set("content-type", "application/json")
 	h.w.WriteHeader(statusCode)
 	err := json.NewEncoder(h.w).Encode(resp)
 	if err != nil {
@@ -83,11 +162,20 @@ func (h *helper) WriteResponse(resp any, statusCode int) {
 	}
 }
 
-func (h *helper) GetID() (int64, error) {
+// GetID возвращает ID задачи из пути запроса в формате ids.
+func (h *helper) GetID(ids *taskid.Codec) (int64, error) {
 	s := h.r.PathValue("id")
 	if s == "" {
 		return 0, &httpError{http.StatusBadRequest, "id is required"}
 	}
+	if ids.Opaque() {
+		// поддельный или искажённый ID неотличим от ID несуществующей задачи
+		v, err := ids.Decode(s)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", model.ErrTaskNotFound, err)
+		}
+		return v, nil
+	}
 	v, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return 0, &httpError{http.StatusBadRequest, "id must be integer"}
@@ -98,14 +186,110 @@ func (h *helper) GetID() (int64, error) {
 	return v, nil
 }
 
+// QueryInt возвращает целочисленный параметр запроса или defaultValue, если параметр не задан.
+func (h *helper) QueryInt(name string, defaultValue int) (int, error) {
+	s := h.r.URL.Query().Get(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &httpError{http.StatusBadRequest, name + " must be integer"}
+	}
+	return v, nil
+}
+
+// QueryBool возвращает логический параметр запроса или defaultValue, если параметр не задан.
+func (h *helper) QueryBool(name string, defaultValue bool) (bool, error) {
+	s := h.r.URL.Query().Get(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, &httpError{http.StatusBadRequest, name + " must be boolean"}
+	}
+	return v, nil
+}
+
+// QueryDuration возвращает параметр-длительность (например, "10m") или 0, если параметр не задан.
+func (h *helper) QueryDuration(name string) (time.Duration, error) {
+	s := h.r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return 0, &httpError{http.StatusBadRequest, name + " must be positive duration"}
+	}
+	return v, nil
+}
+
+// Page возвращает параметры пагинации limit и offset.
+func (h *helper) Page(defaultLimit, maxLimit int) (limit, offset int, err error) {
+	return h.page("limit", "offset", defaultLimit, maxLimit)
+}
+
+// page возвращает параметры пагинации с заданными именами. Если maxLimit <= 0, limit не ограничен сверху.
+func (h *helper) page(limitName, offsetName string, defaultLimit, maxLimit int) (limit, offset int, err error) {
+	limit, err = h.QueryInt(limitName, defaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if maxLimit > 0 && (limit < 0 || limit > maxLimit) {
+		return 0, 0, &httpError{http.StatusBadRequest, fmt.Sprintf("%s must be in [0, %d]", limitName, maxLimit)}
+	}
+	if limit < 0 {
+		return 0, 0, &httpError{http.StatusBadRequest, limitName + " must be >= 0"}
+	}
+	offset, err = h.QueryInt(offsetName, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset < 0 {
+		return 0, 0, &httpError{http.StatusBadRequest, offsetName + " must be >= 0"}
+	}
+	return limit, offset, nil
+}
+
+// paginate возвращает страницу среза s.
+func paginate[T any](s []T, limit, offset int) []T {
+	start := min(offset, len(s))
+	end := start + min(limit, len(s)-start)
+	return s[start:end]
+}
+
 func (h *helper) ReadRequest(req any) error {
+	body, err := h.readBody()
+	if err != nil {
+		return err
+	}
+	return h.parseBody(body, req)
+}
+
+// ReadOptionalRequest читает тело запроса, если оно не пустое (иначе req не меняется).
+func (h *helper) ReadOptionalRequest(req any) error {
+	body, err := h.readBody()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return h.parseBody(body, req)
+}
+
+func (h *helper) readBody() ([]byte, error) {
 	body, err := io.ReadAll(h.r.Body)
 	if err != nil {
 		msg := "can't read request body"
 		h.log.Error(msg, "error", err)
-		return &httpError{http.StatusInternalServerError, msg}
+		return nil, &httpError{http.StatusInternalServerError, msg}
 	}
+	return body, nil
+}
 
+func (h *helper) parseBody(body []byte, req any) error {
 	if err := json.Unmarshal(body, req); err != nil {
 		msg := "can't parse request body"
 		h.log.Error(msg, "error", err)
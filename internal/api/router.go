@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Режимы обработки путей с завершающим слешем (config.API.TrailingSlash)
+const (
+	TrailingSlashStrict   = "strict"   // обрабатывается только канонический путь, путь со слешем - 404
+	TrailingSlashMatch    = "match"    // путь со слешем обрабатывается так же, как канонический
+	TrailingSlashRedirect = "redirect" // путь со слешем перенаправляется на канонический (308)
+)
+
+// router регистрирует маршруты вместе с их вариантами с завершающим слешем.
+type router struct {
+	mux           *http.ServeMux
+	trailingSlash string
+}
+
+func (rt router) Handle(pattern string, handler http.Handler) {
+	rt.mux.Handle(pattern, handler)
+
+	switch rt.trailingSlash {
+	case TrailingSlashMatch:
+		rt.mux.Handle(pattern+"/{$}", handler)
+	case TrailingSlashRedirect:
+		rt.mux.Handle(pattern+"/{$}", redirectToCanonical())
+	}
+}
+
+// redirectToCanonical перенаправляет на путь без завершающего слеша.
+// Используется 308, чтобы клиент повторил запрос с тем же методом и телом.
+func redirectToCanonical() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+		http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
+
+// routeMethods - методы, маршруты которых ищутся для ответа 405.
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Unmatched отвечает на запросы, не совпавшие ни с одним маршрутом mux (кроме самого Unmatched,
+// зарегистрированного на "/"): если путь есть у маршрутов с другими методами - 405 с заголовком
+// Allow, иначе - 404.
+func Unmatched(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, method := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != "/" {
+				allow = append(allow, method)
+			}
+		}
+		if len(allow) == 0 {
+			NotFound()(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		h := newHelper(w, r, "MethodNotAllowed")
+		h.WriteError(&httpError{http.StatusMethodNotAllowed, "method not allowed"})
+	}
+}
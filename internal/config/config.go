@@ -1,59 +1,289 @@
 package config
 
 import (
+	"compress/flate"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
 	"time"
+
+	"zipget/internal/model"
 )
 
 type Logger struct {
-	Level     slog.Level
-	Plaintext bool
+	Level      slog.Level
+	Plaintext  bool
+	HTTPTiming bool // писать строку завершения HTTP-запроса со статусом и длительностью
 }
 
 type Server struct {
-	Addr string
+	Addr    string
+	Metrics bool // публиковать метрики Prometheus (GET /metrics)
+}
+
+type API struct {
+	AdminToken           string // токен доступа к /admin (пусто - административные методы отключены)
+	VersionedArchiveName bool   // добавлять версию содержимого задачи в имя архива
+	TrailingSlash        string // обработка путей с завершающим слешем: strict, match, redirect
+	CheckRate            int    // количество проверок URL (POST /api/check) в минуту (0 - без ограничений)
+	CheckBurst           int    // количество проверок URL, допустимое разом сверх CheckRate
+	Base64MaxSize        int64  // максимальный размер архива, отдаваемого в JSON (?encoding=base64)
+	ArchiveDisposition   string // Content-Disposition архива по умолчанию: attachment, inline
+	ProgressTrailers     bool   // завершать потоковый ответ с архивом трейлерами X-Files-Total, X-Files-Completed, X-Bytes-Written
+	ListTasks            bool   // включить список задач GET /api/tasks
+	ArchiveLinkFiles     int    // количество файлов задачи, начиная с которого статус содержит ссылку на архив
+	FilesIndex           string // ответ на запрос корня /files/: not_found, info, forbidden
+	TaskIDFormat         string // формат ID задачи для клиентов: numeric, opaque
+	TaskIDKey            string // ключ AES непрозрачных ID задач в hex (пусто - случайный при запуске)
+	UIPath               string // путь встроенного веб-интерфейса (пусто - интерфейс отключён)
+
+	MaskQueryParams []string // параметры запроса, значения которых скрываются в URL файлов ("*" - все)
+}
+
+// LogValue скрывает токен и ключ при логировании конфигурации.
+func (c API) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Bool("AdminToken", c.AdminToken != ""),
+		slog.Bool("VersionedArchiveName", c.VersionedArchiveName),
+		slog.String("TrailingSlash", c.TrailingSlash),
+		slog.Int("CheckRate", c.CheckRate),
+		slog.Int("CheckBurst", c.CheckBurst),
+		slog.Int64("Base64MaxSize", c.Base64MaxSize),
+		slog.String("ArchiveDisposition", c.ArchiveDisposition),
+		slog.Bool("ProgressTrailers", c.ProgressTrailers),
+		slog.Bool("ListTasks", c.ListTasks),
+		slog.Int("ArchiveLinkFiles", c.ArchiveLinkFiles),
+		slog.String("FilesIndex", c.FilesIndex),
+		slog.String("TaskIDFormat", c.TaskIDFormat),
+		slog.Bool("TaskIDKey", c.TaskIDKey != ""),
+		slog.String("UIPath", c.UIPath),
+		slog.Any("MaskQueryParams", c.MaskQueryParams),
+	)
 }
 
 type Manager struct {
-	MaxTotal     int           // максимальное количество задач
-	MaxActive    int           // максимальное количество активных загрузок
-	MaxFiles     int           // максимальное количество URLs на задачу
-	TaskTTL      time.Duration // время жизни задачи
-	ProcessDelay time.Duration // ТОЛЬКО ДЛЯ ТЕСТОВ, чтобы можно было отследить количество активных задач
+	MaxTotal         int           // максимальное количество задач
+	MaxActive        int           // максимальное количество активных загрузок (0 - по одной, <0 - без ограничений)
+	MaxFiles         int           // максимальное количество URLs на задачу
+	MinFiles         int           // минимальное количество URLs в непустой задаче для сборки архива
+	EmptyTask        string        // ответ на запрос архива задачи без файлов: build, not_found (404), unprocessable (422)
+	TaskTTL          time.Duration // время жизни задачи
+	StorageMetrics   bool          // писать в лог метрики хранилища при каждой очистке устаревших задач
+	DedupURLs        string        // поведение при повторном добавлении URL: allow, reject, ignore
+	NormalizeURLs    bool          // нормализовать URL перед добавлением (фрагмент, порт по умолчанию, регистр хоста)
+	MaxTotalSize     int64         // бюджет суммарного размера файлов задачи в байтах (0 - без ограничений)
+	SlotWeight       string        // стоимость загрузки в слотах MaxActive: none (1 слот), files (по файлу), size (по размеру)
+	SlotSize         int64         // размер файлов, соответствующий одному слоту (для SlotWeight=size)
+	MaxBuildTime     time.Duration // максимальное время сборки архива, по истечении архив завершается (0 - без ограничений)
+	ArchiveCacheSize int64         // объём кэша собранных архивов в байтах (0 - кэш отключён)
+	Webhooks         bool          // разрешить уведомления о сборке архива (callback_url при создании задачи)
+	WebhookRetries   int           // количество повторов доставки уведомления
+	WebhookBackoff   time.Duration // задержка перед первым повтором (удваивается с каждой попыткой)
+	WebhookTimeout   time.Duration // максимальное время одной попытки доставки (0 - без ограничений)
+	ProcessDelay     time.Duration // ТОЛЬКО ДЛЯ ТЕСТОВ, чтобы можно было отследить количество активных задач
 }
 
 type Loader struct {
-	AllowMIMETypes []string
+	AllowMIMETypes         []string
+	ExtraTypes             []model.FileType   // дополнительные типы файлов (MIME-тип, сигнатура, расширения)
+	AllowSchemes           []string           // разрешённые схемы URL файлов (пусто - http и https)
+	SchemeProxy            *url.URL           // HTTP-прокси для схем, кроме http и https (nil - не заданы)
+	MaxFileSize            int64              // ограничение размера файла в байтах (0 - без ограничений)
+	MaxTotalSize           int64              // ограничение суммарного размера файлов архива без сжатия (Manager.MaxTotalSize)
+	MIMESizeLimits         map[string]int64   // ограничения размера файла по MIME-типу (нет в списке - MaxFileSize)
+	Concurrency            int                // количество параллельных запросов к источникам (0 - без ограничений)
+	DownloadConcurrency    int                // количество параллельно загружаемых файлов архива (0 - последовательно, <0 - без ограничений)
+	GlobalMaxBPS           int64              // общая скорость загрузки файлов всеми задачами, байт/с (0 - без ограничений)
+	ZipComment             *template.Template // шаблон комментария архива (nil - без комментария)
+	ZipLevel               int                // уровень сжатия deflate: 1-9, -2 (только Хаффман), -1 и 0 - по умолчанию
+	EntryMode              os.FileMode        // права доступа к файлам при распаковке архива (0 - не задавать)
+	ZipCompression         string             // метод сжатия файлов: auto (по типу файла), store (без сжатия), deflate
+	Retries                int                // максимальное количество повторных запросов к источнику
+	RetryFailed            int                // количество повторных проходов по файлам с временной ошибкой (0 - без повторов)
+	RetryBackoff           time.Duration      // начальная задержка перед повторным запросом (удваивается с каждой попыткой)
+	MaxRetryAfter          time.Duration      // максимальная задержка по заголовку Retry-After
+	CheckTimeout           time.Duration      // время на проверку файла запросом HEAD (0 - без ограничений)
+	DownloadHeaderTimeout  time.Duration      // время ожидания заголовков ответа при загрузке (0 - без ограничений)
+	SlowDownload           time.Duration      // длительность загрузки файла, после которой пишется предупреждение (0 - не писать)
+	StatusCSV              bool               // дублировать отчёт status.json в status.csv
+	StatusSummary          bool               // добавлять в status.json сводку (status.json становится объектом)
+	StatusArchiveSizes     bool               // добавлять в сводку status.json размеры записей архива до и после сжатия
+	SHA256Sums             bool               // добавлять в архив файл контрольных сумм SHA256SUMS
+	VerifyArchive          bool               // проверять собранный архив перед отдачей (сборка во временный файл)
+	FinalizeOnCancel       bool               // при отмене загрузки завершать архив со status.json (иначе прервать)
+	TrustMagic             bool               // принимать файл по разрешённому реальному типу, даже если заявленный запрещён
+	TrustedHosts           []string           // хосты, для которых сигнатура не проверяется (файл принимается по заявленному типу)
+	IPFamily               string             // семейство адреса источника для соединения: any, ipv4, ipv6
+	DetectHTMLPages        bool               // сообщать о HTML-странице (обычно ошибки) вместо файла статусом 502
+	SignatureCheck         string             // проверка сигнатуры: strict (неизвестная - отклонить), lenient (принять по заявленному типу)
+	PartialContent         string             // ответ 206 на запрос без Range: reject, full (если получен весь файл), accept
+	EnableRange            bool               // докачивать прерванную загрузку запросами Range (если источник поддерживает)
+	MaxRedirects           int                // максимальное количество перенаправлений (0 - запрещены, <0 - без ограничений)
+	AllowRedirectDowngrade bool               // разрешить перенаправление с https на http
+	EntryURL               bool               // записывать исходный URL в комментарий записи архива
+	MaxErrorMsgLen         int                // максимальная длина ErrorMsg в байтах (0 - без ограничений)
+	MaskQueryParams        []string           // параметры запроса, значения которых скрываются в отчётах ("*" - все)
+
+	BreakerThreshold int           // количество последовательных неудач хоста для размыкания (0 - выключено)
+	BreakerWindow    time.Duration // окно, в пределах которого считаются неудачи
+	BreakerCooldown  time.Duration // время, на которое запросы к хосту запрещаются
+
+	HostRPS         float64              // запросов в секунду к одному хосту от всего сервера (0 - без ограничений)
+	HostConcurrency int                  // одновременных запросов к одному хосту от всего сервера (0 - без ограничений)
+	HostLimits      map[string]HostLimit // ограничения для отдельных хостов (заменяют HostRPS и HostConcurrency)
+	HostLimitWait   time.Duration        // максимальное ожидание очереди к хосту, после него - статус 429
+}
+
+// HostLimit - ограничение запросов к хосту источника.
+type HostLimit struct {
+	RPS         float64 // запросов в секунду (0 - без ограничений)
+	Concurrency int     // одновременных запросов (0 - без ограничений)
 }
 
 type Config struct {
 	Logger  Logger
 	Server  Server
+	API     API
 	Manager Manager
 	Loader  Loader
 }
 
+// DefaultAllowMIMETypes - безопасный список разрешённых типов для локальной разработки
+// (используется при LOADER_ALLOW_MIME_DEFAULT=true, если LOADER_ALLOW_MIME не задан).
+var DefaultAllowMIMETypes = []string{"application/pdf", "image/jpeg", "image/png"}
+
 func Load() (Config, error) {
 	const required = true
 	var ge getenv
+
+	// список MIME-типов обязателен, если не разрешён список по умолчанию
+	allowMIMEDefault := ge.Bool("LOADER_ALLOW_MIME_DEFAULT", !required, false)
+	var defaultMIMETypes []string
+	if allowMIMEDefault {
+		defaultMIMETypes = slices.Clone(DefaultAllowMIMETypes)
+	}
+
+	// скрытие параметров URL применяется и к ответам API, и к отчётам в архиве
+	maskQueryParams := ge.Strings("URL_MASK_QUERY_PARAMS", !required, nil)
+
+	// бюджет размера задачи проверяется менеджером по заявленным размерам и загрузчиком при сборке
+	maxTotalSize := ge.Size("MANAGER_MAX_TOTAL_SIZE", !required, 0)
+
 	cfg := Config{
 		Logger: Logger{
-			Level:     ge.LogLevel("LOG_LEVEL", !required, slog.LevelInfo),
-			Plaintext: ge.Bool("LOG_PLAINTEXT", !required, false),
+			Level:      ge.LogLevel("LOG_LEVEL", !required, slog.LevelInfo),
+			Plaintext:  ge.Bool("LOG_PLAINTEXT", !required, false),
+			HTTPTiming: ge.Bool("LOG_HTTP_TIMING", !required, false),
 		},
 		Server: Server{
-			Addr: ge.String("SERVER_ADDR", !required, ":8080"),
+			Addr:    ge.String("SERVER_ADDR", !required, ":8080"),
+			Metrics: ge.Bool("SERVER_METRICS", !required, false),
+		},
+		API: API{
+			AdminToken:           ge.String("API_ADMIN_TOKEN", !required, ""),
+			VersionedArchiveName: ge.Bool("API_VERSIONED_ARCHIVE_NAME", !required, false),
+			TrailingSlash:        ge.OneOf("API_TRAILING_SLASH", !required, "match", "strict", "match", "redirect"),
+			CheckRate:            ge.Int("API_CHECK_RATE", !required, 60),
+			CheckBurst:           ge.Int("API_CHECK_BURST", !required, 10),
+			Base64MaxSize:        ge.Size("API_BASE64_MAX_SIZE", !required, 10<<20),
+			ArchiveDisposition:   ge.OneOf("API_ARCHIVE_DISPOSITION", !required, "attachment", "attachment", "inline"),
+			ProgressTrailers:     ge.Bool("API_PROGRESS_TRAILERS", !required, false),
+			ListTasks:            ge.Bool("API_LIST_TASKS", !required, false),
+			ArchiveLinkFiles:     ge.Int("API_ARCHIVE_LINK_FILES", !required, 3),
+			FilesIndex:           ge.OneOf("API_FILES_INDEX", !required, "not_found", "not_found", "info", "forbidden"),
+			TaskIDFormat:         ge.OneOf("API_TASK_ID_FORMAT", !required, "numeric", "numeric", "opaque"),
+			TaskIDKey:            ge.String("API_TASK_ID_KEY", !required, ""),
+			UIPath:               ge.String("API_UI_PATH", !required, ""),
+			MaskQueryParams:      maskQueryParams,
 		},
 		Manager: Manager{
-			MaxTotal:     ge.Int("MANAGER_MAX_TOTAL", !required, 1000),
-			MaxActive:    ge.Int("MANAGER_MAX_ACTIVE", !required, 3),
-			MaxFiles:     ge.Int("MANAGER_MAX_FILES", !required, 3),
-			TaskTTL:      ge.Duration("MANAGER_TASK_TTL", !required, 10*time.Minute),
-			ProcessDelay: ge.Duration("MANAGER_PROCESS_DELAY", !required, 0),
+			MaxTotal:         ge.Int("MANAGER_MAX_TOTAL", !required, 1000),
+			MaxActive:        ge.Int("MANAGER_MAX_ACTIVE", !required, 3),
+			MaxFiles:         ge.Int("MANAGER_MAX_FILES", !required, 3),
+			MinFiles:         ge.Int("MANAGER_MIN_FILES", !required, 1),
+			EmptyTask:        ge.OneOf("MANAGER_EMPTY_TASK", !required, "build", "build", "not_found", "unprocessable"),
+			TaskTTL:          ge.Duration("MANAGER_TASK_TTL", !required, 10*time.Minute),
+			StorageMetrics:   ge.Bool("MANAGER_STORAGE_METRICS", !required, false),
+			ProcessDelay:     ge.Duration("MANAGER_PROCESS_DELAY", !required, 0),
+			DedupURLs:        ge.OneOf("MANAGER_DEDUP_URLS", !required, "allow", "allow", "reject", "ignore"),
+			NormalizeURLs:    ge.Bool("MANAGER_NORMALIZE_URLS", !required, true),
+			MaxTotalSize:     maxTotalSize,
+			SlotWeight:       ge.OneOf("MANAGER_SLOT_WEIGHT", !required, "none", "none", "files", "size"),
+			SlotSize:         ge.Size("MANAGER_SLOT_SIZE", !required, 100<<20),
+			MaxBuildTime:     ge.Duration("MANAGER_MAX_BUILD_TIME", !required, 0),
+			ArchiveCacheSize: ge.Size("MANAGER_ARCHIVE_CACHE_SIZE", !required, 0),
+			Webhooks:         ge.Bool("MANAGER_WEBHOOKS", !required, false),
+			WebhookRetries:   ge.Int("MANAGER_WEBHOOK_RETRIES", !required, 3),
+			WebhookBackoff:   ge.Duration("MANAGER_WEBHOOK_BACKOFF", !required, time.Second),
+			WebhookTimeout:   ge.Duration("MANAGER_WEBHOOK_TIMEOUT", !required, 10*time.Second),
 		},
 		Loader: Loader{
-			AllowMIMETypes: ge.Strings("LOADER_ALLOW_MIME", required, nil),
+			AllowMIMETypes:         ge.Strings("LOADER_ALLOW_MIME", !allowMIMEDefault, defaultMIMETypes),
+			ExtraTypes:             ge.FileTypes("LOADER_EXTRA_TYPES", !required),
+			AllowSchemes:           ge.Strings("LOADER_ALLOW_SCHEMES", !required, []string{"http", "https"}),
+			SchemeProxy:            ge.URL("LOADER_SCHEME_PROXY", !required),
+			MaxFileSize:            ge.Size("LOADER_MAX_FILE_SIZE", !required, 0),
+			MaxTotalSize:           maxTotalSize,
+			MIMESizeLimits:         ge.Sizes("LOADER_MIME_SIZE_LIMITS", !required, nil),
+			Concurrency:            ge.Int("LOADER_CONCURRENCY", !required, 0),
+			DownloadConcurrency:    ge.Int("LOADER_DOWNLOAD_CONCURRENCY", !required, 1),
+			GlobalMaxBPS:           ge.Size("LOADER_GLOBAL_MAX_BPS", !required, 0),
+			ZipComment:             ge.Template("LOADER_ZIP_COMMENT", !required),
+			ZipLevel:               ge.IntRange("LOADER_ZIP_LEVEL", !required, flate.DefaultCompression, flate.HuffmanOnly, flate.BestCompression),
+			EntryMode:              ge.FileMode("LOADER_ENTRY_MODE", !required, 0o644),
+			ZipCompression:         ge.OneOf("LOADER_ZIP_COMPRESSION", !required, "auto", "auto", "store", "deflate"),
+			Retries:                ge.Int("LOADER_RETRIES", !required, 0),
+			RetryFailed:            ge.Int("LOADER_RETRY_FAILED", !required, 0),
+			RetryBackoff:           ge.Duration("LOADER_RETRY_BACKOFF", !required, 500*time.Millisecond),
+			MaxRetryAfter:          ge.Duration("LOADER_MAX_RETRY_AFTER", !required, 30*time.Second),
+			CheckTimeout:           ge.Duration("LOADER_CHECK_TIMEOUT", !required, 5*time.Second),
+			DownloadHeaderTimeout:  ge.Duration("LOADER_DOWNLOAD_HEADER_TIMEOUT", !required, 30*time.Second),
+			SlowDownload:           ge.Duration("LOADER_SLOW_DOWNLOAD", !required, 0),
+			StatusCSV:              ge.Bool("LOADER_STATUS_CSV", !required, false),
+			StatusSummary:          ge.Bool("LOADER_STATUS_SUMMARY", !required, false),
+			StatusArchiveSizes:     ge.Bool("LOADER_STATUS_ARCHIVE_SIZES", !required, false),
+			SHA256Sums:             ge.Bool("LOADER_SHA256SUMS", !required, false),
+			VerifyArchive:          ge.Bool("LOADER_VERIFY_ARCHIVE", !required, false),
+			FinalizeOnCancel:       ge.Bool("LOADER_FINALIZE_ON_CANCEL", !required, true),
+			TrustMagic:             ge.Bool("LOADER_TRUST_MAGIC_OVER_DECLARED", !required, false),
+			TrustedHosts:           ge.Strings("LOADER_TRUSTED_HOSTS", !required, nil),
+			IPFamily:               ge.OneOf("SSRF_IP_FAMILY", !required, "any", "any", "ipv4", "ipv6"),
+			DetectHTMLPages:        ge.Bool("LOADER_DETECT_HTML_PAGES", !required, true),
+			SignatureCheck:         ge.OneOf("LOADER_SIGNATURE_CHECK", !required, "strict", "strict", "lenient"),
+			PartialContent:         ge.OneOf("LOADER_PARTIAL_CONTENT", !required, "full", "reject", "full", "accept"),
+			EnableRange:            ge.Bool("LOADER_ENABLE_RANGE", !required, false),
+			MaxRedirects:           ge.Int("LOADER_MAX_REDIRECTS", !required, 5),
+			AllowRedirectDowngrade: ge.Bool("LOADER_ALLOW_REDIRECT_DOWNGRADE", !required, false),
+			EntryURL:               ge.Bool("LOADER_ENTRY_URL_COMMENT", !required, false),
+			MaxErrorMsgLen:         ge.Int("LOADER_MAX_ERROR_MSG_LEN", !required, 512),
+			MaskQueryParams:        maskQueryParams,
+
+			BreakerThreshold: ge.Int("LOADER_BREAKER_THRESHOLD", !required, 0),
+			BreakerWindow:    ge.Duration("LOADER_BREAKER_WINDOW", !required, time.Minute),
+			BreakerCooldown:  ge.Duration("LOADER_BREAKER_COOLDOWN", !required, 30*time.Second),
+
+			HostRPS:         ge.Float("LOADER_HOST_RPS", !required, 0),
+			HostConcurrency: ge.Int("LOADER_HOST_CONCURRENCY", !required, 0),
+			HostLimits:      ge.HostLimits("LOADER_HOST_LIMITS", !required),
+			HostLimitWait:   ge.Duration("LOADER_HOST_LIMIT_WAIT", !required, 30*time.Second),
 		},
 	}
+
+	// запросы по дополнительным схемам выполняются только через прокси
+	for _, scheme := range cfg.Loader.AllowSchemes {
+		if cfg.Loader.SchemeProxy == nil && !IsHTTPScheme(scheme) {
+			ge.errs = append(ge.errs, fmt.Errorf("LOADER_ALLOW_SCHEMES: scheme %q requires LOADER_SCHEME_PROXY", scheme))
+		}
+	}
 	return cfg, ge.Err()
 }
+
+// IsHTTPScheme сообщает, что схему обслуживает HTTP-клиент без прокси (http, https).
+func IsHTTPScheme(scheme string) bool {
+	scheme = strings.ToLower(scheme)
+	return scheme == "http" || scheme == "https"
+}
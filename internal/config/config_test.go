@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestLoad_AllowMIMEDefault(t *testing.T) {
+	t.Run("required", func(t *testing.T) {
+		t.Setenv("LOADER_ALLOW_MIME", "")
+		t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "")
+
+		_, err := Load()
+		be.True(t, errors.Is(err, ErrEnvRequired))
+	})
+
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("LOADER_ALLOW_MIME", "")
+		t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "true")
+
+		cfg, err := Load()
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Loader.AllowMIMETypes, []string{"application/pdf", "image/jpeg", "image/png"})
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		t.Setenv("LOADER_ALLOW_MIME", "image/gif")
+		t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "true")
+
+		cfg, err := Load()
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Loader.AllowMIMETypes, []string{"image/gif"})
+	})
+}
+
+func TestLoad_ZipLevel(t *testing.T) {
+	t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "true")
+
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"", -1, true},
+		{"9", 9, true},
+		{"-2", -2, true},
+		{"10", 0, false},
+		{"-3", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOADER_ZIP_LEVEL", tt.value)
+		cfg, err := Load()
+		if !tt.ok {
+			be.True(t, err != nil)
+			continue
+		}
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Loader.ZipLevel, tt.want)
+	}
+}
+
+func TestLoad_EntryMode(t *testing.T) {
+	t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "true")
+
+	tests := []struct {
+		value string
+		want  os.FileMode
+		ok    bool
+	}{
+		{"", 0o644, true},
+		{"0600", 0o600, true},
+		{"755", 0o755, true},
+		{"0", 0, true},
+		{"0888", 0, false},
+		{"01777", 0, false},
+		{"rw-r--r--", 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOADER_ENTRY_MODE", tt.value)
+		cfg, err := Load()
+		if !tt.ok {
+			be.True(t, err != nil)
+			continue
+		}
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Loader.EntryMode, tt.want)
+	}
+}
+
+func TestLoad_SchemeProxy(t *testing.T) {
+	t.Setenv("LOADER_ALLOW_MIME_DEFAULT", "true")
+	t.Setenv("LOADER_ALLOW_SCHEMES", "http https files")
+
+	t.Run("missing", func(t *testing.T) {
+		t.Setenv("LOADER_SCHEME_PROXY", "")
+		_, err := Load()
+		be.Err(t, err)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		t.Setenv("LOADER_SCHEME_PROXY", "http://proxy.internal:3128")
+		cfg, err := Load()
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Loader.SchemeProxy.Host, "proxy.internal:3128")
+	})
+}
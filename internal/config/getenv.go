@@ -1,13 +1,20 @@
 package config
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
+
+	"zipget/internal/model"
 )
 
 var ErrEnvRequired = errors.New("env is required")
@@ -54,6 +61,21 @@ func (ge *getenv) Strings(key string, required bool, defaultValue []string) []st
 	return v
 }
 
+// OneOf читает строковое значение и проверяет, что оно входит в список допустимых.
+func (ge *getenv) OneOf(key string, required bool, defaultValue string, allowed ...string) string {
+	v, err := getValue(key, required, defaultValue, func(s string) (string, error) {
+		s = strings.ToLower(s)
+		if !slices.Contains(allowed, s) {
+			return "", fmt.Errorf("invalid value %q for %q, want one of: %s", s, key, strings.Join(allowed, ", "))
+		}
+		return s, nil
+	})
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
 func (ge *getenv) Int(key string, required bool, defaultValue int) int {
 	v, err := getValue(key, required, defaultValue, func(s string) (int, error) {
 		return strconv.Atoi(s)
@@ -64,6 +86,188 @@ func (ge *getenv) Int(key string, required bool, defaultValue int) int {
 	return v
 }
 
+// IntRange читает целое значение и проверяет, что оно лежит в диапазоне [minValue, maxValue].
+func (ge *getenv) Float(key string, required bool, defaultValue float64) float64 {
+	v, err := getValue(key, required, defaultValue, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func (ge *getenv) IntRange(key string, required bool, defaultValue, minValue, maxValue int) int {
+	v, err := getValue(key, required, defaultValue, func(s string) (int, error) {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, err
+		}
+		if v < minValue || v > maxValue {
+			return 0, fmt.Errorf("invalid value %d for %q, want %d..%d", v, key, minValue, maxValue)
+		}
+		return v, nil
+	})
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+// FileMode читает права доступа к файлу в восьмеричной записи (например, "0644").
+func (ge *getenv) FileMode(key string, required bool, defaultValue os.FileMode) os.FileMode {
+	v, err := getValue(key, required, defaultValue, func(s string) (os.FileMode, error) {
+		v, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid file mode %q for %q: %w", s, key, err)
+		}
+		if v > uint64(os.ModePerm) {
+			return 0, fmt.Errorf("invalid file mode %q for %q, want 0000..0777", s, key)
+		}
+		return os.FileMode(v), nil
+	})
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+// Size читает размер в байтах. Допускаются суффиксы B, KB, MB, GB (кратные 1024), например "10MB".
+func (ge *getenv) Size(key string, required bool, defaultValue int64) int64 {
+	v, err := getValue(key, required, defaultValue, parseSize)
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	str := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid size %q: negative", s)
+	}
+	if v > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size %q: out of range", s)
+	}
+	return v * mult, nil
+}
+
+// Sizes читает список пар "ключ:размер", разделённых пробелами (например, "image/jpeg:10MB application/pdf:100MB").
+func (ge *getenv) Sizes(key string, required bool, defaultValue map[string]int64) map[string]int64 {
+	v, err := getValue(key, required, defaultValue, parseSizes)
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func parseSizes(s string) (map[string]int64, error) {
+	m := make(map[string]int64)
+	for _, field := range strings.Fields(s) {
+		k, v, ok := strings.Cut(field, ":")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid pair %q, want key:size", field)
+		}
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = size
+	}
+	return m, nil
+}
+
+// HostLimits читает список ограничений запросов к хостам "хост:запросов_в_секунду/одновременных",
+// разделённых пробелами (например, "example.com:2/4 cdn.example.org:0.5/1", 0 - без ограничения).
+func (ge *getenv) HostLimits(key string, required bool) map[string]HostLimit {
+	v, err := getValue(key, required, nil, parseHostLimits)
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func parseHostLimits(s string) (map[string]HostLimit, error) {
+	m := make(map[string]HostLimit)
+	for _, field := range strings.Fields(s) {
+		host, limit, ok := strings.Cut(field, ":")
+		rps, conc, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 || host == "" {
+			return nil, fmt.Errorf("invalid host limit %q, want host:rps/concurrency", field)
+		}
+		var hl HostLimit
+		var err error
+		if hl.RPS, err = strconv.ParseFloat(rps, 64); err != nil || hl.RPS < 0 {
+			return nil, fmt.Errorf("invalid rate in host limit %q", field)
+		}
+		if hl.Concurrency, err = strconv.Atoi(conc); err != nil || hl.Concurrency < 0 {
+			return nil, fmt.Errorf("invalid concurrency in host limit %q", field)
+		}
+		m[strings.ToLower(host)] = hl
+	}
+	return m, nil
+}
+
+// FileTypes читает список типов файлов "mime:сигнатура:расширения", разделённых пробелами, где
+// сигнатура - байты в шестнадцатеричной записи, расширения - через запятую
+// (например, "image/webp:52494646:.webp image/tiff:49492A00:.tif,.tiff").
+func (ge *getenv) FileTypes(key string, required bool) []model.FileType {
+	v, err := getValue(key, required, nil, parseFileTypes)
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func parseFileTypes(s string) ([]model.FileType, error) {
+	var types []model.FileType
+	for _, field := range strings.Fields(s) {
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid file type %q, want mime:magic:extensions", field)
+		}
+		magic, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature in file type %q: %w", field, err)
+		}
+		var exts []string
+		for _, ext := range strings.Split(parts[2], ",") {
+			if ext == "" {
+				continue
+			}
+			if ext[0] != '.' {
+				ext = "." + ext
+			}
+			exts = append(exts, strings.ToLower(ext))
+		}
+		types = append(types, model.FileType{MIMEType: parts[0], Magic: magic, Extensions: exts})
+	}
+	return types, nil
+}
+
 func (ge *getenv) LogLevel(key string, required bool, defaultValue slog.Level) slog.Level {
 	v, err := getValue(key, required, defaultValue, func(s string) (slog.Level, error) {
 		var v slog.Level
@@ -102,3 +306,33 @@ func (ge *getenv) Duration(key string, required bool, defaultValue time.Duration
 	}
 	return v
 }
+
+// URL читает абсолютный URL со схемой http или https.
+func (ge *getenv) URL(key string, required bool) *url.URL {
+	v, err := getValue(key, required, nil, parseHTTPURL)
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
+
+func parseHTTPURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, want http(s)://host[:port]", s)
+	}
+	return u, nil
+}
+
+func (ge *getenv) Template(key string, required bool) *template.Template {
+	v, err := getValue(key, required, nil, func(s string) (*template.Template, error) {
+		return template.New(key).Parse(s)
+	})
+	if err != nil {
+		ge.errs = append(ge.errs, err)
+	}
+	return v
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"testing"
+
+	"zipget/internal/model"
+
+	"github.com/nalgeon/be"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"100B", 100},
+		{"10KB", 10 << 10},
+		{"10mb", 10 << 20},
+		{"2 GB", 2 << 30},
+		{"8589934591GB", (1<<33 - 1) << 30}, // наибольшее значение в GB без переполнения
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			be.Err(t, err, nil)
+			be.Equal(t, got, tt.want)
+		})
+	}
+
+	for _, s := range []string{"ten MB", "-1", "-1KB", "9223372036854775807KB", "8589934592GB"} {
+		_, err := parseSize(s)
+		be.Err(t, err)
+	}
+}
+
+func TestParseSizes(t *testing.T) {
+	got, err := parseSizes("image/jpeg:10MB  application/pdf:100MB")
+	be.Err(t, err, nil)
+	be.Equal(t, got, map[string]int64{"image/jpeg": 10 << 20, "application/pdf": 100 << 20})
+
+	for _, s := range []string{"image/jpeg", ":10MB", "image/jpeg:ten"} {
+		_, err := parseSizes(s)
+		be.Err(t, err)
+	}
+}
+
+func TestParseHostLimits(t *testing.T) {
+	got, err := parseHostLimits("Example.com:2/4  cdn.example.org:0.5/0")
+	be.Err(t, err, nil)
+	be.Equal(t, got, map[string]HostLimit{
+		"example.com":     {RPS: 2, Concurrency: 4},
+		"cdn.example.org": {RPS: 0.5},
+	})
+
+	for _, s := range []string{"example.com", "example.com:2", ":2/4", "example.com:x/4", "example.com:2/-1", "example.com:-1/1"} {
+		_, err := parseHostLimits(s)
+		be.Err(t, err)
+	}
+}
+
+func TestParseHTTPURL(t *testing.T) {
+	u, err := parseHTTPURL("http://proxy.internal:3128")
+	be.Err(t, err, nil)
+	be.Equal(t, u.Host, "proxy.internal:3128")
+
+	for _, s := range []string{"proxy.internal:3128", "ftp://proxy.internal", "http://", "://x"} {
+		_, err := parseHTTPURL(s)
+		be.Err(t, err)
+	}
+}
+
+func TestParseFileTypes(t *testing.T) {
+	got, err := parseFileTypes("image/tiff:49492A00:.tif,TIFF  image/webp:52494646:")
+	be.Err(t, err, nil)
+	be.Equal(t, got, []model.FileType{
+		{MIMEType: "image/tiff", Magic: []byte{0x49, 0x49, 0x2A, 0x00}, Extensions: []string{".tif", ".tiff"}},
+		{MIMEType: "image/webp", Magic: []byte("RIFF")},
+	})
+
+	for _, s := range []string{"image/tiff", "image/tiff:49492A00", ":49492A00:.tif", "image/tiff::.tif", "image/tiff:II*:.tif"} {
+		_, err := parseFileTypes(s)
+		be.Err(t, err)
+	}
+}
@@ -0,0 +1,126 @@
+package loader
+
+import (
+	"archive/zip"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// archiveWriter упорядочивает запись файлов в архив при параллельной загрузке. Запросы к
+// источникам выполняются параллельно, но запись i-го файла прохода создаётся только после
+// завершения (i-1)-го: порядок записей совпадает с порядком загрузки, а тела файлов не
+// буферизуются - пока файл ждёт очереди, его источник сдерживается TCP-окном.
+type archiveWriter struct {
+	ar      Archiver
+	sized   bool // размер записи нужен до её содержимого (tar.gz)
+	entries archiveEntries
+	turns   []chan struct{} // turns[i] закрыт, когда i-й файл прохода может писать в архив
+	starts  []chan struct{} // starts[i] закрыт, когда i-й файл прохода может занимать место хоста
+	ordered bool            // места хостов занимаются в порядке записей (есть ограничение LOADER_HOST_*)
+
+	budget  int64       // ограничение суммарного размера файлов без сжатия (0 - без ограничений)
+	written int64       // зарезервировано файлами (изменяется только в очереди записи)
+	spent   atomic.Bool // ограничение превышено: оставшиеся файлы не загружаются
+}
+
+// reset начинает новый проход загрузки n файлов. Предыдущий проход должен быть завершён.
+func (aw *archiveWriter) reset(n int) {
+	aw.turns = make([]chan struct{}, n+1)
+	aw.starts = make([]chan struct{}, n+1)
+	for i := range aw.turns {
+		aw.turns[i] = make(chan struct{})
+		aw.starts[i] = make(chan struct{})
+		if !aw.ordered {
+			close(aw.starts[i])
+		}
+	}
+	close(aw.turns[0])
+	if aw.ordered {
+		close(aw.starts[0])
+	}
+}
+
+// slot возвращает очередь записи i-го файла прохода.
+func (aw *archiveWriter) slot(i int) *archiveSlot {
+	return &archiveSlot{aw: aw, i: i}
+}
+
+// method возвращает метод сжатия записи name (zip.Deflate, если записи нет).
+func (aw *archiveWriter) method(name string) uint16 {
+	for _, h := range aw.entries {
+		if h.Name == name {
+			return h.Method
+		}
+	}
+	return zip.Deflate
+}
+
+// archiveSlot - очередь записи одного файла. Пока файл пишет в архив (от create до done),
+// остальные ждут, поэтому отдельная блокировка zip.Writer не нужна.
+type archiveSlot struct {
+	aw   *archiveWriter
+	i    int
+	tee  io.Writer // копия содержимого записи для повторов URL (nil - не нужна, см. duplicates)
+	once sync.Once // закрытие starts[i+1]
+}
+
+// waitStart дожидается, пока предыдущий файл прохода займёт место хоста (или завершится).
+// Если места занимать в любом порядке, файлы, ждущие очереди записи, могут занять все места
+// хоста, и файл, чья очередь записи подошла, не дождётся своего.
+func (s *archiveSlot) waitStart() {
+	<-s.aw.starts[s.i]
+}
+
+// started разрешает следующему файлу прохода занимать место хоста.
+func (s *archiveSlot) started() {
+	s.once.Do(func() {
+		if s.aw.ordered {
+			close(s.aw.starts[s.i+1])
+		}
+	})
+}
+
+// reserve дожидается очереди файла и резервирует size байт ограничения архива. Если ограничение
+// будет превышено, файл и все следующие не пишутся: возвращается false и остаток ограничения.
+func (s *archiveSlot) reserve(size int64) (int64, bool) {
+	aw := s.aw
+	<-aw.turns[s.i]
+	if aw.budget <= 0 {
+		return 0, true
+	}
+	remaining := aw.budget - aw.written
+	if aw.spent.Load() || size > remaining {
+		aw.spent.Store(true)
+		return remaining, false
+	}
+	aw.written += size
+	return remaining - size, true
+}
+
+// exhausted сообщает, что ограничение архива уже превышено.
+func (s *archiveSlot) exhausted() bool {
+	return s.aw.spent.Load()
+}
+
+// create дожидается очереди файла и создаёт его запись в архиве.
+func (s *archiveSlot) create(header *EntryHeader) (io.Writer, error) {
+	<-s.aw.turns[s.i]
+	w, err := s.aw.ar.CreateEntry(header)
+	if err != nil {
+		return nil, err
+	}
+	s.aw.entries = append(s.aw.entries, header)
+	if s.tee != nil {
+		return io.MultiWriter(w, s.tee), nil
+	}
+	return w, nil
+}
+
+// done передаёт очередь следующему файлу. Вызывается ровно один раз, даже если запись
+// не создавалась (файл отклонён до загрузки).
+func (s *archiveSlot) done() {
+	s.started()
+	<-s.aw.turns[s.i]
+	close(s.aw.turns[s.i+1])
+}
@@ -0,0 +1,286 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Форматы архива (model.Archive.Format)
+const (
+	FormatZip   = "zip"   // ZIP (по умолчанию)
+	FormatTarGz = "targz" // tar, сжатый gzip
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown archive format")
+	ErrNilWriter     = errors.New("nil output writer")
+	ErrWriteOutput   = errors.New("write output failed")
+)
+
+// EntryHeader - заголовок записи архива.
+type EntryHeader struct {
+	Name    string
+	Size    int64       // размер содержимого (-1 - неизвестен: tar.gz накапливает такую запись в памяти)
+	Method  uint16      // метод сжатия ZIP (zip.Store или zip.Deflate); tar.gz сжимает поток целиком
+	Comment string      // комментарий записи (в tar - запись PAX comment)
+	Mode    os.FileMode // права доступа при распаковке (0 - не задавать; в tar - 0644)
+
+	// Заполняются при закрытии записи, т.е. при создании следующей или закрытии архива
+	Written    int64 // байт содержимого
+	Compressed int64 // байт после сжатия (-1 - неизвестно)
+}
+
+// Archiver - контейнер архива, не зависящий от формата. Записи пишутся по одной:
+// создание записи закрывает предыдущую.
+type Archiver interface {
+	CreateEntry(h *EntryHeader) (io.Writer, error)
+	Close() error
+}
+
+// NewArchiver создаёт архив формата format ("" - ZIP), записываемый в out.
+// level - уровень сжатия deflate (LOADER_ZIP_LEVEL, для tar.gz - уровень gzip).
+func NewArchiver(format string, out io.Writer, level int) (Archiver, error) {
+	switch format {
+	case "", FormatZip:
+		return newZipArchiver(out, level), nil
+	case FormatTarGz:
+		return newTarGzArchiver(out, level)
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+}
+
+// ArchiveContentType возвращает MIME-тип архива формата format.
+func ArchiveContentType(format string) string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// ArchiveExtension возвращает расширение файла архива формата format.
+func ArchiveExtension(format string) string {
+	if format == FormatTarGz {
+		return ".tar.gz"
+	}
+	return ".zip"
+}
+
+// sizedEntries сообщает, что размер записи файла формата format нужен до её содержимого.
+func sizedEntries(format string) bool {
+	return format == FormatTarGz
+}
+
+// zipArchiver пишет ZIP-архив.
+type zipArchiver struct {
+	zw   *zip.Writer
+	last *EntryHeader
+	fh   *zip.FileHeader // заголовок последней записи (размеры заполняет zip.Writer)
+}
+
+func newZipArchiver(out io.Writer, level int) *zipArchiver {
+	zw := zip.NewWriter(out)
+	if level != 0 && level != flate.DefaultCompression {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return &zipArchiver{zw: zw}
+}
+
+func (a *zipArchiver) CreateEntry(h *EntryHeader) (io.Writer, error) {
+	fh := &zip.FileHeader{Name: h.Name, Method: h.Method, Comment: h.Comment}
+	if h.Mode != 0 {
+		fh.SetMode(h.Mode)
+	}
+	w, err := a.zw.CreateHeader(fh)
+	if err != nil {
+		return nil, err
+	}
+	a.finish()
+	a.last, a.fh = h, fh
+	return w, nil
+}
+
+// finish переносит размеры закрытой записи в её EntryHeader.
+func (a *zipArchiver) finish() {
+	if a.last != nil {
+		a.last.Written = int64(a.fh.UncompressedSize64)
+		a.last.Compressed = int64(a.fh.CompressedSize64)
+		a.last = nil
+	}
+}
+
+// SetComment устанавливает комментарий архива.
+func (a *zipArchiver) SetComment(comment string) error {
+	return a.zw.SetComment(comment)
+}
+
+// Close пишет центральный каталог: ошибка означает неполный архив.
+func (a *zipArchiver) Close() error {
+	err := a.zw.Close()
+	a.finish()
+	return err
+}
+
+// tarGzArchiver пишет tar, сжатый gzip. Размер записи tar указывается в заголовке до содержимого:
+// запись с известным размером пишется потоком (недописанное содержимое, например при обрыве
+// загрузки, дополняется нулями), с неизвестным - накапливается в памяти до закрытия.
+type tarGzArchiver struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+
+	last    *EntryHeader
+	counter *countWriter  // содержимое записи с известным размером
+	pending *bytes.Buffer // содержимое записи с неизвестным размером
+	closed  bool
+}
+
+func newTarGzArchiver(out io.Writer, level int) (*tarGzArchiver, error) {
+	gz, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		return nil, err
+	}
+	return &tarGzArchiver{gz: gz, tw: tar.NewWriter(gz), modTime: time.Now().Truncate(time.Second)}, nil
+}
+
+func (a *tarGzArchiver) CreateEntry(h *EntryHeader) (io.Writer, error) {
+	if err := a.finish(); err != nil {
+		return nil, err
+	}
+	if h.Size < 0 {
+		a.last, a.pending = h, new(bytes.Buffer)
+		return a.pending, nil
+	}
+	// запись без заголовка не создана: закрывать нечего
+	if err := a.writeHeader(h, h.Size); err != nil {
+		return nil, err
+	}
+	a.last, a.counter = h, &countWriter{w: a.tw}
+	return a.counter, nil
+}
+
+func (a *tarGzArchiver) writeHeader(h *EntryHeader, size int64) error {
+	th := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     h.Name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  a.modTime,
+	}
+	if h.Mode != 0 {
+		th.Mode = int64(h.Mode.Perm())
+	}
+	if h.Comment != "" {
+		th.PAXRecords = map[string]string{"comment": h.Comment}
+		th.Format = tar.FormatPAX
+	}
+	return a.tw.WriteHeader(th)
+}
+
+// finish закрывает предыдущую запись.
+func (a *tarGzArchiver) finish() error {
+	h := a.last
+	if h == nil {
+		return nil
+	}
+	a.last = nil
+	h.Compressed = -1
+
+	if a.pending != nil {
+		buf := a.pending
+		a.pending = nil
+		h.Written = int64(buf.Len())
+		if err := a.writeHeader(h, h.Written); err != nil {
+			return err
+		}
+		_, err := a.tw.Write(buf.Bytes())
+		return err
+	}
+
+	h.Written = a.counter.n
+	if missing := h.Size - a.counter.n; missing > 0 {
+		if _, err := io.CopyN(a.tw, zeroReader{}, missing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *tarGzArchiver) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return errors.Join(a.finish(), a.tw.Close(), a.gz.Close())
+}
+
+// outputWriter - получатель архива. Первая ошибка записи запоминается и вызывает fail
+// (отмену загрузки), последующие записи возвращают её, не обращаясь к w: закрытие архива
+// после ошибки не дописывает в w обрывки служебных структур.
+// Архив пишется в каждый момент одним файлом (см. archiveWriter), поэтому блокировка не нужна.
+type outputWriter struct {
+	w    io.Writer
+	fail func() // nil - не вызывать
+	err  error
+}
+
+func (ow *outputWriter) Write(p []byte) (int, error) {
+	if ow.err != nil {
+		return 0, ow.err
+	}
+	n, err := ow.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		ow.err = err
+		if ow.fail != nil {
+			ow.fail()
+		}
+	}
+	return n, err
+}
+
+// isNilWriter сообщает, что w - nil, в том числе nil-указатель в непустом интерфейсе
+// (например, (*os.File)(nil)).
+func isNilWriter(w io.Writer) bool {
+	if w == nil {
+		return true
+	}
+	v := reflect.ValueOf(w)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// countWriter считает записанные байты.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// zeroReader отдаёт нулевые байты.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
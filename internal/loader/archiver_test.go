@@ -0,0 +1,240 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+// readTarGz читает архив tar.gz: содержимое и заголовки записей в порядке архива.
+func readTarGz(t *testing.T, data []byte) ([]string, map[string]string, map[string]*tar.Header) {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	be.Err(t, err, nil)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	contents := make(map[string]string)
+	headers := make(map[string]*tar.Header)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		be.Err(t, err, nil)
+		b, err := io.ReadAll(tr)
+		be.Err(t, err, nil)
+		names = append(names, th.Name)
+		contents[th.Name] = string(b)
+		headers[th.Name] = th
+	}
+	return names, contents, headers
+}
+
+// readZip читает архив ZIP: содержимое записей в порядке архива.
+func readZip(t *testing.T, data []byte) ([]string, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	be.Err(t, err, nil)
+
+	var names []string
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		contents[f.Name] = readEntry(t, f)
+	}
+	return names, contents
+}
+
+func TestDownload_Formats(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/photo.jpg", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", `attachment; filename="../my photo.jpg"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(jpegData)))
+		w.Write(jpegData)
+	}))
+	// без Content-Length: в tar.gz тело сохраняется во временный файл
+	mux.Handle("/doc.pdf", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdfData[:100])
+		w.(http.Flusher).Flush()
+		w.Write(pdfData[100:])
+	}))
+	mux.Handle("/missing.pdf", http.NotFoundHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	urls := []string{srv.URL + "/photo.jpg", srv.URL + "/doc.pdf", srv.URL + "/missing.pdf"}
+
+	ldr := newTestLoader(config.Loader{SHA256Sums: true, EntryURL: true, StatusSummary: true, StatusArchiveSizes: true})
+	build := func(format string) ([]File, []byte) {
+		var buf bytes.Buffer
+		files, err := ldr.Download(context.Background(), urls, &buf, Archive{Format: format})
+		be.Err(t, err, nil)
+		return files, buf.Bytes()
+	}
+
+	zipFiles, zipData := build(FormatZip)
+	zipNames, zipContents := readZip(t, zipData)
+
+	tarFiles, tarData := build(FormatTarGz)
+	tarNames, tarContents, headers := readTarGz(t, tarData)
+
+	// одинаковые имена, содержимое и результаты
+	be.Equal(t, tarNames, zipNames)
+	be.Equal(t, tarNames, []string{"my-photo-1.jpg", "unnamed-2.pdf", sha256SumsName, "status.json"})
+	be.Equal(t, tarContents["my-photo-1.jpg"], string(jpegData))
+	be.Equal(t, tarContents["unnamed-2.pdf"], string(pdfData))
+	be.Equal(t, tarContents[sha256SumsName], zipContents[sha256SumsName])
+	for i := range tarFiles {
+		be.Equal(t, tarFiles[i].Status, zipFiles[i].Status)
+		be.Equal(t, tarFiles[i].Name, zipFiles[i].Name)
+	}
+	be.Equal(t, tarFiles[2].Status, http.StatusNotFound)
+
+	// заголовки tar: размер, права и исходный URL
+	be.Equal(t, headers["my-photo-1.jpg"].Size, int64(len(jpegData)))
+	be.Equal(t, headers["my-photo-1.jpg"].Mode, int64(0o644))
+	be.Equal(t, headers["my-photo-1.jpg"].PAXRecords["comment"], srv.URL+"/photo.jpg")
+
+	// status.json: сжатый размер записей tar.gz неизвестен
+	var status statusWithSummary
+	be.Err(t, json.Unmarshal([]byte(tarContents["status.json"]), &status), nil)
+	be.Equal(t, len(status.Files), 3)
+	be.Equal(t, *status.Summary.UncompressedBytes, int64(len(jpegData)+len(pdfData)))
+	be.True(t, status.Summary.CompressedBytes == nil)
+
+	be.Err(t, json.Unmarshal([]byte(zipContents["status.json"]), &status), nil)
+	be.True(t, status.Summary.CompressedBytes != nil)
+}
+
+func TestDownload_TarGzTruncated(t *testing.T) {
+	// источник обрывает соединение, не дописав заявленный размер
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(jpegData)))
+		w.Write(jpegData[:len(jpegData)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{})
+	var buf bytes.Buffer
+	files, err := ldr.Download(context.Background(), []string{srv.URL + "/a.jpg"}, &buf, Archive{Format: FormatTarGz})
+	be.Err(t, err, nil)
+	be.Equal(t, files[0].Status, http.StatusBadGateway)
+
+	// запись дополнена нулями до заявленного размера, архив читается
+	be.Err(t, verifyTarGz(bytes.NewReader(buf.Bytes()), int64(buf.Len())), nil)
+	names, contents, _ := readTarGz(t, buf.Bytes())
+	be.Equal(t, names, []string{"unnamed-1.jpg", "status.json"})
+	be.Equal(t, len(contents["unnamed-1.jpg"]), len(jpegData))
+	be.Equal(t, contents["unnamed-1.jpg"][:len(jpegData)/2], string(jpegData[:len(jpegData)/2]))
+}
+
+func TestDownload_TarGzVerify(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{VerifyArchive: true})
+	var buf bytes.Buffer
+	_, err := ldr.Download(context.Background(), []string{srv.URL + "/a.jpg"}, &buf, Archive{Format: FormatTarGz})
+	be.Err(t, err, nil)
+	archive := buf.Bytes()
+	_, contents, _ := readTarGz(t, archive)
+	be.Equal(t, contents["unnamed-1.jpg"], string(jpegData))
+
+	truncated := archive[:len(archive)-10]
+	be.Err(t, verifyTarGz(bytes.NewReader(truncated), int64(len(truncated))), ErrCorruptArchive)
+}
+
+func TestNewArchiver_UnknownFormat(t *testing.T) {
+	_, err := NewArchiver("rar", io.Discard, 0)
+	be.Err(t, err, ErrUnknownFormat)
+
+	_, err = newTestLoader(config.Loader{}).Download(context.Background(), nil, io.Discard, Archive{Format: "rar"})
+	be.Err(t, err, ErrUnknownFormat)
+}
+
+// failingWriter возвращает ошибку на каждую запись и считает попытки.
+type failingWriter struct {
+	writes int
+}
+
+var errWriterBroken = errors.New("writer is broken")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errWriterBroken
+}
+
+func TestDownload_NilWriter(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		serveFile("application/pdf", pdfData)(w, r)
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{})
+	for _, out := range []io.Writer{nil, (*bytes.Buffer)(nil)} {
+		files, err := ldr.Download(context.Background(), []string{srv.URL + "/a.pdf"}, out, Archive{})
+		be.Err(t, err, ErrNilWriter)
+		be.Equal(t, len(files), 0)
+	}
+	// файлы не загружались
+	be.Equal(t, requests.Load(), int32(0))
+}
+
+func TestDownload_FailingWriter(t *testing.T) {
+	// файл больше буфера zip.Writer: ошибка проявляется при записи содержимого
+	bigPDF := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("pdf "), 64<<10)...)
+	mux := http.NewServeMux()
+	mux.Handle("/small.pdf", serveFile("application/pdf", pdfData))
+	mux.Handle("/", serveFile("application/pdf", bigPDF))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		cfg  config.Loader
+		arch Archive
+		urls []string
+	}{
+		{"zip", config.Loader{DownloadConcurrency: -1}, Archive{}, []string{srv.URL + "/a.pdf", srv.URL + "/b.pdf", srv.URL + "/c.pdf"}},
+		{"zip small", config.Loader{}, Archive{}, []string{srv.URL + "/small.pdf"}}, // архив умещается в буфер: ошибка при закрытии
+		{"targz", config.Loader{DownloadConcurrency: -1}, Archive{Format: FormatTarGz}, []string{srv.URL + "/a.pdf", srv.URL + "/b.pdf"}},
+		{"verify", config.Loader{VerifyArchive: true}, Archive{}, []string{srv.URL + "/a.pdf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &failingWriter{}
+			var reported atomic.Int32 // OnFile вызывается из загружающих горутин
+			tt.arch.OnFile = func(File) { reported.Add(1) }
+
+			files, err := newTestLoader(tt.cfg).Download(context.Background(), tt.urls, out, tt.arch)
+			be.Err(t, err, ErrWriteOutput)
+			be.Err(t, err, errWriterBroken)
+
+			// после первой ошибки в out ничего не пишется, все загрузки завершены
+			be.Equal(t, out.writes, 1)
+			be.True(t, len(files) > 0)
+			be.True(t, int(reported.Load()) <= len(tt.urls))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package loader
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker - автоматический выключатель по хостам источников.
+//
+// После threshold последовательных неудач (ошибка сети или 5xx) к хосту в пределах window
+// запросы к нему не выполняются в течение cooldown. Успешный запрос сбрасывает счётчик.
+// По истечении cooldown пропускается один пробный запрос (остальные по-прежнему отклоняются):
+// при его неудаче выключатель снова размыкается независимо от window. Если о результате пробы
+// не сообщено (запрос отменён вызывающим), следующая проба пропускается через cooldown.
+//
+// nil *breaker ничего не ограничивает.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	failures  int       // количество последовательных неудач
+	first     time.Time // время первой неудачи в серии
+	openUntil time.Time // до какого времени запросы запрещены
+	open      bool      // выключатель разомкнут: после openUntil пропускаются только пробы
+	probeAt   time.Time // с какого времени можно пропустить следующий пробный запрос
+}
+
+func newBreaker(threshold int, window, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       time.Now,
+		hosts:     make(map[string]*hostState),
+	}
+}
+
+// allow сообщает, можно ли выполнять запрос к хосту.
+func (b *breaker) allow(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.hosts[host]
+	if !ok || !st.open {
+		return true
+	}
+
+	now := b.now()
+	if now.Before(st.openUntil) || now.Before(st.probeAt) {
+		return false
+	}
+	// полуразомкнут: пропускаем один пробный запрос
+	st.probeAt = now.Add(b.cooldown)
+	return true
+}
+
+// success сбрасывает счётчик неудач хоста.
+func (b *breaker) success(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.hosts, host)
+}
+
+// failure учитывает неудачу и при достижении порога (или неудаче пробного запроса) размыкает выключатель.
+func (b *breaker) failure(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	st, ok := b.hosts[host]
+	if !ok {
+		st = &hostState{}
+		b.hosts[host] = st
+	}
+
+	// неудачный пробный запрос
+	if st.open {
+		st.openUntil, st.probeAt = now.Add(b.cooldown), time.Time{}
+		return
+	}
+
+	// серия неудач устарела - начинаем новую
+	if st.failures > 0 && b.window > 0 && now.Sub(st.first) > b.window {
+		st.failures = 0
+	}
+	if st.failures == 0 {
+		st.first = now
+	}
+
+	st.failures++
+	if st.failures >= b.threshold {
+		st.open, st.openUntil = true, now.Add(b.cooldown)
+	}
+}
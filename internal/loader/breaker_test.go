@@ -0,0 +1,125 @@
+package loader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	const host = "example.com"
+
+	// первая неудача - ещё замкнут
+	b.failure(host)
+	be.True(t, b.allow(host))
+
+	// вторая - размыкается
+	b.failure(host)
+	be.True(t, !b.allow(host))
+	be.True(t, b.allow("other.com"))
+
+	// по истечении cooldown пропускаем пробный запрос
+	now = now.Add(31 * time.Second)
+	be.True(t, b.allow(host))
+
+	// пробный запрос неудачен - снова разомкнут
+	b.failure(host)
+	be.True(t, !b.allow(host))
+
+	// успех сбрасывает
+	now = now.Add(31 * time.Second)
+	b.success(host)
+	b.failure(host)
+	be.True(t, b.allow(host))
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(3, 10*time.Second, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	const host = "example.com"
+
+	for range 3 {
+		b.failure(host)
+	}
+	be.True(t, !b.allow(host))
+
+	// по истечении cooldown пропускается только один пробный запрос
+	now = now.Add(31 * time.Second)
+	be.True(t, b.allow(host))
+	be.True(t, !b.allow(host))
+
+	// проба неудачна далеко за пределами window - выключатель снова разомкнут
+	b.failure(host)
+	be.True(t, !b.allow(host))
+	now = now.Add(29 * time.Second)
+	be.True(t, !b.allow(host))
+
+	// о результате пробы не сообщено - следующая через cooldown
+	now = now.Add(2 * time.Second)
+	be.True(t, b.allow(host))
+	now = now.Add(29 * time.Second)
+	be.True(t, !b.allow(host))
+	now = now.Add(2 * time.Second)
+	be.True(t, b.allow(host))
+
+	// удачная проба замыкает
+	b.success(host)
+	be.True(t, b.allow(host))
+	be.True(t, b.allow(host))
+}
+
+func TestBreaker_Window(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	const host = "example.com"
+
+	b.failure(host)
+	now = now.Add(2 * time.Minute) // серия устарела
+	b.failure(host)
+	be.True(t, b.allow(host))
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	b := newBreaker(0, time.Minute, time.Minute)
+	be.True(t, b == nil)
+	b.failure("example.com")
+	be.True(t, b.allow("example.com"))
+}
+
+func TestCheck_BreakerTrips(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{BreakerThreshold: 2, BreakerWindow: time.Minute, BreakerCooldown: time.Minute})
+	ctx := context.Background()
+
+	for range 2 {
+		file, err := ldr.CheckFile(ctx, srv.URL)
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusInternalServerError)
+	}
+
+	file, err := ldr.CheckFile(ctx, srv.URL)
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusServiceUnavailable)
+	be.Equal(t, hits.Load(), int32(2)) // третий запрос до источника не дошёл
+}
@@ -0,0 +1,147 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// duplicates - повторы URL в списке загрузки. Каждый URL загружается один раз (первое вхождение):
+// содержимое его записи сохраняется во временный файл и после загрузки остальных файлов
+// копируется в записи повторов. У повтора общий с первым вхождением результат загрузки, но своя
+// запись и своё имя (uniqueNum - позиция в списке, как и у остальных файлов).
+type duplicates struct {
+	first  []int            // first[i] - индекс первого вхождения urls[i] (i - первое вхождение)
+	spools map[int]*os.File // содержимое первых вхождений, у которых есть повторы
+}
+
+// newDuplicates находит повторы URL. Временные файлы создаются, только если повторы есть;
+// вызывающий код должен закрыть их (close).
+func newDuplicates(urls []string) (*duplicates, error) {
+	d := &duplicates{first: make([]int, len(urls)), spools: make(map[int]*os.File)}
+	index := make(map[string]int, len(urls))
+	for i, uri := range urls {
+		first, ok := index[uri]
+		if !ok {
+			first = i
+			index[uri] = i
+		}
+		d.first[i] = first
+		if ok && d.spools[first] == nil {
+			spool, err := os.CreateTemp("", "zipget-*.dup")
+			if err != nil {
+				d.close()
+				return nil, fmt.Errorf("create temp file failed: %w", err)
+			}
+			d.spools[first] = spool
+		}
+	}
+	return d, nil
+}
+
+// unique возвращает индексы первых вхождений URL.
+func (d *duplicates) unique() []int {
+	idx := make([]int, 0, len(d.first))
+	for i, first := range d.first {
+		if first == i {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// repeated возвращает индексы повторов URL.
+func (d *duplicates) repeated() []int {
+	var idx []int
+	for i, first := range d.first {
+		if first != i {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// tee возвращает временный файл для содержимого i-го файла (nil, если повторов у него нет).
+func (d *duplicates) tee(i int) io.Writer {
+	if spool := d.spools[i]; spool != nil {
+		return spool
+	}
+	return nil
+}
+
+func (d *duplicates) close() {
+	for _, spool := range d.spools {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+}
+
+// writeDuplicates создаёт записи повторов URL (после записей остальных файлов) и сообщает их
+// результаты в files и sums. Повтор незагруженного файла получает тот же результат без записи.
+// offset - количество файлов предыдущих пачек (см. downloadBatch).
+func (ldr *Loader) writeDuplicates(ctx, fetchCtx context.Context, aw *archiveWriter, dups *duplicates, files []File, sums []string, offset int) error {
+	idx := dups.repeated()
+	aw.reset(len(idx))
+	for j, i := range idx {
+		slot := aw.slot(j)
+		err := ldr.copyDuplicate(ctx, fetchCtx, slot, dups, files, sums, i, offset)
+		slot.done()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDuplicate копирует запись первого вхождения URL i-го файла в его собственную запись.
+func (ldr *Loader) copyDuplicate(ctx, fetchCtx context.Context, slot *archiveSlot, dups *duplicates, files []File, sums []string, i, offset int) error {
+	first := dups.first[i]
+	file := files[first]
+	if file.Status != http.StatusOK {
+		file.Name = "" // записи (даже неполной) у повтора нет
+		files[i] = file
+		return nil
+	}
+
+	// после отмены и превышения ограничения архива повторы не пишутся, как и остальные файлы
+	if fetchCtx.Err() != nil {
+		files[i] = File{URL: file.URL}
+		setInterrupted(&files[i], fetchCtx)
+		if ctx.Err() != nil && !ldr.cfg.FinalizeOnCancel {
+			return ctx.Err()
+		}
+		return nil
+	}
+	if remaining, ok := slot.reserve(file.Size); !ok {
+		files[i] = File{URL: file.URL}
+		setBudgetExceeded(&files[i])
+		files[i].ErrorMsg += fmt.Sprintf(": file size %d, remaining %d", file.Size, remaining)
+		return nil
+	}
+
+	header := &EntryHeader{
+		Name:    constructFileName(file.OrigName, file.Extension, offset+i+1),
+		Size:    file.Size,
+		Method:  slot.aw.method(files[first].Name),
+		Comment: ldr.entryComment(file.URL),
+		Mode:    ldr.cfg.EntryMode,
+	}
+	fw, err := slot.create(header)
+	if err != nil {
+		files[i] = File{URL: file.URL, Status: http.StatusInternalServerError}
+		return fmt.Errorf("create archive entry failed: %w", err)
+	}
+	if _, err := io.Copy(fw, io.NewSectionReader(dups.spools[first], 0, file.Size)); err != nil {
+		files[i] = File{URL: file.URL, Status: http.StatusInternalServerError, Name: header.Name}
+		return fmt.Errorf("write failed: %w", err)
+	}
+
+	file.Name = header.Name
+	files[i] = file
+	if sums[first] != "" {
+		sums[i] = file.SHA256 + "  " + file.Name + "\n"
+	}
+	return nil
+}
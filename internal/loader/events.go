@@ -0,0 +1,72 @@
+package loader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"time"
+
+	"zipget/internal/protect"
+)
+
+// EventKind - вид события загрузчика.
+type EventKind string
+
+const (
+	EventFileChecked    EventKind = "file_checked"    // проверка файла завершена (результат - в Status)
+	EventFileDownloaded EventKind = "file_downloaded" // загрузка файла завершена (результат - в Status)
+	EventSSRFBlocked    EventKind = "ssrf_blocked"    // запрос отклонён защитой от SSRF
+	EventTypeRejected   EventKind = "type_rejected"   // тип файла (заявленный или реальный) не разрешён
+)
+
+// Event - событие загрузчика для систем мониторинга и аудита.
+type Event struct {
+	Kind        EventKind
+	Time        time.Time
+	URL         string
+	Status      int
+	ContentType string // тип, по которому принято решение (для type_rejected - отклонённый тип)
+	Size        int64
+	ErrorMsg    string
+}
+
+// EventSink принимает события загрузчика (например, передаёт их в Kafka, журнал или метрики).
+// Emit вызывается синхронно из потоков проверки и загрузки, в том числе параллельно,
+// поэтому должен быть безопасен для конкурентного вызова и не должен блокироваться надолго.
+type EventSink interface {
+	Emit(ctx context.Context, ev Event)
+}
+
+// NopSink отбрасывает события (используется по умолчанию).
+type NopSink struct{}
+
+func (NopSink) Emit(context.Context, Event) {}
+
+// SetEventSink задаёт приёмник событий (nil - события отбрасываются).
+// Вызывается до начала работы загрузчика.
+func (ldr *Loader) SetEventSink(sink EventSink) {
+	if sink == nil {
+		sink = NopSink{}
+	}
+	ldr.events = sink
+}
+
+// emit передаёт приёмнику событие kind по файлу file.
+func (ldr *Loader) emit(ctx context.Context, kind EventKind, file File) {
+	ldr.events.Emit(ctx, Event{
+		Kind:        kind,
+		Time:        time.Now(),
+		URL:         file.URL,
+		Status:      file.Status,
+		ContentType: cmp.Or(file.RealType, file.ContentType), // реальный тип, если определён
+		Size:        file.Size,
+		ErrorMsg:    file.ErrorMsg,
+	})
+}
+
+// emitRequestError передаёт приёмнику событие об отклонённом защитой от SSRF запросе.
+func (ldr *Loader) emitRequestError(ctx context.Context, file File, err error) {
+	if errors.Is(err, protect.ErrSSRF) {
+		ldr.emit(ctx, EventSSRFBlocked, file)
+	}
+}
@@ -26,7 +26,7 @@ var reservedNames = map[string]bool{
 // constructFileName строит безопасное имя файла:
 //
 //   - обрезает путь;
-//   - заменяет расширение на заданное;
+//   - заменяет расширение на заданное (лидирующие точки расширением не считаются);
 //   - если uniqueNum > 0, базовое имя дополняется суффиксом '-<uniqueNum>';
 //   - удаляет управляющие и неграфические символы;
 //   - заменяет запрещенные и проблемные символы на '-';
@@ -41,6 +41,7 @@ var reservedNames = map[string]bool{
 //	"file.txt", ".png", 123 -> "file-123.png"
 //	"file<>end", ".png", 0 -> "file-end.png"
 //	"con..txt", ".png", 0 -> "con_.png"
+//	".gitignore", ".txt", 0 -> "gitignore.txt"
 func constructFileName(fileName string, fileExt string, uniqueNum int) string {
 	if fileName == "" {
 		if uniqueNum > 0 {
@@ -54,8 +55,9 @@ func constructFileName(fileName string, fileExt string, uniqueNum int) string {
 		fileName = fileName[p+1:]
 	}
 
-	// Удалить расширение (всё после последней точки)
-	if p := strings.LastIndexByte(fileName, '.'); p != -1 {
+	// Удалить расширение (всё после последней точки). Лидирующие точки расширение не отделяют:
+	// у ".gitignore" нет расширения, иначе от имени ничего не останется.
+	if p := strings.LastIndexByte(fileName, '.'); p != -1 && p >= leadingDotsLen(fileName) {
 		fileName = fileName[:p]
 	}
 
@@ -73,6 +75,39 @@ func constructFileName(fileName string, fileExt string, uniqueNum int) string {
 	return baseName + fileExt
 }
 
+// ArchiveBaseName строит безопасное базовое имя архива (без расширения .zip) из имени, заданного
+// клиентом, по тем же правилам, что и имена файлов архива (см. constructFileName). Расширение .zip,
+// если указано, отбрасывается. Для пустого имени возвращает пустую строку (имя по умолчанию).
+//
+// Примеры:
+//
+//	"invoices-2025.zip" -> "invoices-2025"
+//	"Счета за 2025" -> "Счета-за-2025"
+//	"../evil\r\nname" -> "evil-name"
+func ArchiveBaseName(name string) string {
+	name = strings.TrimSpace(name)
+	if ext := ".zip"; len(name) >= len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+		name = name[:len(name)-len(ext)]
+	}
+	if name == "" {
+		return ""
+	}
+
+	baseName := sanitizeFilename(name, maxBaseNameLen)
+	if reservedNames[strings.ToUpper(baseName)] {
+		baseName = baseName + "_"
+	}
+	return baseName
+}
+
+// leadingDotsLen возвращает длину префикса из точек и пробелов.
+func leadingDotsLen(s string) int {
+	trimmed := strings.TrimLeftFunc(s, func(r rune) bool {
+		return r == '.' || unicode.IsSpace(r)
+	})
+	return len(s) - len(trimmed)
+}
+
 // ASCII опасные символы
 const asciiProblem = `<>:"/\|?*~.;#$%&'(){}[]!` + "`"
 
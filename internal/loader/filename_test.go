@@ -191,6 +191,34 @@ func TestConstructFileName(t *testing.T) {
 			fileName: "file................end",
 			want:     "file",
 		},
+		{
+			name:     "dotfile_env",
+			fileName: ".env",
+			fileExt:  ".txt",
+			want:     "env.txt",
+		},
+		{
+			name:     "dotfile_gitignore",
+			fileName: ".gitignore",
+			want:     "gitignore",
+		},
+		{
+			name:     "dotfile_many_leading_dots",
+			fileName: "...config",
+			fileExt:  ".pdf",
+			want:     "config.pdf",
+		},
+		{
+			name:     "dotfile_with_ext",
+			fileName: ".hidden.pdf",
+			fileExt:  ".pdf",
+			want:     "hidden.pdf",
+		},
+		{
+			name:     "dotfile_with_path",
+			fileName: "/home/user/.bashrc",
+			want:     "bashrc",
+		},
 	}
 
 	for _, tt := range tests {
@@ -274,6 +302,34 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
+func TestArchiveBaseName(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{".zip", ""},
+		{"invoices-2025", "invoices-2025"},
+		{"invoices-2025.zip", "invoices-2025"},
+		{"Invoices 2025.ZIP", "Invoices-2025"},
+		{"report.tar.zip", "report-tar"},
+		{"Счета за 2025", "Счета-за-2025"},
+		{"../../etc/passwd", "etc-passwd"},
+		{"evil\r\nSet-Cookie: x=1", "evil-Set-Cookie-x=1"},
+		{`a"b\c`, "a-b-c"},
+		{"CON.zip", "CON_"},
+		{"!!!", "unnamed"},
+		{strings.Repeat("a", 300) + ".zip", strings.Repeat("a", maxBaseNameLen)},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			be.Equal(t, ArchiveBaseName(tt.input), tt.output)
+		})
+	}
+}
+
 func TestConstructFileName2(t *testing.T) {
 	tests := []struct {
 		fileName  string
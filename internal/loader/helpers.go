@@ -1,12 +1,57 @@
 package loader
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"mime"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"zipget/internal/protect"
+	"zipget/internal/urlutil"
 )
 
+// DefaultSchemes - схемы URL, разрешённые, если LOADER_ALLOW_SCHEMES не задан.
+var DefaultSchemes = []string{"http", "https"}
+
+// parseURL разбирает нормализованный URL и проверяет, что его схема разрешена.
+// Запрос по нестандартной схеме выполняет транспорт клиента (в zipgetd - прокси LOADER_SCHEME_PROXY).
+func (ldr *Loader) parseURL(uri string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(urlutil.Normalize(uri))
+	if err != nil {
+		return nil, err
+	}
+	if !ldr.schemes[u.Scheme] {
+		return nil, fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+	return u, nil
+}
+
+// trustedHost сообщает, что ответ получен от доверенного хоста (LOADER_TRUSTED_HOSTS). Учитывается
+// хост последнего запроса: перенаправление с доверенного хоста на другой доверия не сохраняет.
+func (ldr *Loader) trustedHost(resp *http.Response) bool {
+	return len(ldr.trusted) > 0 && resp.Request != nil && ldr.trusted[strings.ToLower(resp.Request.URL.Hostname())]
+}
+
+// readUpTo дочитывает r в buf, начиная с позиции n, пока не наберётся want байт или чтение
+// не завершится, и возвращает новую позицию и ошибку чтения (io.EOF - данные закончились).
+func readUpTo(r io.Reader, buf []byte, n, want int64) (int64, error) {
+	var err error
+	for n < want && err == nil {
+		var m int
+		m, err = r.Read(buf[n:])
+		n += int64(m)
+	}
+	return n, err
+}
+
 func getContentLength(resp *http.Response) int64 {
 	sizeStr := resp.Header.Get("Content-Length")
 	if sizeStr == "" {
@@ -27,13 +72,181 @@ func getContentType(resp *http.Response) string {
 	return contentType
 }
 
+// getFileName возвращает имя файла из заголовка Content-Disposition (filename или filename*).
+// Имя из заголовка не доверенное: путь отбрасывается, имена "." и ".." считаются пустыми.
+// Окончательная санитизация выполняется в constructFileName.
 func getFileName(resp *http.Response) string {
 	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
 	if err != nil {
 		return ""
 	}
-	if fileName, ok := params["filename"]; ok {
-		return fileName
+
+	fileName := params["filename"]
+	if p := strings.LastIndexAny(fileName, `/\`); p != -1 {
+		fileName = fileName[p+1:]
+	}
+	if fileName == "." || fileName == ".." {
+		return ""
+	}
+	return fileName
+}
+
+// setRequestError заполняет статус файла по ошибке выполнения запроса.
+func setRequestError(log *slog.Logger, file *File, err error) {
+	switch {
+	case errors.Is(err, protect.ErrSSRF):
+		file.Status = http.StatusForbidden
+		log.Warn("SSRF attack blocked", "error", err)
+	case errors.Is(err, protect.ErrTooManyRedirects):
+		file.Status = http.StatusBadGateway
+		file.ErrorMsg = protect.ErrTooManyRedirects.Error()
+		log.Debug("too many redirects", "error", err)
+	case errors.Is(err, protect.ErrRedirectDowngrade):
+		file.Status = http.StatusBadGateway
+		file.ErrorMsg = protect.ErrRedirectDowngrade.Error()
+		log.Warn("redirect downgrade blocked", "error", err)
+	case errors.Is(err, ErrCircuitOpen):
+		file.Status = http.StatusServiceUnavailable
+		file.ErrorMsg = err.Error()
+		log.Debug("circuit breaker is open", "error", err)
+	case errors.Is(err, ErrHostLimit):
+		file.Status = http.StatusTooManyRequests
+		file.ErrorMsg = err.Error()
+		log.Debug("host request limit exceeded", "error", err)
+	default:
+		file.Status = http.StatusBadGateway
+		log.Debug("request failed", "error", err)
+	}
+}
+
+// sizeLimit возвращает ограничение размера файла типа mimeType: из LOADER_MIME_SIZE_LIMITS,
+// а для типов без своего ограничения - LOADER_MAX_FILE_SIZE (0 - без ограничений).
+func (ldr *Loader) sizeLimit(mimeType string) int64 {
+	if limit, ok := ldr.cfg.MIMESizeLimits[mimeType]; ok {
+		return limit
+	}
+	return ldr.cfg.MaxFileSize
+}
+
+// tooLarge проверяет размер файла типа mimeType по ограничению sizeLimit и при превышении
+// заполняет статус 413.
+func (ldr *Loader) tooLarge(file *File, mimeType string, size int64) bool {
+	limit := ldr.sizeLimit(mimeType)
+	if limit <= 0 || size <= limit {
+		return false
 	}
-	return ""
+	file.Status = http.StatusRequestEntityTooLarge
+	file.ErrorMsg = fmt.Sprintf("file size %d exceeds limit %d for type %q", size, limit, mimeType)
+	return true
+}
+
+// spoolLimit возвращает предел сохранения тела без Content-Length во временный файл:
+// меньшее из ограничений файла (sizeLimit) и архива (MANAGER_MAX_TOTAL_SIZE), 0 - без ограничений.
+func (ldr *Loader) spoolLimit(mimeType string) int64 {
+	limit, total := ldr.sizeLimit(mimeType), ldr.cfg.MaxTotalSize
+	if limit <= 0 || (total > 0 && total < limit) {
+		return total
+	}
+	return limit
+}
+
+// errArchiveTooLarge - превышено ограничение суммарного размера файлов архива.
+var errArchiveTooLarge = errors.New("archive size limit exceeded")
+
+// setBudgetExceeded заполняет статус файла, не загруженного из-за ограничения размера архива.
+func setBudgetExceeded(file *File) {
+	file.Status = http.StatusInsufficientStorage
+	file.ErrorMsg = errArchiveTooLarge.Error()
+}
+
+// spoolBody сохраняет во временный файл не больше maxSize+1 байт тела r и возвращает файл,
+// установленный на начало, и количество прочитанных байт (больше maxSize - ограничение превышено).
+// Вызывающий код должен закрыть и удалить файл.
+func spoolBody(r io.Reader, maxSize int64) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "zipget-*.part")
+	if err != nil {
+		return nil, 0, fmt.Errorf("create temp file failed: %w", err)
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r, maxSize+1))
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	return tmp, n, err
+}
+
+// errHTMLPage - источник вернул HTML-страницу (обычно страницу ошибки) вместо файла.
+var errHTMLPage = errors.New("origin returned an html page instead of the file (probably an error page)")
+
+// htmlPage распознаёт HTML-страницу, отданную вместо файла (LOADER_DETECT_HTML_PAGES), и заполняет
+// статус 502 с понятным сообщением вместо "file type is not allowed".
+func (ldr *Loader) htmlPage(file *File) bool {
+	if !ldr.cfg.DetectHTMLPages {
+		return false
+	}
+	switch file.ContentType {
+	case "text/html", "application/xhtml+xml":
+		file.Status = http.StatusBadGateway
+		file.ErrorMsg = errHTMLPage.Error()
+		return true
+	}
+	return false
+}
+
+// setInterrupted отмечает файл, загрузка которого прервана отменой ctx.
+func setInterrupted(file *File, ctx context.Context) {
+	file.Status = http.StatusGatewayTimeout
+	file.ErrorMsg = fmt.Sprintf("interrupted: %v", ctx.Err())
+	file.Interrupted = true
+}
+
+// statusMessage возвращает сообщение об ошибочном статусе ответа источника. Для требований
+// аутентификации сообщение подсказывает, что делать (для остальных статусов - пусто, будет
+// использован текст статуса).
+func statusMessage(resp *http.Response, status int) string {
+	var msg string
+	switch status {
+	case http.StatusUnauthorized:
+		msg = "origin requires authentication: use a URL with credentials or a presigned link"
+		if scheme := authScheme(resp.Header.Get("WWW-Authenticate")); scheme != "" {
+			msg += " (scheme " + scheme + ")"
+		}
+	case http.StatusProxyAuthRequired:
+		msg = "proxy requires authentication: check the server's proxy credentials"
+		if scheme := authScheme(resp.Header.Get("Proxy-Authenticate")); scheme != "" {
+			msg += " (scheme " + scheme + ")"
+		}
+	}
+	return msg
+}
+
+// authScheme возвращает схему аутентификации из заголовка WWW-Authenticate/Proxy-Authenticate.
+func authScheme(challenge string) string {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	return scheme
+}
+
+// errorMsgEllipsis - признак обрезанного сообщения об ошибке.
+const errorMsgEllipsis = "..."
+
+// truncateErrorMsg обрезает сообщение до maxLen байт (0 - без ограничений) по границе символа,
+// заменяя конец многоточием.
+func truncateErrorMsg(msg string, maxLen int) string {
+	if maxLen <= 0 || len(msg) <= maxLen {
+		return msg
+	}
+	if maxLen <= len(errorMsgEllipsis) {
+		return errorMsgEllipsis[:maxLen]
+	}
+	cut := maxLen - len(errorMsgEllipsis)
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut] + errorMsgEllipsis
+}
+
+// retryable сообщает, что файл не загружен из-за временной ошибки источника и может быть
+// загружен повторно. Файлы с уже созданной записью в архиве (Name задано) не повторяются,
+// чтобы в архиве не появилось двух записей с одним именем.
+func retryable(file *File) bool {
+	return file.Name == "" && !file.Interrupted && file.Transient()
 }
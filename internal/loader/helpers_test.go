@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestGetFileName(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{``, ""},
+		{`attachment`, ""},
+		{`attachment; filename="photo.jpg"`, "photo.jpg"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="..\\..\\windows\\win.ini"`, "win.ini"},
+		{`attachment; filename="/etc/"`, ""},
+		{`attachment; filename=".."`, ""},
+		{`attachment; filename="dir/.."`, ""},
+		{`attachment; filename*=UTF-8''..%2F..%2Fetc%2Fpasswd`, "passwd"},
+		{`attachment; filename*=UTF-8''%D1%84%D0%BE%D1%82%D0%BE.jpg`, "фото.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{"Content-Disposition": {tt.header}}}
+			be.Equal(t, getFileName(resp), tt.want)
+		})
+	}
+}
+
+func TestTruncateErrorMsg(t *testing.T) {
+	tests := []struct {
+		msg    string
+		maxLen int
+		want   string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"long error message", 10, "long er..."},
+		{"long error message", 0, "long error message"},
+		{"long error message", 2, ".."},
+		{"ошибка", 8, "ош..."}, // символ не разрезается
+	}
+	for _, tt := range tests {
+		be.Equal(t, truncateErrorMsg(tt.msg, tt.maxLen), tt.want)
+	}
+}
@@ -0,0 +1,173 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"zipget/internal/config"
+
+	"golang.org/x/time/rate"
+)
+
+var ErrHostLimit = errors.New("host request limit exceeded")
+
+// hostLimiterSweep - количество хостов, после которого из hostLimiter удаляются простаивающие.
+const hostLimiterSweep = 1024
+
+// hostLimiter ограничивает частоту и количество одновременных запросов к каждому хосту источника.
+// Один ограничитель общий для всех задач загрузчика.
+//
+// Частота ограничивается для каждого HTTP-запроса (включая повторы), количество - для файлов:
+// проверка или загрузка файла занимает место хоста целиком, вместе с повторами и докачкой,
+// поэтому медленная загрузка большого файла тоже считается одновременным запросом.
+// Если очередь к хосту не подошла за wait, запрос не выполняется (ErrHostLimit).
+//
+// nil *hostLimiter ничего не ограничивает.
+type hostLimiter struct {
+	def    config.HostLimit
+	custom map[string]config.HostLimit
+	wait   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostQueue
+}
+
+type hostQueue struct {
+	rate  *rate.Limiter // nil - без ограничения частоты
+	slots chan struct{} // nil - без ограничения количества
+	users int           // ждущие и занявшие место запросы
+	last  time.Time     // время последнего обращения
+}
+
+func newHostLimiter(def config.HostLimit, custom map[string]config.HostLimit, wait time.Duration) *hostLimiter {
+	if def == (config.HostLimit{}) && len(custom) == 0 {
+		return nil
+	}
+	return &hostLimiter{
+		def:    def,
+		custom: custom,
+		wait:   wait,
+		hosts:  make(map[string]*hostQueue),
+	}
+}
+
+// acquire занимает место хоста и возвращает функцию, освобождающую его (безопасна для повторного
+// вызова; при ошибке место не занимается). Возвращает ErrHostLimit, если место не освободилось
+// за wait, или ошибку контекста.
+func (l *hostLimiter) acquire(ctx context.Context, host string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	q := l.queue(host)
+	if q.slots == nil {
+		l.leave(q)
+		return func() {}, nil
+	}
+
+	tm := time.NewTimer(l.wait)
+	defer tm.Stop()
+
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.leave(q)
+		return func() {}, ctx.Err()
+	case <-tm.C:
+		l.leave(q)
+		return func() {}, fmt.Errorf("%w for host %s: no free slot in %s", ErrHostLimit, host, l.wait)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-q.slots
+			l.leave(q)
+		})
+	}, nil
+}
+
+// allow ждёт, пока ограничение частоты разрешит запрос к хосту. Возвращает ErrHostLimit,
+// если ждать пришлось бы дольше wait или дедлайна контекста, или ошибку контекста.
+func (l *hostLimiter) allow(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+	q := l.queue(host)
+	defer l.leave(q)
+	if q.rate == nil {
+		return nil
+	}
+
+	r := q.rate.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if delay > l.wait || !canWait(ctx, delay) {
+		r.Cancel()
+		return fmt.Errorf("%w for host %s: request rate", ErrHostLimit, host)
+	}
+	if err := sleep(ctx, delay); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+// queue возвращает очередь хоста, создавая её при необходимости, и учитывает в ней запрос
+// (учёт снимается leave).
+func (l *hostLimiter) queue(host string) *hostQueue {
+	host = strings.ToLower(host)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q, ok := l.hosts[host]
+	if !ok {
+		if len(l.hosts) >= hostLimiterSweep {
+			l.sweep(now)
+		}
+		limit, ok := l.custom[host]
+		if !ok {
+			limit = l.def
+		}
+		q = &hostQueue{}
+		if limit.RPS > 0 {
+			q.rate = rate.NewLimiter(rate.Limit(limit.RPS), 1)
+		}
+		if limit.Concurrency > 0 {
+			q.slots = make(chan struct{}, limit.Concurrency)
+		}
+		l.hosts[host] = q
+	}
+	q.users++
+	q.last = now
+	return q
+}
+
+// leave снимает учёт запроса в очереди хоста.
+func (l *hostLimiter) leave(q *hostQueue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q.users--
+	q.last = time.Now()
+}
+
+// sweep удаляет очереди хостов без запросов, ограничение частоты которых уже не действует.
+func (l *hostLimiter) sweep(now time.Time) {
+	for host, q := range l.hosts {
+		idle := time.Second
+		if q.rate != nil {
+			idle = max(idle, time.Duration(float64(time.Second)/float64(q.rate.Limit())))
+		}
+		if q.users == 0 && now.Sub(q.last) > idle {
+			delete(l.hosts, host)
+		}
+	}
+}
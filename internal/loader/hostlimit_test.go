@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+func TestHostLimit_Global(t *testing.T) {
+	const (
+		rps   = 50
+		tasks = 3
+		files = 5
+	)
+
+	var (
+		inflight, maxInflight atomic.Int32
+		mu                    sync.Mutex
+		starts                []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for m := maxInflight.Load(); n > m && !maxInflight.CompareAndSwap(m, n); m = maxInflight.Load() {
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdfData)
+	}))
+	defer srv.Close()
+
+	// один загрузчик на все задачи, как на сервере
+	ldr := newTestLoader(config.Loader{
+		DownloadConcurrency: files,
+		HostRPS:             rps,
+		HostConcurrency:     2,
+		HostLimitWait:       5 * time.Second,
+	})
+
+	urls := make([]string, files)
+	for i := range urls {
+		urls[i] = srv.URL + "/file-" + strconv.Itoa(i) + ".pdf" // повторы URL загружаются один раз
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				res, err := ldr.Check(context.Background(), urls)
+				be.Err(t, err, nil)
+				for _, f := range res {
+					be.Equal(t, f.Status, http.StatusOK)
+				}
+				return
+			}
+			var buf bytes.Buffer
+			res, err := ldr.Download(context.Background(), urls, &buf, Archive{})
+			be.Err(t, err, nil)
+			for _, f := range res {
+				be.Equal(t, f.Status, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	be.Equal(t, len(starts), tasks*files)
+	be.True(t, maxInflight.Load() <= 2)
+
+	// интервал между запросами всех задач - не меньше 1/rps (с запасом на точность таймеров)
+	for i := 1; i < len(starts); i++ {
+		be.True(t, starts[i].Sub(starts[i-1]) >= time.Second/rps*3/4)
+	}
+	be.True(t, elapsed >= time.Duration(tasks*files-1)*time.Second/rps*3/4)
+}
+
+func TestHostLimit_Wait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdfData)
+	}))
+	defer srv.Close()
+
+	// ограничение только для 127.0.0.1, для остальных хостов - без ограничений
+	ldr := newTestLoader(config.Loader{
+		HostLimits:    map[string]config.HostLimit{"127.0.0.1": {Concurrency: 1}},
+		HostLimitWait: 50 * time.Millisecond,
+	})
+
+	files, err := ldr.Check(context.Background(), []string{srv.URL + "/a.pdf", srv.URL + "/b.pdf"})
+	be.Err(t, err, nil)
+
+	statuses := map[int]int{}
+	for _, f := range files {
+		statuses[f.Status]++
+		if f.Status == http.StatusTooManyRequests {
+			be.True(t, strings.Contains(f.ErrorMsg, ErrHostLimit.Error()))
+			be.True(t, strings.Contains(f.ErrorMsg, "127.0.0.1"))
+		}
+	}
+	be.Equal(t, statuses, map[int]int{http.StatusOK: 1, http.StatusTooManyRequests: 1})
+}
+
+func TestHostLimiter(t *testing.T) {
+	ctx := context.Background()
+	custom := map[string]config.HostLimit{"fast.example.com": {}, "one.example.com": {Concurrency: 1}}
+	l := newHostLimiter(config.HostLimit{RPS: 1}, custom, time.Millisecond)
+
+	// свой хост без ограничений (имя хоста без учёта регистра)
+	for range 3 {
+		be.Err(t, l.allow(ctx, "FAST.example.com"), nil)
+	}
+
+	// общее ограничение частоты: второй запрос в ту же секунду не дождётся очереди
+	be.Err(t, l.allow(ctx, "slow.example.com"), nil)
+	be.Err(t, l.allow(ctx, "slow.example.com"), ErrHostLimit)
+
+	// ограничение количества: место освобождается release (повторный вызов безопасен)
+	release, err := l.acquire(ctx, "one.example.com")
+	be.Err(t, err, nil)
+	_, err = l.acquire(ctx, "one.example.com")
+	be.Err(t, err, ErrHostLimit)
+	release()
+	release()
+	release, err = l.acquire(ctx, "one.example.com")
+	be.Err(t, err, nil)
+	release()
+
+	// без ограничений ограничитель не создаётся
+	be.True(t, newHostLimiter(config.HostLimit{}, nil, time.Second) == nil)
+}
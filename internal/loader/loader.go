@@ -3,45 +3,99 @@ package loader
 import (
 	"archive/zip"
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log/slog"
+	"math"
 	"net/http"
-	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"zipget/internal/config"
 	"zipget/internal/logger"
+	"zipget/internal/metrics"
 	"zipget/internal/model"
-	"zipget/internal/protect"
+	"zipget/internal/urlutil"
+	"zipget/internal/version"
+
+	"golang.org/x/time/rate"
 )
 
 const (
 	bufSize  = 4096
 	magicLen = 8
+	sniffLen = 512 // сколько байт использует http.DetectContentType
 )
 
-type File = model.File
+type (
+	File    = model.File
+	Archive = model.Archive
+)
 
 type Loader struct {
-	client *http.Client
-	valid  map[string]bool
+	cfg     config.Loader
+	client  *http.Client
+	valid   map[string]bool
+	types   []FileType      // известные типы файлов: дополнительные (LOADER_EXTRA_TYPES) и встроенные
+	schemes map[string]bool // разрешённые схемы URL
+	trusted map[string]bool // доверенные хосты: сигнатура файла не проверяется
+	breaker *breaker
+	hosts   *hostLimiter // ограничение запросов к хостам (nil - без ограничений)
+	events  EventSink    // приёмник событий (по умолчанию NopSink)
+
+	bandwidth *rate.Limiter // общий ограничитель скорости загрузки (nil - без ограничений)
 }
 
-func New(client *http.Client, validMIMETypes []string) *Loader {
-	valid := make(map[string]bool, len(validMIMETypes))
-	for _, contentType := range validMIMETypes {
+func New(client *http.Client, cfg config.Loader) *Loader {
+	valid := make(map[string]bool, len(cfg.AllowMIMETypes))
+	for _, contentType := range cfg.AllowMIMETypes {
 		valid[contentType] = true
 	}
+	allowSchemes := cfg.AllowSchemes
+	if len(allowSchemes) == 0 {
+		allowSchemes = DefaultSchemes
+	}
+	schemes := make(map[string]bool, len(allowSchemes))
+	for _, scheme := range allowSchemes {
+		schemes[strings.ToLower(scheme)] = true
+	}
+	trusted := make(map[string]bool, len(cfg.TrustedHosts))
+	for _, host := range cfg.TrustedHosts {
+		trusted[strings.ToLower(host)] = true
+	}
+	if len(trusted) > 0 {
+		slog.Warn("signature check is disabled for trusted hosts", "hosts", cfg.TrustedHosts)
+	}
+	types := newFileTypes(cfg.ExtraTypes)
+	checkAllowedTypes(types, cfg.AllowMIMETypes, cfg.SignatureCheck)
 	return &Loader{
-		client: client,
-		valid:  valid,
+		cfg:       cfg,
+		client:    client,
+		valid:     valid,
+		types:     types,
+		schemes:   schemes,
+		trusted:   trusted,
+		breaker:   newBreaker(cfg.BreakerThreshold, cfg.BreakerWindow, cfg.BreakerCooldown),
+		hosts:     newHostLimiter(config.HostLimit{RPS: cfg.HostRPS, Concurrency: cfg.HostConcurrency}, cfg.HostLimits, cfg.HostLimitWait),
+		events:    NopSink{},
+		bandwidth: newBandwidthLimiter(cfg.GlobalMaxBPS),
 	}
 }
 
 // Check параллельно проверяет доступность и валидность списка URL с помощью HTTP HEAD-запросов.
 //
-// Для каждого URL создаётся отдельный поток выполнения. Результаты собираются в срез []File
+// Проверки выполняются пулом из Concurrency потоков (если Concurrency <= 0 - отдельный поток
+// на каждый URL). Результаты собираются в срез []File
 // в том же порядке, что и входной срез urls. Даже если проверка некоторых URL завершается с ошибкой,
 // функция всё равно возвращает полный срез с заполненными полями Status, ErrorMsg и др.
 //
@@ -67,30 +121,66 @@ func (ldr *Loader) Check(ctx context.Context, urls []string) ([]File, error) {
 		return []File{file}, err
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(urls))
-
 	files := make([]File, len(urls))
 	errs := make([]error, len(urls))
 
-	for i, url := range urls {
-		go func(i int, url string) {
-			defer wg.Done()
-			file, err := ldr.CheckFile(ctx, url)
-			files[i] = file
-			errs[i] = err
-		}(i, url)
+	forEach(len(urls), ldr.cfg.Concurrency, func(i int) {
+		files[i], errs[i] = ldr.CheckFile(ctx, urls[i])
+	})
+
+	return files, errors.Join(errs...)
+}
+
+// CheckFirst параллельно проверяет список зеркал одного файла и завершается, как только найден
+// первый доступный файл разрешённого типа (Status == 200): остальные проверки отменяются.
+//
+// Проверки выполняются так же, как в Check (пул из Concurrency потоков), но побеждает первый
+// по времени ответ, а не первый по порядку URL. Возвращает результаты в порядке urls и индекс
+// победившего URL (-1, если доступных файлов нет). Отменённые и не начатые проверки отмечаются
+// как прерванные (Interrupted, статус 504), завершённые до отмены сохраняют свой результат. Ошибка - как в Check (фатальные ошибки и отмена ctx).
+func (ldr *Loader) CheckFirst(ctx context.Context, urls []string) ([]File, int, error) {
+	if len(urls) == 0 {
+		return nil, -1, nil
 	}
 
-	wg.Wait()
+	checkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	return files, errors.Join(errs...)
+	files := make([]File, len(urls))
+	errs := make([]error, len(urls))
+	winner := -1
+	var once sync.Once
+
+	forEach(len(urls), ldr.cfg.Concurrency, func(i int) {
+		if checkCtx.Err() == nil {
+			files[i], errs[i] = ldr.CheckFile(checkCtx, urls[i])
+		}
+		if files[i].Status == http.StatusOK {
+			once.Do(func() {
+				winner = i
+				cancel()
+			})
+			return
+		}
+		// проверка не начата или её запрос оборван отменой (ошибка запроса - 502):
+		// источник мог бы и ответить, а полученный до отмены ответ остаётся результатом
+		if checkCtx.Err() != nil && (files[i].Status == 0 || files[i].Status == http.StatusBadGateway) {
+			files[i] = File{URL: urls[i]}
+			setInterrupted(&files[i], checkCtx)
+			errs[i] = nil
+		}
+	})
+
+	if err := ctx.Err(); err != nil && winner < 0 {
+		errs = append(errs, err)
+	}
+	return files, winner, errors.Join(errs...)
 }
 
 // CheckFile проверяет один URL с помощью HEAD-запроса и возвращает информацию о файле.
 //
 // Основные этапы:
-//  1. Валидация URL (должен быть корректным URI).
+//  1. Валидация URL (должен быть корректным URI с разрешённой схемой, см. LOADER_ALLOW_SCHEMES).
 //  2. Отправка HEAD-запроса с использованием контекста.
 //  3. Проверка HTTP-статуса (ожидается 200 OK).
 //  4. Проверка Content-Type (должен быть разрешён).
@@ -110,6 +200,7 @@ func (ldr *Loader) Check(ctx context.Context, urls []string) ([]File, error) {
 // Особенности:
 //   - Тело ответа не читается (HEAD-запрос).
 //   - Если Status не 200, ErrorMsg автоматически заполняется текстом статуса (например, "Not Found").
+//   - ErrorMsg длиннее LOADER_MAX_ERROR_MSG_LEN обрезается с многоточием.
 //
 // Пример результата:
 //
@@ -118,14 +209,16 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 	log := logger.FromContext(ctx).With("op", "checkFile", "fileURL", uri)
 
 	file = File{URL: uri}
-	defer func() {
+	defer func(ctx context.Context) {
 		if file.Status != http.StatusOK && file.ErrorMsg == "" {
 			file.ErrorMsg = http.StatusText(file.Status)
 		}
-	}()
+		file.ErrorMsg = truncateErrorMsg(file.ErrorMsg, ldr.cfg.MaxErrorMsgLen)
+		ldr.emit(ctx, EventFileChecked, file) // с исходным контекстом, а не с ограниченным по времени
+	}(ctx)
 
-	// Валидация URL
-	url, err := url.ParseRequestURI(uri)
+	// Валидация URL (запрос выполняется по нормализованному URL)
+	url, err := ldr.parseURL(uri)
 	if err != nil {
 		file.Status = http.StatusBadRequest
 		file.ErrorMsg = fmt.Sprintf("invalid url: %v", err)
@@ -133,7 +226,18 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 		return file, nil
 	}
 
-	// Запрос заголовков
+	// Очередь к хосту (LOADER_HOST_CONCURRENCY) не входит во время проверки
+	release, err := ldr.hosts.acquire(ctx, url.Hostname())
+	defer release()
+	if err != nil {
+		setRequestError(log, &file, err)
+		return file, nil
+	}
+
+	// Запрос заголовков (проверка должна завершаться быстро, поэтому время ограничено целиком)
+	ctx, cancel := withTimeout(ctx, ldr.cfg.CheckTimeout)
+	defer cancel()
+
 	req, err := http.NewRequestWithContext(ctx, "HEAD", url.String(), nil)
 	if err != nil {
 		file.Status = http.StatusInternalServerError
@@ -141,22 +245,18 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 		return file, fmt.Errorf("create request failed: %w", err)
 	}
 
-	resp, err := ldr.client.Do(req)
+	resp, err := ldr.do(req)
 	if err != nil {
-		if errors.Is(err, protect.ErrSSRF) {
-			file.Status = http.StatusForbidden
-			log.Warn("SSRF attack blocked", "error", err)
-			return file, nil
-		}
-		file.Status = http.StatusBadGateway
-		log.Debug("request failed", "error", err)
+		setRequestError(log, &file, err)
+		ldr.emitRequestError(ctx, file, err)
 		return file, nil
 	}
 	resp.Body.Close()
 
 	// Проверка статуса
-	file.Status = resp.StatusCode
+	file.Status = ldr.responseStatus(resp)
 	if file.Status != http.StatusOK {
+		file.ErrorMsg = statusMessage(resp, file.Status)
 		log.Debug("unexpected status", "status", file.Status)
 		return file, nil
 	}
@@ -166,9 +266,26 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 	// Проверка Content-Type
 	file.ContentType = getContentType(resp)
 	if !ldr.valid[file.ContentType] {
-		file.Status = http.StatusForbidden
-		file.ErrorMsg = fmt.Sprintf("file type %q is not allowed", file.ContentType)
-		log.Debug("blocked by content-type", "contentType", file.ContentType)
+		if ldr.htmlPage(&file) {
+			log.Debug("origin returned html page", "contentType", file.ContentType)
+			return file, nil
+		}
+		if ldr.cfg.TrustMagic {
+			// реальный тип будет проверен по сигнатуре при загрузке
+			file.TypeMismatch = true
+			log.Warn("declared content-type is not allowed, real type will be checked on download", "contentType", file.ContentType)
+		} else {
+			file.Status = http.StatusForbidden
+			file.ErrorMsg = fmt.Sprintf("file type %q is not allowed", file.ContentType)
+			log.Debug("blocked by content-type", "contentType", file.ContentType)
+			ldr.emit(ctx, EventTypeRejected, file)
+			return file, nil
+		}
+	}
+
+	// Проверка размера по заявленному типу
+	if ldr.tooLarge(&file, file.ContentType, file.Size) {
+		log.Debug("blocked by size limit", "contentType", file.ContentType, "size", file.Size)
 		return file, nil
 	}
 
@@ -183,20 +300,33 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 // Для каждого URL:
 //  1. Выполняется GET-запрос.
 //  2. Проверяется HTTP-статус (ожидается 200 OK).
-//  3. Проверяется Content-Type (должен быть разрешён).
+//  3. Проверяется Content-Type (должен быть разрешён, кроме режима LOADER_TRUST_MAGIC_OVER_DECLARED,
+//     в котором решение принимается по реальному типу, а файл помечается флагом TypeMismatch).
 //  4. Читается первые 8 байт (магическая сигнатура) для определения реального типа файла.
-//  5. Если реальный тип не разрешён — загрузка прерывается с ошибкой.
+//  5. Если реальный тип не разрешён — загрузка прерывается с ошибкой. Если сигнатура неизвестна,
+//     файл отклоняется, а в режиме LOADER_SIGNATURE_CHECK=lenient принимается по разрешённому
+//     заявленному типу и помечается флагом Unverified.
 //  6. Файл записывается в ZIP-архив с уникальным именем.
 //
 // Дополнительно:
 //   - В архив добавляется файл `status.json` с информацией о всех загруженных файлах
-//     (включая те, что не были загружены).
+//     (включая те, что не были загружены). При включённом LOADER_STATUS_CSV тот же отчёт
+//     дублируется в `status.csv`.
+//   - При включённом LOADER_SHA256SUMS в архив добавляется файл `SHA256SUMS` с контрольными
+//     суммами загруженных файлов (проверяется командой `sha256sum -c SHA256SUMS`).
 //   - Все файлы именуются по шаблону: <basename>-<uniqueNum>.<ext>.
+//   - Повтор URL в urls загружается один раз: результат (статус, размер, типы) у повторов общий,
+//     но каждый получает свою запись со своим именем (копию записи первого вхождения).
+//   - Порядок записей гарантирован: сначала загруженные файлы (в порядке urls, повторно
+//     загруженные и повторы URL - после остальных), затем
+//     `SHA256SUMS` и `status.csv`, последней - `status.json`. Потоковый распаковщик
+//     получает все данные до отчёта.
 //
 // Параметры:
 //   - ctx: контекст с таймаутом и возможностью отмены.
 //   - urls: список URL для загрузки.
-//   - out: io.Writer, куда будет записан ZIP-архив (например, http.ResponseWriter).
+//   - out: io.Writer, куда будет записан архив (например, http.ResponseWriter).
+//   - arch: параметры архива (ID задачи, формат и т.п.), используются для метаданных архива.
 //
 // Возвращает:
 //   - []File: информация о каждом файле в том же порядке, что и urls.
@@ -208,51 +338,486 @@ func (ldr *Loader) CheckFile(ctx context.Context, uri string) (file File, _ erro
 //   - Всегда создает архив. Если передан пустой список urls будет создан пустой архив с пустым файлом статуса.
 //
 // Особенности:
-//   - Загрузка происходит последовательно (не параллельно), чтобы избежать перегрузки памяти.
+//   - По умолчанию файлы загружаются последовательно. При LOADER_DOWNLOAD_CONCURRENCY > 1 запросы
+//     к источникам выполняются параллельно, но записи создаются по очереди в порядке urls
+//     (см. archiveWriter), тела файлов не буферизуются в памяти. Фатальная ошибка одного файла
+//     отменяет загрузку остальных.
 //   - При ошибках чтения тела файла (например, обрыв соединения) — статус устанавливается в 502.
 //   - После успешной загрузки одного файла, процесс продолжается со следующим.
 //   - Даже если все файлы провалились, `status.json` всё равно записывается.
+//   - При отмене ctx (например, по дедлайну) архив завершается: прерванный и оставшиеся файлы
+//     получают статус 504 и флаг Interrupted, `status.json` записывается как обычно.
+//     При LOADER_FINALIZE_ON_CANCEL=no загрузка прекращается с ошибкой ctx.Err().
+//   - При MANAGER_MAX_TOTAL_SIZE > 0 суммарный размер файлов архива (без сжатия) ограничен:
+//     файл, который превысил бы ограничение, и все следующие не загружаются и получают
+//     статус 507, архив завершается как обычно.
+//   - При LOADER_RETRY_FAILED > 0 файлы, не загруженные из-за временной ошибки источника
+//     (408, 429, 502, 503, 504) до создания записи в архиве, загружаются повторно - до
+//     LOADER_RETRY_FAILED проходов. Записи повторно загруженных файлов следуют за остальными.
+//   - Архив собирается в формате arch.Format (см. Archiver): ZIP (по умолчанию) или tar.gz.
+//     Имена записей и status.json от формата не зависят. Запись tar требует размера до
+//     содержимого, поэтому тело без Content-Length в tar.gz сначала сохраняется во временный файл.
+//   - Файлы сжимаются deflate с уровнем LOADER_ZIP_LEVEL (по умолчанию - стандартный уровень),
+//     tar.gz сжимается gzip с тем же уровнем.
+//   - Если задан шаблон LOADER_ZIP_COMMENT, архиву ZIP устанавливается комментарий с метаданными
+//     (время создания, ID задачи, версия, количество файлов).
+//   - При включённом LOADER_VERIFY_ARCHIVE архив собирается во временный файл и отдаётся в out
+//     только после проверки всех записей (см. downloadVerified).
+//   - out == nil - ошибка ErrNilWriter до начала загрузки. Ошибка записи в out прерывает загрузку
+//     остальных файлов (как фатальная ошибка), в out после неё больше ничего не пишется, а
+//     возвращается ошибка ErrWriteOutput с исходной ошибкой out.
 //
 // Примечание: вызывающий код должен обрабатывать как возвращённый срез File,
 // так и наличие ошибки — они не взаимоисключающие.
-func (ldr *Loader) Download(ctx context.Context, urls []string, out io.Writer) ([]File, error) {
-	zipWriter := zip.NewWriter(out)
-	defer zipWriter.Close()
+func (ldr *Loader) Download(ctx context.Context, urls []string, out io.Writer, arch Archive) ([]File, error) {
+	return ldr.DownloadSeq(ctx, slices.Values(urls), len(urls), out, arch)
+}
 
-	var failed int
+// DownloadSeq собирает архив, как Download, но URL читаются из последовательности urls по мере
+// загрузки пачками по batch штук (batch <= 0 - все сразу): в памяти держатся URL одной пачки,
+// поэтому список может быть сколь угодно длинным (результаты файлов для status.json по-прежнему
+// накапливаются). Пачка загружается целиком, включая повторные проходы (LOADER_RETRY_FAILED),
+// до чтения следующей. Повторы URL находятся в пределах пачки: повтор из другой пачки
+// загружается заново.
+func (ldr *Loader) DownloadSeq(ctx context.Context, urls iter.Seq[string], batch int, out io.Writer, arch Archive) ([]File, error) {
+	if isNilWriter(out) {
+		return nil, ErrNilWriter
+	}
+	if ldr.cfg.VerifyArchive {
+		return ldr.downloadVerified(ctx, urls, batch, out, arch)
+	}
+	return ldr.download(ctx, urls, batch, out, arch)
+}
+
+// download собирает архив, записывая его в out по мере загрузки файлов.
+func (ldr *Loader) download(ctx context.Context, urls iter.Seq[string], batch int, out io.Writer, arch Archive) (_ []File, err error) {
+	// фатальная ошибка одного файла или ошибка записи в out прерывает загрузку остальных
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// ошибка записи в out важнее ошибки, с которой она проявилась (запись, закрытие архива)
+	ow := &outputWriter{w: out, fail: cancel}
+	defer func() {
+		if ow.err != nil {
+			err = fmt.Errorf("%w: %w", ErrWriteOutput, ow.err)
+		}
+	}()
+
+	ar, err := NewArchiver(arch.Format, ow, ldr.cfg.ZipLevel)
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
 
-	files := make([]File, 0, len(urls))
-	for i, url := range urls {
-		file, err := ldr.downloadFile(ctx, zipWriter, url, i+1)
-		files = append(files, file)
+	aw := &archiveWriter{ar: ar, sized: sizedEntries(arch.Format), budget: ldr.cfg.MaxTotalSize, ordered: ldr.hosts != nil}
 
+	files := []File{}
+	var sums []string
+	for urls := range batches(urls, batch) {
+		bf, bs, err := ldr.downloadBatch(ctx, fetchCtx, cancel, aw, urls, len(files), arch)
+		files = append(files, bf...)
+		sums = append(sums, bs...)
 		if err != nil {
 			return files, err
 		}
+	}
 
-		if file.Status != http.StatusOK {
+	var failed int
+	for i := range files {
+		if files[i].Status != http.StatusOK {
 			failed++
 		}
 	}
 
-	if err := ldr.writeStatus(zipWriter, files); err != nil {
+	if ldr.cfg.SHA256Sums {
+		if err := ldr.writeEntry(ar, sha256SumsName, strings.Join(sums, "")); err != nil {
+			return files, err
+		}
+	}
+
+	if ldr.cfg.StatusCSV {
+		if err := ldr.writeStatusCSV(ar, files); err != nil {
+			return files, err
+		}
+	}
+
+	// status.json всегда последняя запись архива
+	if err := ldr.writeStatus(ar, files, aw.entries); err != nil {
 		return files, err
 	}
 
+	// комментарий есть только у ZIP
+	if zw, ok := ar.(*zipArchiver); ok && ldr.cfg.ZipComment != nil {
+		ldr.writeComment(ctx, zw, arch, len(files)-failed, len(files))
+	}
+
+	// центральный каталог ZIP (конец потока gzip) пишется при закрытии: ошибка записи означает неполный архив
+	if err := ar.Close(); err != nil {
+		return files, fmt.Errorf("close archive failed: %w", err)
+	}
 	return files, nil
 }
 
-func (ldr *Loader) writeStatus(zw *zip.Writer, files []File) error {
-	fw, err := zw.Create("status.json")
+// downloadBatch загружает в архив пачку URL (см. DownloadSeq) и возвращает результаты и строки
+// контрольных сумм её файлов. offset - количество файлов предыдущих пачек: номера файлов
+// (uniqueNum) продолжают нумерацию архива.
+func (ldr *Loader) downloadBatch(ctx, fetchCtx context.Context, cancel context.CancelFunc, aw *archiveWriter, urls []string, offset int, arch Archive) ([]File, []string, error) {
+	// повторы URL загружаются один раз, их записи копируются после остальных файлов
+	dups, err := newDuplicates(urls)
 	if err != nil {
-		return fmt.Errorf("create zip entry failed: %w", err)
+		return nil, nil, err
+	}
+	defer dups.close()
+
+	files := make([]File, len(urls))
+	sums := make([]string, len(urls))
+
+	// по умолчанию (0) файлы загружаются последовательно, <0 - без ограничений
+	workers := ldr.cfg.DownloadConcurrency
+	if workers == 0 {
+		workers = 1
+	}
+
+	// report сообщает вызывающему коду окончательный результат файла (Archive.OnFile)
+	reported := make([]bool, len(urls))
+	report := func(i int) {
+		if arch.OnFile != nil && !reported[i] {
+			reported[i] = true
+			arch.OnFile(files[i])
+		}
+	}
+
+	// pass загружает файлы с индексами idx (записи создаются в порядке idx) и возвращает
+	// индекс файла с фатальной ошибкой. Файлы, которые могут быть повторены следующим проходом
+	// (retry - номер прохода меньше LOADER_RETRY_FAILED), не сообщаются.
+	pass := func(idx []int, retry bool) (int, error) {
+		aw.reset(len(idx))
+		errs := make([]error, len(idx))
+		forEach(len(idx), workers, func(j int) {
+			i := idx[j]
+			slot := aw.slot(j)
+			slot.tee = dups.tee(i)
+			defer slot.done()
+
+			files[i], errs[j] = ldr.downloadOne(ctx, fetchCtx, slot, urls[i], offset+i+1, &sums[i])
+			if errs[j] != nil {
+				cancel()
+			}
+			if !retry || !retryable(&files[i]) {
+				report(i)
+			}
+		})
+		for j, err := range errs {
+			if err != nil {
+				return idx[j], err
+			}
+		}
+		return 0, nil
+	}
+
+	// при фатальной ошибке возвращаются файлы до неё включительно
+	if i, err := pass(dups.unique(), ldr.cfg.RetryFailed > 0); err != nil {
+		for _, d := range dups.repeated() {
+			if d < i {
+				files[d] = File{URL: urls[d]}
+				setInterrupted(&files[d], fetchCtx)
+			}
+		}
+		return files[:i+1], sums[:i+1], err
+	}
+
+	// повторные проходы по файлам с временной ошибкой (LOADER_RETRY_FAILED)
+	for attempt := 1; attempt <= ldr.cfg.RetryFailed && fetchCtx.Err() == nil; attempt++ {
+		var idx []int
+		for i := range files {
+			if retryable(&files[i]) {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 {
+			break
+		}
+		logger.FromContext(ctx).Debug("retry failed files", "attempt", attempt, "files", len(idx))
+		if _, err := pass(idx, attempt < ldr.cfg.RetryFailed); err != nil {
+			return files, sums, err
+		}
+	}
+	if err := ldr.writeDuplicates(ctx, fetchCtx, aw, dups, files, sums, offset); err != nil {
+		return files, sums, err
+	}
+	for i := range files {
+		report(i) // файлы, повтор которых не состоялся (отмена)
+	}
+	return files, sums, nil
+}
+
+// commentData - данные, доступные в шаблоне комментария архива.
+type commentData struct {
+	Time    time.Time // время создания архива
+	TaskID  int64     // ID задачи (0 вне задачи)
+	Version string    // версия zipget
+	Files   int       // количество файлов в архиве
+	Total   int       // количество запрошенных файлов
+}
+
+// writeComment устанавливает комментарий архива по шаблону.
+// Ошибки не фатальны: архив остаётся корректным и без комментария.
+func (ldr *Loader) writeComment(ctx context.Context, zw *zipArchiver, arch Archive, archived, total int) {
+	log := logger.FromContext(ctx).With("op", "writeComment")
+
+	var sb strings.Builder
+	err := ldr.cfg.ZipComment.Execute(&sb, commentData{
+		Time:    time.Now().UTC(),
+		TaskID:  arch.TaskID,
+		Version: version.Version,
+		Files:   archived,
+		Total:   total,
+	})
+	if err != nil {
+		log.Warn("execute comment template failed", "error", err)
+		return
+	}
+
+	if err := zw.SetComment(sb.String()); err != nil {
+		log.Warn("set comment failed", "error", err)
+	}
+}
+
+// sha256SumsName - имя файла контрольных сумм в формате `sha256sum` (`<hash>  <filename>`).
+const sha256SumsName = "SHA256SUMS"
+
+func (ldr *Loader) writeEntry(ar Archiver, name, content string) error {
+	fw, err := ldr.createEntry(ar, name)
+	if err != nil {
+		return fmt.Errorf("create archive entry failed: %w", err)
+	}
+	_, err = io.WriteString(fw, content)
+	return err
+}
+
+func (ldr *Loader) writeStatus(ar Archiver, files []File, entries archiveEntries) error {
+	// создание записи закрывает предыдущую: размеры всех записей файлов уже известны
+	fw, err := ldr.createEntry(ar, "status.json")
+	if err != nil {
+		return fmt.Errorf("create archive entry failed: %w", err)
 	}
 	cdr := json.NewEncoder(fw)
 	cdr.SetIndent("", "    ")
-	return cdr.Encode(files)
+	return cdr.Encode(ldr.statusReport(files, entries))
+}
+
+// archiveEntries - заголовки записей файлов в архиве. Размеры в заголовке заполняются
+// архиватором при закрытии записи (т.е. при создании следующей).
+type archiveEntries []*EntryHeader
+
+// sizes возвращает суммарный размер записей до и после сжатия (compressed < 0 - неизвестен,
+// например в tar.gz, который сжимается целиком).
+func (e archiveEntries) sizes() (uncompressed, compressed int64) {
+	for _, h := range e {
+		uncompressed += h.Written
+		if h.Compressed < 0 || compressed < 0 {
+			compressed = -1
+		} else {
+			compressed += h.Compressed
+		}
+	}
+	return uncompressed, compressed
+}
+
+// statusSummary - сводка отчёта о загрузке.
+type statusSummary struct {
+	Total         int            `json:"total"`           // количество запрошенных файлов
+	Archived      int            `json:"archived"`        // количество файлов в архиве
+	ByStatusClass map[string]int `json:"by_status_class"` // количество файлов по классам статусов ("2xx", "4xx", ...)
+	TotalBytes    int64          `json:"total_bytes"`     // прочитано байт всего
+	ArchivedBytes int64          `json:"archived_bytes"`  // байт в архиве (без сжатия)
+
+	// Размеры записей файлов в архиве (при LOADER_STATUS_ARCHIVE_SIZES). Учитываются все созданные
+	// записи, в т.ч. прерванные; служебные записи (SHA256SUMS, status.csv, status.json) - нет.
+	UncompressedBytes *int64 `json:"uncompressed_bytes,omitempty"` // до сжатия
+	CompressedBytes   *int64 `json:"compressed_bytes,omitempty"`   // после сжатия
+}
+
+type statusWithSummary struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Summary     statusSummary `json:"summary"`
+	Files       []File        `json:"files"`
+}
+
+// maskFiles возвращает копию files, в URL которых скрыты параметры URL_MASK_QUERY_PARAMS.
+func (ldr *Loader) maskFiles(files []File) []File {
+	if len(ldr.cfg.MaskQueryParams) == 0 {
+		return files
+	}
+	masked := slices.Clone(files)
+	for i := range masked {
+		masked[i].URL = urlutil.MaskQuery(masked[i].URL, ldr.cfg.MaskQueryParams)
+	}
+	return masked
+}
+
+// statusReport возвращает содержимое status.json: массив файлов или, при включённом
+// LOADER_STATUS_SUMMARY (или LOADER_STATUS_ARCHIVE_SIZES), объект со сводкой и тем же
+// массивом в поле files. entries - записи файлов в архиве (nil, если архив не собирается).
+func (ldr *Loader) statusReport(files []File, entries archiveEntries) any {
+	files = ldr.maskFiles(files)
+	if !ldr.cfg.StatusSummary && !ldr.cfg.StatusArchiveSizes {
+		return files
+	}
+
+	summary := statusSummary{
+		Total:         len(files),
+		ByStatusClass: make(map[string]int),
+	}
+	for i := range files {
+		f := &files[i]
+		summary.ByStatusClass[strconv.Itoa(f.Status/100)+"xx"]++
+		summary.TotalBytes += f.Size
+		if f.Status == http.StatusOK {
+			summary.Archived++
+			summary.ArchivedBytes += f.Size
+		}
+	}
+	if ldr.cfg.StatusArchiveSizes && entries != nil {
+		uncompressed, compressed := entries.sizes()
+		summary.UncompressedBytes = &uncompressed
+		if compressed >= 0 {
+			summary.CompressedBytes = &compressed
+		}
+	}
+
+	return statusWithSummary{
+		GeneratedAt: time.Now().UTC(),
+		Summary:     summary,
+		Files:       files,
+	}
+}
+
+var statusCSVHeader = []string{"url", "name", "size", "content_type", "real_type", "status", "error"}
+
+// writeStatusCSV дублирует отчёт в `status.csv` для открытия в табличных редакторах.
+func (ldr *Loader) writeStatusCSV(ar Archiver, files []File) error {
+	fw, err := ldr.createEntry(ar, "status.csv")
+	if err != nil {
+		return fmt.Errorf("create archive entry failed: %w", err)
+	}
+	cw := csv.NewWriter(fw)
+	if err := cw.Write(statusCSVHeader); err != nil {
+		return err
+	}
+	for _, f := range ldr.maskFiles(files) {
+		err := cw.Write([]string{
+			f.URL,
+			f.Name,
+			strconv.FormatInt(f.Size, 10),
+			f.ContentType,
+			f.RealType,
+			strconv.Itoa(f.Status),
+			f.ErrorMsg,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// createEntry создаёт служебную запись архива (размер заранее неизвестен) с режимом LOADER_ENTRY_MODE.
+func (ldr *Loader) createEntry(ar Archiver, name string) (io.Writer, error) {
+	return ar.CreateEntry(&EntryHeader{Name: name, Size: -1, Method: zip.Deflate, Mode: ldr.cfg.EntryMode})
+}
+
+// Методы сжатия файлов в архиве (config.Loader.ZipCompression)
+const (
+	ZipCompressionAuto    = "auto"    // по типу файла: уже сжатые хранятся без сжатия
+	ZipCompressionStore   = "store"   // без сжатия
+	ZipCompressionDeflate = "deflate" // deflate
+)
+
+// zipMethod возвращает метод сжатия записи для файла типа ft (LOADER_ZIP_COMPRESSION).
+func (ldr *Loader) zipMethod(ft FileType) uint16 {
+	switch ldr.cfg.ZipCompression {
+	case ZipCompressionStore:
+		return zip.Store
+	case ZipCompressionDeflate:
+		return zip.Deflate
+	}
+	if ft.Compressed {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// entryComment возвращает комментарий записи архива: исходный URL без учётных данных
+// (режим LOADER_ENTRY_URL_COMMENT) или пустую строку.
+func (ldr *Loader) entryComment(uri string) string {
+	if !ldr.cfg.EntryURL {
+		return ""
+	}
+	return urlutil.Redact(urlutil.MaskQuery(uri, ldr.cfg.MaskQueryParams))
+}
+
+// downloadOne загружает один файл архива. ctx - контекст загрузки, fetchCtx - производный от него
+// контекст, отменяемый также при фатальной ошибке другого файла. Строка контрольной суммы
+// (LOADER_SHA256SUMS) загруженного файла записывается в sumLine.
+func (ldr *Loader) downloadOne(ctx, fetchCtx context.Context, slot *archiveSlot, uri string, uniqueNum int, sumLine *string) (File, error) {
+	// после превышения ограничения архива оставшиеся файлы не загружаются
+	if slot.exhausted() {
+		file := File{URL: uri}
+		setBudgetExceeded(&file)
+		return file, nil
+	}
+
+	// после отмены оставшиеся файлы не загружаются, но архив завершается
+	// (при LOADER_FINALIZE_ON_CANCEL=no загрузка прекращается с ошибкой)
+	if fetchCtx.Err() != nil {
+		file := File{URL: uri}
+		setInterrupted(&file, fetchCtx)
+		if ctx.Err() != nil && !ldr.cfg.FinalizeOnCancel {
+			return file, ctx.Err()
+		}
+		return file, nil
+	}
+
+	start := time.Now()
+	file, err := ldr.downloadFile(fetchCtx, slot, uri, uniqueNum)
+	ldr.checkSlow(fetchCtx, uri, file, time.Since(start))
+	metrics.Download(file.Status, file.Size)
+	if err == nil && file.Status != http.StatusOK && ctx.Err() != nil {
+		// загрузка прервана отменой (запись в архиве, если создана, содержит начало файла)
+		setInterrupted(&file, ctx)
+	}
+	ldr.emit(fetchCtx, EventFileDownloaded, file)
+	if err == nil && file.Status == http.StatusOK && ldr.cfg.SHA256Sums {
+		*sumLine = file.SHA256 + "  " + file.Name + "\n"
+	}
+	return file, err
 }
 
-func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri string, uniqueNum int) (file File, _ error) {
+// checkSlow сообщает в журнал (уровень warn) о загрузке файла, длившейся дольше LOADER_SLOW_DOWNLOAD
+// (0 - не сообщать), чтобы медленные источники были видны и без отладочного журнала.
+func (ldr *Loader) checkSlow(ctx context.Context, uri string, file File, elapsed time.Duration) {
+	if ldr.cfg.SlowDownload <= 0 || elapsed <= ldr.cfg.SlowDownload {
+		return
+	}
+	var host string
+	if url, err := ldr.parseURL(uri); err == nil {
+		host = url.Hostname()
+	}
+	logger.FromContext(ctx).Warn("slow download",
+		"op", "downloadFile",
+		"fileURL", uri,
+		"host", host,
+		"elapsed", elapsed,
+		"status", file.Status,
+		"size", file.Size,
+	)
+}
+
+// downloadFile скачивает файл в новую запись архива, подсчитывая контрольную сумму SHA-256
+// содержимого (заполняется только для загруженного файла).
+func (ldr *Loader) downloadFile(ctx context.Context, slot *archiveSlot, uri string, uniqueNum int) (file File, _ error) {
 	log := logger.FromContext(ctx).With("op", "downloadFile", "fileURL", uri).With("uniqueNum", uniqueNum)
 
 	file = File{URL: uri}
@@ -260,10 +825,11 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 		if file.Status != http.StatusOK && file.ErrorMsg == "" {
 			file.ErrorMsg = http.StatusText(file.Status)
 		}
+		file.ErrorMsg = truncateErrorMsg(file.ErrorMsg, ldr.cfg.MaxErrorMsgLen)
 	}()
 
-	// Валидация URL
-	url, err := url.ParseRequestURI(uri)
+	// Валидация URL (запрос выполняется по нормализованному URL)
+	url, err := ldr.parseURL(uri)
 	if err != nil {
 		file.Status = http.StatusBadRequest
 		file.ErrorMsg = fmt.Sprintf("invalid url: %v", err)
@@ -271,7 +837,21 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 		return file, nil
 	}
 
-	// Запрос файла
+	// Очередь к хосту (LOADER_HOST_CONCURRENCY): место занимается на всю загрузку файла,
+	// включая повторы и докачку, в порядке записей архива
+	slot.waitStart()
+	release, err := ldr.hosts.acquire(ctx, url.Hostname())
+	slot.started()
+	defer release()
+	if err != nil {
+		setRequestError(log, &file, err)
+		return file, nil
+	}
+
+	// Запрос файла (время ограничено только до получения заголовков, тело может передаваться долго)
+	ctx, stopTimer, cancel := withHeaderTimeout(ctx, ldr.cfg.DownloadHeaderTimeout)
+	defer cancel()
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 	if err != nil {
 		file.Status = http.StatusInternalServerError
@@ -279,46 +859,53 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 		return file, fmt.Errorf("create request failed: %w", err)
 	}
 
-	resp, err := ldr.client.Do(req)
+	resp, err := ldr.do(req)
+	stopTimer()
 	if err != nil {
-		if errors.Is(err, protect.ErrSSRF) {
-			file.Status = http.StatusForbidden
-			log.Warn("SSRF attack blocked", "error", err)
-			return file, nil
-		}
-		file.Status = http.StatusBadGateway
-		log.Debug("request failed", "error", err)
+		setRequestError(log, &file, err)
+		ldr.emitRequestError(ctx, file, err)
 		return file, nil
 	}
 	defer resp.Body.Close()
 
 	// Проверка статуса
-	file.Status = resp.StatusCode
+	file.Status = ldr.responseStatus(resp)
 	if file.Status != http.StatusOK {
+		file.ErrorMsg = statusMessage(resp, file.Status)
 		log.Debug("unexpected status", "status", file.Status)
 		return file, nil
 	}
 
 	// Проверка Content-Type
 	file.ContentType = getContentType(resp)
-	if !ldr.valid[file.ContentType] {
+	if !ldr.valid[file.ContentType] && ldr.htmlPage(&file) {
+		log.Debug("origin returned html page", "contentType", file.ContentType)
+		return file, nil
+	}
+	if !ldr.valid[file.ContentType] && !ldr.cfg.TrustMagic {
 		file.Status = http.StatusForbidden
 		file.ErrorMsg = fmt.Sprintf("file type %q is not allowed", file.ContentType)
 		log.Debug("blocked by content-type", "contentType", file.ContentType)
+		ldr.emit(ctx, EventTypeRejected, file)
 		return file, nil
 	}
 
 	file.OrigName = getFileName(resp)
 
+	// Оборванная загрузка докачивается запросами Range (режим LOADER_ENABLE_RANGE)
+	var src io.Reader = resp.Body
+	ranged := ldr.rangeResumable(resp)
+	if ranged {
+		rr := ldr.newRangeReader(ctx, log, resp)
+		defer rr.Close()
+		src = rr
+	}
+	body := ldr.throttle(ctx, src)
 	buf := make([]byte, bufSize)
 	var readErr error
 
 	// Чтение первого чанка (нужен для проверки сигнатуру)
-	for file.Size < magicLen && readErr == nil {
-		var n int
-		n, readErr = resp.Body.Read(buf[file.Size:])
-		file.Size += int64(n)
-	}
+	file.Size, readErr = readUpTo(body, buf, 0, magicLen)
 	if readErr != nil && readErr != io.EOF {
 		file.Status = http.StatusBadGateway
 		log.Debug("first chank read failed", "error", readErr)
@@ -327,30 +914,142 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 
 	// Проверка сигнатуры
 	magic := buf[:min(magicLen, file.Size)]
-	fileType, err := getFileTypeBySignature(magic)
-	if err != nil {
+	fileType, err := getFileTypeBySignature(ldr.types, magic)
+
+	// Сигнатуры нет в таблице: тип определяется по началу файла стандартным http.DetectContentType
+	var sniffed string
+	if err != nil && !ldr.trustedHost(resp) {
+		if readErr == nil {
+			file.Size, readErr = readUpTo(body, buf, file.Size, sniffLen)
+		}
+		if readErr != nil && readErr != io.EOF {
+			file.Status = http.StatusBadGateway
+			log.Debug("first chank read failed", "error", readErr)
+			return file, nil
+		}
+		sniffed = sniffContentType(buf[:file.Size])
+	}
+
+	switch {
+	case ldr.trustedHost(resp) && ldr.valid[file.ContentType]:
+		// Доверенный хост (LOADER_TRUSTED_HOSTS): сигнатура не проверяется, файл принимается по заявленному типу
+		fileType, _ = getFileTypeByMIME(ldr.types, file.ContentType)
+		file.RealType = ""
+		file.Unverified = true
+		log.Warn("signature check skipped for trusted host", "host", resp.Request.URL.Hostname(), "contentType", file.ContentType)
+
+	case err == nil:
+		file.RealType = fileType.MIMEType
+		if !ldr.valid[file.RealType] {
+			file.Status = http.StatusForbidden
+			file.ErrorMsg = fmt.Sprintf("file type %q is not allowed", file.RealType)
+			log.Debug("blocked by real file type", "realType", file.RealType)
+			ldr.emit(ctx, EventTypeRejected, file)
+			return file, nil
+		}
+
+	case ldr.valid[sniffed]:
+		// Тип, определённый http.DetectContentType, разрешён
+		file.RealType = sniffed
+		if fileType, err = getFileTypeByMIME(ldr.types, sniffed); err != nil {
+			fileType = FileType{MIMEType: sniffed}
+		}
+		log.Debug("file type detected by content sniffing", "realType", sniffed)
+
+	case ldr.cfg.SignatureCheck == SignatureLenient && ldr.valid[file.ContentType]:
+		// Сигнатура неизвестна, но заявленный тип разрешён: принимаем файл без подтверждения типа
+		fileType, _ = getFileTypeByMIME(ldr.types, file.ContentType)
+		file.Unverified = true
+		log.Warn("unknown file signature, accepted by declared content-type", "contentType", file.ContentType)
+
+	default:
 		file.Status = http.StatusForbidden
 		file.ErrorMsg = err.Error()
 		log.Debug("can't check real file type", "error", err)
+		ldr.emit(ctx, EventTypeRejected, file)
 		return file, nil
 	}
 
-	file.RealType = fileType.MIMEType
-	if !ldr.valid[file.RealType] {
-		file.Status = http.StatusForbidden
-		file.ErrorMsg = fmt.Sprintf("file type %q is not allowed", file.RealType)
-		log.Debug("blocked by real file type", "realType", file.RealType)
+	// Заявленный тип запрещён, но реальный разрешён (режим LOADER_TRUST_MAGIC_OVER_DECLARED)
+	if !ldr.valid[file.ContentType] {
+		file.TypeMismatch = true
+		log.Warn("declared content-type is not allowed, accepted by real type",
+			"contentType", file.ContentType, "realType", file.RealType)
+	}
+
+	// Проверка размера по типу, с которым файл принят (заявленный или уже прочитанный)
+	if size := max(getContentLength(resp), file.Size); ldr.tooLarge(&file, fileType.MIMEType, size) {
+		log.Debug("blocked by size limit", "type", fileType.MIMEType, "size", size)
+		return file, nil
+	}
+
+	// Тело без Content-Length может оказаться больше ограничения (файла или архива): чтобы
+	// не оставить в архиве обрезанную запись, оно сначала сохраняется во временный файл
+	// (не больше ограничения). Тело с Content-Length клиент не читает дальше заявленного размера.
+	// Докачиваемое тело тоже сохраняется во временный файл: запись архива нельзя переписать,
+	// поэтому в архив попадает только полностью полученный файл.
+	expected := max(getContentLength(resp), file.Size)
+	spoolMax := int64(-1)
+	if limit := ldr.spoolLimit(fileType.MIMEType); limit > 0 && resp.ContentLength < 0 {
+		spoolMax = limit - file.Size
+	} else if ranged {
+		spoolMax = resp.ContentLength - file.Size
+	} else if resp.ContentLength < 0 && slot.aw.sized {
+		// tar.gz: размер записи пишется в заголовок до содержимого
+		spoolMax = math.MaxInt64 / 2
+	}
+	if spoolMax >= 0 && readErr == nil {
+		spool, n, err := spoolBody(body, spoolMax)
+		if spool != nil {
+			defer func() {
+				spool.Close()
+				os.Remove(spool.Name())
+			}()
+		}
+		switch {
+		case spool == nil:
+			file.Status = http.StatusInternalServerError
+			log.Error("spool body failed", "error", err)
+			return file, err
+		case err != nil:
+			file.Status = http.StatusBadGateway
+			log.Debug("read failed", "error", err)
+			return file, nil
+		case ldr.tooLarge(&file, fileType.MIMEType, file.Size+n):
+			log.Debug("size limit exceeded while reading", "type", fileType.MIMEType, "size", file.Size+n)
+			return file, nil
+		}
+		body = spool
+		expected = file.Size + n
+	}
+
+	// Проверка общего ограничения архива (MANAGER_MAX_TOTAL_SIZE) в очереди записи
+	if remaining, ok := slot.reserve(expected); !ok {
+		setBudgetExceeded(&file)
+		file.ErrorMsg += fmt.Sprintf(": file size %d, remaining %d", expected, remaining)
+		log.Debug("blocked by archive size limit", "size", expected, "remaining", remaining)
 		return file, nil
 	}
 
 	// Создание файла в архиве
-	file.Name = constructFileName(file.OrigName, fileType.Extension(), uniqueNum)
-	fileWriter, err := zipWriter.Create(file.Name)
+	file.Extension = fileType.Extension()
+	file.Name = constructFileName(file.OrigName, file.Extension, uniqueNum)
+	header := &EntryHeader{
+		Name:    file.Name,
+		Size:    expected,
+		Method:  ldr.zipMethod(fileType),
+		Comment: ldr.entryComment(file.URL),
+		Mode:    ldr.cfg.EntryMode,
+	}
+	var fileWriter io.Writer
+	fileWriter, err = slot.create(header)
 	if err != nil {
 		file.Status = http.StatusInternalServerError
-		log.Error("create zip entry failed", "error", err)
-		return file, fmt.Errorf("create zip entry failed: %w", err)
+		log.Error("create archive entry failed", "error", err)
+		return file, fmt.Errorf("create archive entry failed: %w", err)
 	}
+	sum := sha256.New()
+	fileWriter = io.MultiWriter(fileWriter, sum)
 
 	// Запись первого чанка
 	if file.Size > 0 {
@@ -364,12 +1063,18 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 	// Копирование оставшихся данных
 	for readErr == nil {
 		var n int
-		n, readErr = resp.Body.Read(buf)
+		n, readErr = body.Read(buf)
 		if n == 0 {
 			continue
 		}
 		file.Size += int64(n)
 
+		// заявленный размер мог быть не указан или занижен
+		if ldr.tooLarge(&file, fileType.MIMEType, file.Size) {
+			log.Debug("size limit exceeded while reading", "type", fileType.MIMEType, "size", file.Size)
+			return file, nil
+		}
+
 		if _, err := fileWriter.Write(buf[:n]); err != nil {
 			file.Status = http.StatusInternalServerError
 			log.Error("write failed", "error", err)
@@ -383,6 +1088,7 @@ func (ldr *Loader) downloadFile(ctx context.Context, zipWriter *zip.Writer, uri
 		return file, nil
 	}
 
+	file.SHA256 = hex.EncodeToString(sum.Sum(nil))
 	log.Debug("success")
 	return file, nil
 }
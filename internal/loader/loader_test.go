@@ -0,0 +1,1887 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"zipget/internal/config"
+	"zipget/internal/logger"
+	"zipget/internal/protect"
+	"zipget/internal/version"
+
+	"github.com/nalgeon/be"
+)
+
+var (
+	jpegData = append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, bytes.Repeat([]byte("jpeg"), 256)...)
+	pdfData  = append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("pdf "), 256)...)
+)
+
+// newTestLoader создаёт загрузчик с обычным клиентом (без SSRF-защиты), чтобы ходить на httptest-сервер.
+func newTestLoader(cfg config.Loader) *Loader {
+	if cfg.AllowMIMETypes == nil {
+		cfg.AllowMIMETypes = []string{"image/jpeg", "application/pdf"}
+	}
+	return New(http.DefaultClient, cfg)
+}
+
+// serveFile отдаёт body с заданным Content-Type.
+func serveFile(contentType string, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}
+}
+
+// download скачивает urls в архив в памяти и возвращает его ридер.
+func download(t *testing.T, ldr *Loader, urls []string, arch Archive) ([]File, *zip.Reader) {
+	t.Helper()
+	var buf bytes.Buffer
+	files, err := ldr.Download(context.Background(), urls, &buf, arch)
+	be.Err(t, err, nil)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	be.Err(t, err, nil)
+	return files, zr
+}
+
+func TestDownload_Comment(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	tmpl := template.Must(template.New("").Parse("zipget {{.Version}} task={{.TaskID}} files={{.Files}}/{{.Total}}"))
+	ldr := newTestLoader(config.Loader{ZipComment: tmpl})
+
+	_, zr := download(t, ldr, []string{srv.URL + "/a.jpg", srv.URL + "/b.jpg", "bad url"}, Archive{TaskID: 42})
+	be.Equal(t, zr.Comment, "zipget "+version.Version+" task=42 files=2/3")
+}
+
+func TestDownload_NoComment(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	_, zr := download(t, newTestLoader(config.Loader{}), []string{srv.URL}, Archive{TaskID: 42})
+	be.Equal(t, zr.Comment, "")
+}
+
+func TestDownload_CommentTime(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	tmpl := template.Must(template.New("").Parse(`{{.Time.Format "2006"}}`))
+	_, zr := download(t, newTestLoader(config.Loader{ZipComment: tmpl}), []string{srv.URL}, Archive{})
+	be.Equal(t, len(zr.Comment), 4)
+	be.True(t, !strings.Contains(zr.Comment, "<no value>"))
+}
+
+// flakyHandler первые fails запросов отвечает 503 с заголовком Retry-After, затем отдаёт файл.
+func flakyHandler(fails int, retryAfter string, next http.Handler) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := fails > 0
+		fails--
+		mu.Unlock()
+
+		if fail {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func TestDownload_RetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retries    int
+		retryAfter string
+		want       int
+	}{
+		{"no_retries", 0, "0", http.StatusServiceUnavailable},
+		{"retried", 1, "1", http.StatusOK},                               // 1s урезается MaxRetryAfter
+		{"http_date", 1, "Thu, 01 Jan 1970 00:00:00 GMT", http.StatusOK}, // дата в прошлом - без задержки
+		{"invalid_header", 1, "soon", http.StatusOK},                     // повтор с задержкой RetryBackoff
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(flakyHandler(1, tt.retryAfter, serveFile("image/jpeg", jpegData)))
+			defer srv.Close()
+
+			ldr := newTestLoader(config.Loader{Retries: tt.retries, MaxRetryAfter: 10 * time.Millisecond})
+			files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+		})
+	}
+}
+
+func TestDownload_RetryBackoff(t *testing.T) {
+	const backoff = 10 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		status   int // 0 - разрыв соединения
+		retries  int
+		want     int
+		wantHits int
+	}{
+		{"bad_gateway", http.StatusBadGateway, 2, http.StatusOK, 3},
+		{"reset", 0, 2, http.StatusOK, 3},
+		{"exhausted", http.StatusBadGateway, 1, http.StatusBadGateway, 2},
+		{"not_found", http.StatusNotFound, 2, http.StatusNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			next := serveFile("image/jpeg", jpegData)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := hits.Add(1); n > 2 && tt.status != http.StatusNotFound {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if tt.status == 0 {
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			ldr := newTestLoader(config.Loader{Retries: tt.retries, RetryBackoff: backoff})
+			start := time.Now()
+			files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+			be.Equal(t, int(hits.Load()), tt.wantHits)
+			if tt.wantHits == 3 {
+				be.True(t, time.Since(start) >= backoff+2*backoff) // задержка удваивается
+			}
+		})
+	}
+}
+
+func TestCheck_RetryAfterDeadline(t *testing.T) {
+	srv := httptest.NewServer(flakyHandler(1, "10", serveFile("image/jpeg", jpegData)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// задержка больше оставшегося времени - не ждём, возвращаем 503
+	ldr := newTestLoader(config.Loader{Retries: 1, MaxRetryAfter: time.Minute})
+	start := time.Now()
+	file, err := ldr.CheckFile(ctx, srv.URL)
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusServiceUnavailable)
+	be.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestDownload_StatusCSV(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ok", serveFile("image/jpeg", jpegData))
+	mux.HandleFunc("/tricky", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/plain, "quoted"`)
+		w.Write([]byte("text"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{StatusCSV: true})
+	files, zr := download(t, ldr, []string{srv.URL + "/ok", srv.URL + "/tricky", srv.URL + "/missing"}, Archive{})
+
+	rc, err := zr.Open("status.csv")
+	be.Err(t, err, nil)
+	defer rc.Close()
+
+	records, err := csv.NewReader(rc).ReadAll()
+	be.Err(t, err, nil)
+	be.Equal(t, len(records), len(files)+1)
+	be.Equal(t, records[0], statusCSVHeader)
+
+	for i, f := range files {
+		want := []string{f.URL, f.Name, strconv.FormatInt(f.Size, 10), f.ContentType, f.RealType, strconv.Itoa(f.Status), f.ErrorMsg}
+		be.Equal(t, records[i+1], want)
+	}
+	be.Equal(t, records[2][3], `text/plain, "quoted"`)
+}
+
+func TestDownload_NoStatusCSV(t *testing.T) {
+	_, zr := download(t, newTestLoader(config.Loader{}), nil, Archive{})
+	_, err := zr.Open("status.csv")
+	be.Err(t, err)
+}
+
+func TestPlan_MatchesDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", `attachment; filename="photo.jpeg"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(jpegData)))
+		w.Write(jpegData)
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{})
+	urls := []string{srv.URL + "/1", srv.URL + "/2"}
+
+	checked, err := ldr.Check(context.Background(), urls)
+	be.Err(t, err, nil)
+	preview := ldr.Plan(checked)
+
+	var buf bytes.Buffer
+	files, err := ldr.Download(context.Background(), urls, &buf, Archive{})
+	be.Err(t, err, nil)
+
+	be.Equal(t, preview.Entries, []string{files[0].Name, files[1].Name})
+	// оценка не учитывает сжатие, поэтому не меньше реального размера
+	be.True(t, preview.Size >= int64(buf.Len()))
+}
+
+func TestDownload_TrustedHosts(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/doc", serveFile("application/pdf", []byte("not a pdf at all"))) // заявленный тип не подтверждается
+	mux.Handle("/notes", serveFile("text/plain", []byte("plain text")))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		trusted    []string
+		path       string
+		want       int
+		unverified bool
+	}{
+		{"untrusted", []string{"example.com"}, "/doc", http.StatusForbidden, false},
+		{"trusted", []string{"127.0.0.1"}, "/doc", http.StatusOK, true},
+		{"trusted_not_allowed", []string{"127.0.0.1"}, "/notes", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ldr := newTestLoader(config.Loader{TrustedHosts: tt.trusted})
+			files, _ := download(t, ldr, []string{srv.URL + tt.path}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+			be.Equal(t, files[0].Unverified, tt.unverified)
+			if tt.want == http.StatusOK {
+				be.Equal(t, files[0].Name, "unnamed-1.pdf")
+				be.Equal(t, files[0].RealType, "")
+			}
+		})
+	}
+}
+
+func TestDownload_TrustMagicOverDeclared(t *testing.T) {
+	srv := httptest.NewServer(serveFile("text/plain", jpegData))
+	defer srv.Close()
+
+	t.Run("strict", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{})
+
+		file, err := ldr.CheckFile(context.Background(), srv.URL)
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusForbidden)
+
+		files, zr := download(t, ldr, []string{srv.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusForbidden)
+		be.Equal(t, len(zr.File), 1) // только status.json
+	})
+
+	t.Run("trust_magic", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{TrustMagic: true})
+
+		file, err := ldr.CheckFile(context.Background(), srv.URL)
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusOK)
+		be.True(t, file.TypeMismatch)
+
+		files, zr := download(t, ldr, []string{srv.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.Equal(t, files[0].RealType, "image/jpeg")
+		be.True(t, files[0].TypeMismatch)
+		be.Equal(t, zr.File[0].Name, files[0].Name)
+	})
+
+	t.Run("trust_magic_real_not_allowed", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("text/plain", []byte("just text")))
+		defer srv.Close()
+
+		files, _ := download(t, newTestLoader(config.Loader{TrustMagic: true}), []string{srv.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusForbidden)
+	})
+}
+
+func TestDownload_EntryURLComment(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	withCreds := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/b.jpg"
+	urls := []string{srv.URL + "/a.jpg", withCreds}
+
+	t.Run("enabled", func(t *testing.T) {
+		_, zr := download(t, newTestLoader(config.Loader{EntryURL: true}), urls, Archive{})
+		be.Equal(t, len(zr.File), 3)
+		be.Equal(t, zr.File[0].Comment, srv.URL+"/a.jpg")
+		be.Equal(t, zr.File[1].Comment, srv.URL+"/b.jpg")
+		be.Equal(t, zr.File[2].Comment, "") // status.json
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, zr := download(t, newTestLoader(config.Loader{}), urls, Archive{})
+		for _, f := range zr.File {
+			be.Equal(t, f.Comment, "")
+		}
+	})
+}
+
+// slowHandler задерживает ответ на delay, затем отдаёт файл.
+func slowHandler(delay time.Duration, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func TestTimeouts_CheckVsDownload(t *testing.T) {
+	srv := httptest.NewServer(slowHandler(200*time.Millisecond, serveFile("image/jpeg", jpegData)))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{
+		CheckTimeout:          50 * time.Millisecond,
+		DownloadHeaderTimeout: time.Second,
+	})
+
+	start := time.Now()
+	file, err := ldr.CheckFile(context.Background(), srv.URL)
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusBadGateway)
+	be.True(t, time.Since(start) < 200*time.Millisecond)
+
+	files, zr := download(t, ldr, []string{srv.URL}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, len(zr.File), 2)
+}
+
+func TestTimeouts_DownloadHeader(t *testing.T) {
+	srv := httptest.NewServer(slowHandler(200*time.Millisecond, serveFile("image/jpeg", jpegData)))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{DownloadHeaderTimeout: 50 * time.Millisecond})
+	files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusBadGateway)
+}
+
+func TestTimeouts_BodyNotLimited(t *testing.T) {
+	// заголовки приходят сразу, тело передаётся дольше таймаута заголовков
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(jpegData[:magicLen])
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write(jpegData[magicLen:])
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{DownloadHeaderTimeout: 50 * time.Millisecond})
+	files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, files[0].Size, int64(len(jpegData)))
+}
+
+func TestDownload_SHA256Sums(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a.jpg", serveFile("image/jpeg", jpegData))
+	mux.Handle("/b.pdf", serveFile("application/pdf", pdfData))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{SHA256Sums: true})
+	files, zr := download(t, ldr, []string{srv.URL + "/a.jpg", srv.URL + "/missing", srv.URL + "/b.pdf"}, Archive{})
+	be.Equal(t, files[1].Status, http.StatusNotFound)
+
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	sums := readEntry(t, entries[sha256SumsName])
+	lines := strings.Split(strings.TrimSuffix(sums, "\n"), "\n")
+	be.Equal(t, len(lines), 2) // только успешно загруженные файлы
+
+	for i, line := range lines {
+		hash, name, ok := strings.Cut(line, "  ")
+		be.True(t, ok)
+		be.Equal(t, name, []string{files[0].Name, files[2].Name}[i])
+
+		sum := sha256.Sum256([]byte(readEntry(t, entries[name])))
+		be.Equal(t, hash, hex.EncodeToString(sum[:]))
+	}
+}
+
+func TestDownload_FileSHA256(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a.jpg", serveFile("image/jpeg", jpegData))
+	mux.Handle("/big.pdf", serveFile("application/pdf", pdfData))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{MaxFileSize: int64(len(pdfData)) - 1})
+	files, zr := download(t, ldr, []string{srv.URL + "/a.jpg", srv.URL + "/missing", srv.URL + "/big.pdf"}, Archive{})
+
+	sum := sha256.Sum256(jpegData)
+	be.Equal(t, files[0].SHA256, hex.EncodeToString(sum[:]))
+	be.Equal(t, files[1].SHA256, "") // не загружен
+	be.Equal(t, files[2].Status, http.StatusRequestEntityTooLarge)
+	be.Equal(t, files[2].SHA256, "")
+
+	// контрольная сумма попадает в status.json
+	rc, err := zr.Open("status.json")
+	be.Err(t, err, nil)
+	defer rc.Close()
+	var status []File
+	be.Err(t, json.NewDecoder(rc).Decode(&status), nil)
+	be.Equal(t, status[0].SHA256, files[0].SHA256)
+}
+
+func TestDownload_EntryMode(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{EntryMode: 0o640, SHA256Sums: true, StatusCSV: true})
+	_, zr := download(t, ldr, []string{srv.URL}, Archive{})
+
+	be.Equal(t, len(zr.File), 4) // файл, SHA256SUMS, status.csv, status.json
+	for _, f := range zr.File {
+		be.Equal(t, f.Mode(), os.FileMode(0o640))
+	}
+}
+
+func TestDownload_SlowDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		serveFile("image/jpeg", jpegData)(w, r)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	ctx := logger.Context(context.Background(), slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	ldr := newTestLoader(config.Loader{SlowDownload: 50 * time.Millisecond})
+	files, err := ldr.Download(ctx, []string{srv.URL + "/fast", srv.URL + "/slow"}, io.Discard, Archive{})
+	be.Err(t, err, nil)
+	be.Equal(t, files[1].Status, http.StatusOK)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	be.Equal(t, len(lines), 1)
+	be.True(t, strings.Contains(lines[0], "level=WARN msg=\"slow download\""))
+	be.True(t, strings.Contains(lines[0], "fileURL="+srv.URL+"/slow"))
+	be.True(t, strings.Contains(lines[0], "host=127.0.0.1"))
+	be.True(t, strings.Contains(lines[0], "elapsed="))
+}
+
+func TestDownload_ExtraTypes(t *testing.T) {
+	tiffData := append([]byte{0x49, 0x49, 0x2A, 0x00}, make([]byte, 100)...)
+	srv := httptest.NewServer(serveFile("image/tiff", tiffData))
+	defer srv.Close()
+
+	tiff := FileType{MIMEType: "image/tiff", Magic: tiffData[:4], Extensions: []string{".tif"}}
+	tooLong := FileType{MIMEType: "image/x-bad", Magic: make([]byte, magicLen+1)}
+
+	tests := []struct {
+		name     string
+		extra    []FileType
+		check    string
+		want     int
+		wantName string
+	}{
+		{"unknown", nil, SignatureStrict, http.StatusForbidden, ""},
+		{"unknown_lenient", nil, SignatureLenient, http.StatusOK, "unnamed-1"},
+		{"registered", []FileType{tiff}, SignatureStrict, http.StatusOK, "unnamed-1.tif"},
+		{"invalid_skipped", []FileType{tooLong}, SignatureStrict, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ldr := newTestLoader(config.Loader{
+				AllowMIMETypes: []string{"image/tiff"},
+				ExtraTypes:     tt.extra,
+				SignatureCheck: tt.check,
+			})
+			files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+			be.Equal(t, files[0].Name, tt.wantName)
+		})
+	}
+}
+
+func TestDownload_NoSHA256Sums(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	_, zr := download(t, newTestLoader(config.Loader{}), []string{srv.URL}, Archive{})
+	for _, f := range zr.File {
+		be.True(t, f.Name != sha256SumsName)
+	}
+}
+
+func readEntry(t *testing.T, f *zip.File) string {
+	t.Helper()
+	be.True(t, f != nil)
+	rc, err := f.Open()
+	be.Err(t, err, nil)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	be.Err(t, err, nil)
+	return string(data)
+}
+
+func TestDownload_PathTraversalFileName(t *testing.T) {
+	headers := []string{
+		`attachment; filename="../../etc/passwd"`,
+		`attachment; filename="..\\..\\evil.jpg"`,
+		`attachment; filename=".."`,
+		`attachment; filename*=UTF-8''..%2F..%2F.ssh%2Fauthorized_keys`,
+	}
+	want := []string{"passwd-1.jpg", "evil-2.jpg", "unnamed-3.jpg", "authorized_keys-4.jpg"}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", headers[i])
+		w.Write(jpegData)
+	}))
+	defer srv.Close()
+
+	urls := make([]string, len(headers))
+	for i := range headers {
+		urls[i] = srv.URL + "/" + strconv.Itoa(i)
+	}
+
+	files, zr := download(t, newTestLoader(config.Loader{}), urls, Archive{})
+	for i := range headers {
+		be.Equal(t, files[i].Status, http.StatusOK)
+		be.Equal(t, files[i].Name, want[i])
+		be.Equal(t, zr.File[i].Name, want[i])
+	}
+}
+
+func TestDownload_MaxRedirects(t *testing.T) {
+	// /r/N перенаправляет на /r/N-1, /r/0 отдаёт файл
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n == 0 {
+			serveFile("image/jpeg", jpegData)(w, r)
+			return
+		}
+		http.Redirect(w, r, "/r/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: protect.RedirectPolicy{MaxRedirects: 2}.CheckRedirect}
+	ldr := New(client, config.Loader{AllowMIMETypes: []string{"image/jpeg"}})
+
+	file, err := ldr.CheckFile(context.Background(), srv.URL+"/r/2")
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusOK)
+
+	file, err = ldr.CheckFile(context.Background(), srv.URL+"/r/3")
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusBadGateway)
+	be.Equal(t, file.ErrorMsg, protect.ErrTooManyRedirects.Error())
+
+	files, _ := download(t, ldr, []string{srv.URL + "/r/2", srv.URL + "/r/3"}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, files[1].Status, http.StatusBadGateway)
+	be.Equal(t, files[1].ErrorMsg, protect.ErrTooManyRedirects.Error())
+}
+
+func TestDownload_SniffedType(t *testing.T) {
+	webp := []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")
+
+	tests := []struct {
+		name     string
+		declared string
+		allow    []string
+		want     int
+		wantType string
+	}{
+		{"allowed", "image/webp", []string{"image/webp"}, http.StatusOK, "image/webp"},
+		{"spoofed", "image/jpeg", []string{"image/jpeg"}, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(serveFile(tt.declared, webp))
+			defer srv.Close()
+
+			ldr := newTestLoader(config.Loader{AllowMIMETypes: tt.allow})
+			files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+			be.Equal(t, files[0].RealType, tt.wantType)
+			be.True(t, !files[0].Unverified)
+		})
+	}
+}
+
+func TestDownload_UnknownSignature(t *testing.T) {
+	exotic := []byte("\x00\x01exotic binary format")
+
+	mux := http.NewServeMux()
+	mux.Handle("/fake.pdf", serveFile("application/pdf", exotic))
+	mux.Handle("/notes.txt", serveFile("text/plain", []byte("plain text")))
+	mux.Handle("/blob", serveFile("application/octet-stream", exotic))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/fake.pdf", srv.URL + "/notes.txt", srv.URL + "/blob"}
+	allow := []string{"application/pdf", "text/plain"}
+
+	t.Run("strict", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{AllowMIMETypes: allow, SignatureCheck: SignatureStrict})
+		files, zr := download(t, ldr, urls, Archive{})
+		for _, f := range files {
+			be.True(t, !f.Unverified)
+		}
+		be.Equal(t, files[0].Status, http.StatusForbidden)
+		be.Equal(t, files[0].ErrorMsg, ErrUnknownFileType.Error())
+		be.Equal(t, files[2].Status, http.StatusForbidden)
+
+		// текст распознан http.DetectContentType, а text/plain разрешён
+		be.Equal(t, files[1].Status, http.StatusOK)
+		be.Equal(t, files[1].RealType, "text/plain")
+		be.Equal(t, len(zr.File), 2)
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{AllowMIMETypes: allow, SignatureCheck: SignatureLenient})
+		files, zr := download(t, ldr, urls, Archive{})
+
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.True(t, files[0].Unverified)
+		be.Equal(t, files[0].RealType, "")
+		be.Equal(t, files[0].Name, "unnamed-1.pdf")
+
+		be.Equal(t, files[1].Status, http.StatusOK)
+		be.True(t, !files[1].Unverified) // тип подтверждён http.DetectContentType
+		be.Equal(t, readEntry(t, zr.File[1]), "plain text")
+
+		// заявленный тип не разрешён - файл отклоняется
+		be.Equal(t, files[2].Status, http.StatusForbidden)
+		be.True(t, !files[2].Unverified)
+
+		be.Equal(t, len(zr.File), 3)
+	})
+
+	t.Run("lenient_known_signature", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("application/pdf", jpegData))
+		defer srv.Close()
+
+		// известная, но не разрешённая сигнатура отклоняется и в мягком режиме
+		ldr := newTestLoader(config.Loader{AllowMIMETypes: []string{"application/pdf"}, SignatureCheck: SignatureLenient})
+		files, _ := download(t, ldr, []string{srv.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusForbidden)
+		be.True(t, !files[0].Unverified)
+	})
+}
+
+func TestDownload_Extension(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", `attachment; filename="photo.jpeg"`)
+		w.Write(jpegData)
+	}))
+	defer srv.Close()
+
+	files, _ := download(t, newTestLoader(config.Loader{}), []string{srv.URL, "http://127.0.0.1:0/missing"}, Archive{})
+	be.Equal(t, files[0].RealType, "image/jpeg")
+	be.Equal(t, files[0].Extension, ".jpg")
+	be.Equal(t, files[0].Name, "photo-1.jpg")
+	be.Equal(t, files[1].Extension, "")
+}
+
+func TestDownload_StatusSummary(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a.jpg", serveFile("image/jpeg", jpegData))
+	mux.Handle("/b.pdf", serveFile("application/pdf", pdfData))
+	mux.Handle("/c.txt", serveFile("text/plain", []byte("text")))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/a.jpg", srv.URL + "/missing", srv.URL + "/b.pdf", srv.URL + "/c.txt"}
+
+	t.Run("summary", func(t *testing.T) {
+		before := time.Now().UTC()
+		_, zr := download(t, newTestLoader(config.Loader{StatusSummary: true}), urls, Archive{})
+
+		var report statusWithSummary
+		be.Err(t, json.Unmarshal([]byte(readEntry(t, zr.File[len(zr.File)-1])), &report), nil)
+
+		be.True(t, !report.GeneratedAt.Before(before.Truncate(time.Second)))
+		be.Equal(t, len(report.Files), 4)
+		be.Equal(t, report.Summary.Total, 4)
+		be.Equal(t, report.Summary.Archived, 2)
+		be.Equal(t, report.Summary.ByStatusClass, map[string]int{"2xx": 2, "4xx": 2})
+		be.Equal(t, report.Summary.ArchivedBytes, int64(len(jpegData)+len(pdfData)))
+		be.True(t, report.Summary.TotalBytes >= report.Summary.ArchivedBytes)
+	})
+
+	t.Run("archive sizes", func(t *testing.T) {
+		_, zr := download(t, newTestLoader(config.Loader{StatusArchiveSizes: true}), urls, Archive{})
+
+		var report statusWithSummary
+		be.Err(t, json.Unmarshal([]byte(readEntry(t, zr.File[len(zr.File)-1])), &report), nil)
+		be.True(t, report.Summary.UncompressedBytes != nil)
+		be.True(t, report.Summary.CompressedBytes != nil)
+
+		// размеры совпадают с записями файлов в готовом архиве
+		var uncompressed, compressed int64
+		for _, f := range zr.File[:len(zr.File)-1] {
+			uncompressed += int64(f.UncompressedSize64)
+			compressed += int64(f.CompressedSize64)
+		}
+		be.Equal(t, *report.Summary.UncompressedBytes, uncompressed)
+		be.Equal(t, *report.Summary.CompressedBytes, compressed)
+		be.Equal(t, *report.Summary.UncompressedBytes, report.Summary.ArchivedBytes)
+		be.True(t, *report.Summary.CompressedBytes > 0)
+	})
+
+	t.Run("no archive sizes", func(t *testing.T) {
+		_, zr := download(t, newTestLoader(config.Loader{StatusSummary: true}), urls, Archive{})
+
+		var report statusWithSummary
+		be.Err(t, json.Unmarshal([]byte(readEntry(t, zr.File[len(zr.File)-1])), &report), nil)
+		be.Equal(t, report.Summary.UncompressedBytes, nil)
+		be.Equal(t, report.Summary.CompressedBytes, nil)
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		_, zr := download(t, newTestLoader(config.Loader{}), urls, Archive{})
+
+		var files []File
+		be.Err(t, json.Unmarshal([]byte(readEntry(t, zr.File[len(zr.File)-1])), &files), nil)
+		be.Equal(t, len(files), 4)
+	})
+}
+
+func TestDownload_RedirectDowngrade(t *testing.T) {
+	plain := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer plain.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+"/file.jpg", http.StatusFound)
+	}))
+	defer secure.Close()
+
+	newLoader := func(allow bool) *Loader {
+		client := secure.Client()
+		client.CheckRedirect = protect.RedirectPolicy{MaxRedirects: 5, AllowDowngrade: allow}.CheckRedirect
+		return New(client, config.Loader{AllowMIMETypes: []string{"image/jpeg"}})
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		ldr := newLoader(false)
+
+		file, err := ldr.CheckFile(context.Background(), secure.URL)
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusBadGateway)
+		be.Equal(t, file.ErrorMsg, protect.ErrRedirectDowngrade.Error())
+
+		files, _ := download(t, ldr, []string{secure.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusBadGateway)
+		be.Equal(t, files[0].ErrorMsg, protect.ErrRedirectDowngrade.Error())
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		files, _ := download(t, newLoader(true), []string{secure.URL}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusOK)
+	})
+}
+
+func TestDownload_PartialContent(t *testing.T) {
+	partial := func(contentRange string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				t.Errorf("unexpected Range header: %q", r.Header.Get("Range"))
+			}
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Range", contentRange)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(jpegData)
+		}
+	}
+
+	full := fmt.Sprintf("bytes 0-%d/%d", len(jpegData)-1, len(jpegData))
+	mux := http.NewServeMux()
+	mux.Handle("/full", partial(full))
+	mux.Handle("/head", partial(fmt.Sprintf("bytes 0-%d/%d", len(jpegData)-1, 2*len(jpegData))))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/full", srv.URL + "/head"}
+
+	tests := []struct {
+		mode string
+		want []int
+	}{
+		{PartialReject, []int{http.StatusPartialContent, http.StatusPartialContent}},
+		{PartialFull, []int{http.StatusOK, http.StatusPartialContent}},
+		{PartialAccept, []int{http.StatusOK, http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ldr := newTestLoader(config.Loader{PartialContent: tt.mode})
+			files, zr := download(t, ldr, urls, Archive{})
+			for i, f := range files {
+				be.Equal(t, f.Status, tt.want[i])
+			}
+			if tt.want[0] == http.StatusOK {
+				be.Equal(t, readEntry(t, zr.File[0]), string(jpegData))
+			}
+		})
+	}
+}
+
+// rangeServer отдаёт data с поддержкой Range, обрывая соединение после chunk байт в первых drops
+// ответах. ETag меняется после первого ответа, если changed. Заголовки Range запросов сохраняются.
+type rangeServer struct {
+	data         []byte
+	chunk        int
+	drops        int
+	acceptRanges bool
+	changed      bool
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (rs *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	rs.ranges = append(rs.ranges, r.Header.Get("Range"))
+	n := len(rs.ranges)
+	rs.mu.Unlock()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", `"v1"`)
+	if rs.changed && n > 1 {
+		w.Header().Set("ETag", `"v2"`)
+	}
+	if !rs.acceptRanges {
+		w.Header().Set("Content-Length", strconv.Itoa(len(rs.data)))
+		w.Write(rs.data[:rs.chunk])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if n > rs.drops {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(rs.data))
+		return
+	}
+
+	start := 0
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(rs.data)-1, len(rs.data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(rs.data)-start))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(rs.data)))
+	}
+	w.Write(rs.data[start : start+rs.chunk])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestDownload_RangeResume(t *testing.T) {
+	data := append(bytes.Clone(jpegData), make([]byte, 64<<10)...)
+	rand.Read(data[len(jpegData):])
+	sum := sha256.Sum256(data)
+
+	tests := []struct {
+		name         string
+		enable       bool
+		acceptRanges bool
+		changed      bool
+		drops        int
+		want         int
+		wantRanges   []string
+	}{
+		{"resumed", true, true, false, 1, http.StatusOK, []string{"", "bytes=10000-"}},
+		{"resumed_twice", true, true, false, 2, http.StatusOK, []string{"", "bytes=10000-", "bytes=20000-"}},
+		{"too_many_drops", true, true, false, 4, http.StatusBadGateway, []string{"", "bytes=10000-", "bytes=20000-", "bytes=30000-"}},
+		{"disabled", false, true, false, 1, http.StatusBadGateway, []string{""}},
+		{"no_accept_ranges", true, false, false, 1, http.StatusBadGateway, []string{""}},
+		{"file_changed", true, true, true, 1, http.StatusBadGateway, []string{"", "bytes=10000-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &rangeServer{data: data, chunk: 10000, drops: tt.drops, acceptRanges: tt.acceptRanges, changed: tt.changed}
+			srv := httptest.NewServer(rs)
+			defer srv.Close()
+
+			ldr := newTestLoader(config.Loader{EnableRange: tt.enable})
+			files, zr := download(t, ldr, []string{srv.URL}, Archive{})
+			be.Equal(t, files[0].Status, tt.want)
+			be.Equal(t, rs.ranges, tt.wantRanges)
+
+			if tt.want != http.StatusOK {
+				return
+			}
+			be.Equal(t, files[0].Size, int64(len(data)))
+			be.Equal(t, files[0].SHA256, hex.EncodeToString(sum[:]))
+			rc, err := zr.File[0].Open()
+			be.Err(t, err, nil)
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			be.Err(t, err, nil)
+			be.True(t, bytes.Equal(got, data))
+		})
+	}
+}
+
+func TestIsFullContentRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"bytes 0-9/10", true},
+		{"bytes 0-9/20", false},
+		{"bytes 5-9/10", false},
+		{"bytes 0-9/*", false},
+		{"bytes */10", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		be.Equal(t, isFullContentRange(tt.in), tt.want)
+	}
+}
+
+func TestDownload_StatusLast(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{StatusCSV: true, SHA256Sums: true})
+	_, zr := download(t, ldr, []string{srv.URL + "/a.jpg", "bad url", srv.URL + "/b.jpg"}, Archive{})
+
+	names := make([]string, len(zr.File))
+	for i, f := range zr.File {
+		names[i] = f.Name
+	}
+	be.Equal(t, names, []string{"unnamed-1.jpg", "unnamed-3.jpg", "SHA256SUMS", "status.csv", "status.json"})
+}
+
+func TestMIMESizeLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a.jpg", serveFile("image/jpeg", jpegData))
+	mux.Handle("/a.pdf", serveFile("application/pdf", pdfData))
+	mux.HandleFunc("/chunked.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for i := 0; i < len(jpegData); i += 100 {
+			w.Write(jpegData[i:min(i+100, len(jpegData))])
+			w.(http.Flusher).Flush() // без Content-Length
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// jpeg ограничен 512 байтами, pdf - без ограничения
+	ldr := newTestLoader(config.Loader{MIMESizeLimits: map[string]int64{"image/jpeg": 512}})
+
+	t.Run("check", func(t *testing.T) {
+		files, err := ldr.Check(context.Background(), []string{srv.URL + "/a.jpg", srv.URL + "/a.pdf"})
+		be.Err(t, err, nil)
+		be.Equal(t, files[0].Status, http.StatusRequestEntityTooLarge)
+		be.Equal(t, files[1].Status, http.StatusOK)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		files, zr := download(t, ldr, []string{srv.URL + "/a.jpg", srv.URL + "/a.pdf", srv.URL + "/chunked.jpg"}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusRequestEntityTooLarge)
+		be.Equal(t, files[1].Status, http.StatusOK)
+		be.Equal(t, files[2].Status, http.StatusRequestEntityTooLarge)
+		be.Equal(t, readEntry(t, zr.File[0]), string(pdfData))
+		be.Equal(t, len(zr.File), 2) // обрезанной записи нет
+	})
+}
+
+func TestMaxFileSize(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/small.jpg", serveFile("image/jpeg", jpegData[:400]))
+	mux.Handle("/big.jpg", serveFile("image/jpeg", jpegData))
+	mux.HandleFunc("/lying.jpg", func(w http.ResponseWriter, r *http.Request) {
+		// без Content-Length, HEAD не выдаёт размер
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.Method == http.MethodHead {
+			return
+		}
+		for i := 0; i < len(jpegData); i += 100 {
+			w.Write(jpegData[i:min(i+100, len(jpegData))])
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.Handle("/a.pdf", serveFile("application/pdf", pdfData))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// общее ограничение 512 байт, для pdf - своё
+	ldr := newTestLoader(config.Loader{MaxFileSize: 512, MIMESizeLimits: map[string]int64{"application/pdf": 10 << 10}})
+
+	t.Run("advertised", func(t *testing.T) {
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/big.jpg")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusRequestEntityTooLarge)
+		be.True(t, strings.Contains(file.ErrorMsg, "exceeds limit 512"))
+
+		file, err = ldr.CheckFile(context.Background(), srv.URL+"/lying.jpg")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusOK) // размер неизвестен до загрузки
+	})
+
+	t.Run("download", func(t *testing.T) {
+		urls := []string{srv.URL + "/big.jpg", srv.URL + "/lying.jpg", srv.URL + "/small.jpg", srv.URL + "/a.pdf"}
+		files, zr := download(t, ldr, urls, Archive{})
+		be.Equal(t, files[0].Status, http.StatusRequestEntityTooLarge)
+		be.Equal(t, files[1].Status, http.StatusRequestEntityTooLarge)
+		be.True(t, strings.Contains(files[1].ErrorMsg, "exceeds limit 512"))
+		be.Equal(t, files[2].Status, http.StatusOK)
+		be.Equal(t, files[3].Status, http.StatusOK)
+
+		// в архиве только целые файлы
+		be.Equal(t, len(zr.File), 3)
+		be.Equal(t, zr.File[0].Name, files[2].Name)
+		be.Equal(t, readEntry(t, zr.File[0]), string(jpegData[:400]))
+		be.Equal(t, readEntry(t, zr.File[1]), string(pdfData))
+	})
+
+	t.Run("chunked within limit", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{MaxFileSize: 2048})
+		files, zr := download(t, ldr, []string{srv.URL + "/lying.jpg"}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.Equal(t, files[0].Size, int64(len(jpegData)))
+		be.Equal(t, readEntry(t, zr.File[0]), string(jpegData))
+	})
+}
+
+func TestDownload_MaskQuery(t *testing.T) {
+	// файл отдаётся только по подписанному URL
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		serveFile("image/jpeg", jpegData)(w, r)
+	}))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{MaskQueryParams: []string{"sig"}, StatusCSV: true})
+	uri := srv.URL + "/a.jpg?sig=secret"
+	files, zr := download(t, ldr, []string{uri}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, files[0].URL, uri) // вызывающему возвращается исходный URL
+
+	for _, name := range []string{"status.json", "status.csv"} {
+		var entry *zip.File
+		for _, f := range zr.File {
+			if f.Name == name {
+				entry = f
+			}
+		}
+		report := readEntry(t, entry)
+		be.True(t, !strings.Contains(report, "secret"))
+		be.True(t, strings.Contains(report, "sig=REDACTED"))
+	}
+}
+
+func TestHTMLErrorPage(t *testing.T) {
+	page := []byte("<!DOCTYPE html><html><body>404 Not Found</body></html>")
+	srv := httptest.NewServer(serveFile("text/html; charset=utf-8", page))
+	defer srv.Close()
+
+	t.Run("detect", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{DetectHTMLPages: true})
+
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.pdf")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusBadGateway)
+		be.Equal(t, file.ErrorMsg, errHTMLPage.Error())
+
+		files, zr := download(t, ldr, []string{srv.URL + "/a.pdf"}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusBadGateway)
+		be.Equal(t, files[0].ErrorMsg, errHTMLPage.Error())
+		be.Equal(t, len(zr.File), 1) // только status.json
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{})
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.pdf")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusForbidden)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		// text/html явно разрешён - это не ошибка
+		ldr := newTestLoader(config.Loader{AllowMIMETypes: []string{"text/html"}, DetectHTMLPages: true})
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.pdf")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusOK)
+	})
+}
+
+func TestDownload_DeadlineMidDownload(t *testing.T) {
+	var requested sync.Map
+	mux := http.NewServeMux()
+	mux.Handle("/fast.jpg", serveFile("image/jpeg", jpegData))
+	mux.HandleFunc("/slow.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(jpegData[:100])
+		w.(http.Flusher).Flush()
+		select { // остаток не отдаётся до отмены
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	mux.HandleFunc("/after.jpg", func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.Path, true)
+		serveFile("image/jpeg", jpegData)(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/fast.jpg", srv.URL + "/slow.jpg", srv.URL + "/after.jpg"}
+
+	t.Run("finalize", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{FinalizeOnCancel: true})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		var buf bytes.Buffer
+		files, err := ldr.Download(ctx, urls, &buf, Archive{})
+		be.Err(t, err, nil)
+
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.True(t, !files[0].Interrupted)
+		for _, f := range files[1:] {
+			be.Equal(t, f.Status, http.StatusGatewayTimeout)
+			be.True(t, f.Interrupted)
+			be.True(t, strings.Contains(f.ErrorMsg, context.DeadlineExceeded.Error()))
+		}
+		_, ok := requested.Load("/after.jpg")
+		be.True(t, !ok) // после дедлайна загрузки не начинаются
+
+		// архив корректен, отчёт отмечает прерванные файлы
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		be.Err(t, err, nil)
+		last := zr.File[len(zr.File)-1]
+		be.Equal(t, last.Name, "status.json")
+		var report []File
+		be.Err(t, json.Unmarshal([]byte(readEntry(t, last)), &report), nil)
+		be.Equal(t, len(report), 3)
+		be.True(t, report[1].Interrupted && report[2].Interrupted)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		_, err := ldr.Download(ctx, urls, io.Discard, Archive{})
+		be.Err(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestAuthRequired(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		want   string
+	}{
+		{http.StatusUnauthorized, `Basic realm="files"`, "origin requires authentication: use a URL with credentials or a presigned link (scheme Basic)"},
+		{http.StatusUnauthorized, "", "origin requires authentication: use a URL with credentials or a presigned link"},
+		{http.StatusProxyAuthRequired, `Negotiate`, "proxy requires authentication: check the server's proxy credentials (scheme Negotiate)"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.header != "" {
+				w.Header().Set("WWW-Authenticate", tt.header)
+				w.Header().Set("Proxy-Authenticate", tt.header)
+			}
+			w.WriteHeader(tt.status)
+		}))
+
+		ldr := newTestLoader(config.Loader{})
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.pdf")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, tt.status)
+		be.Equal(t, file.ErrorMsg, tt.want)
+
+		files, _ := download(t, ldr, []string{srv.URL + "/a.pdf"}, Archive{})
+		be.Equal(t, files[0].ErrorMsg, tt.want)
+		srv.Close()
+	}
+
+	// остальные статусы - текст статуса
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	file, err := newTestLoader(config.Loader{}).CheckFile(context.Background(), srv.URL)
+	be.Err(t, err, nil)
+	be.Equal(t, file.ErrorMsg, "Not Found")
+}
+
+func TestLongErrorMsg(t *testing.T) {
+	uri := "http://example.com/" + strings.Repeat("a", 1000) + "%zz"
+	ldr := newTestLoader(config.Loader{MaxErrorMsgLen: 64})
+
+	file, err := ldr.CheckFile(context.Background(), uri)
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusBadRequest)
+	be.Equal(t, len(file.ErrorMsg), 64)
+	be.True(t, strings.HasPrefix(file.ErrorMsg, "invalid url: "))
+	be.True(t, strings.HasSuffix(file.ErrorMsg, "..."))
+
+	files, _ := download(t, ldr, []string{uri}, Archive{})
+	be.Equal(t, files[0].ErrorMsg, file.ErrorMsg)
+}
+
+func TestAllowSchemes(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	// схему "files" обслуживает транспорт, перенаправляя запросы на тестовый сервер
+	transport := &http.Transport{}
+	transport.RegisterProtocol("files", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+	client := &http.Client{Transport: transport}
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("custom allowed", func(t *testing.T) {
+		ldr := New(client, config.Loader{
+			AllowMIMETypes: []string{"image/jpeg"},
+			AllowSchemes:   []string{"http", "https", "files"},
+		})
+
+		file, err := ldr.CheckFile(context.Background(), "files://"+host+"/a.jpg")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusOK)
+
+		files, zr := download(t, ldr, []string{"files://" + host + "/a.jpg"}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.Equal(t, len(zr.File), 2)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		ldr := New(client, config.Loader{AllowMIMETypes: []string{"image/jpeg"}})
+
+		for _, uri := range []string{"files://" + host + "/a.jpg", "ftp://" + host + "/a.jpg"} {
+			file, err := ldr.CheckFile(context.Background(), uri)
+			be.Err(t, err, nil)
+			be.Equal(t, file.Status, http.StatusBadRequest)
+			be.True(t, strings.Contains(file.ErrorMsg, "is not allowed"))
+
+			files, _ := download(t, ldr, []string{uri}, Archive{})
+			be.Equal(t, files[0].Status, http.StatusBadRequest)
+		}
+
+		// http и https разрешены по умолчанию
+		file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.jpg")
+		be.Err(t, err, nil)
+		be.Equal(t, file.Status, http.StatusOK)
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestDownload_Concurrency(t *testing.T) {
+	var active, maxActive atomic.Int32
+	mux := http.NewServeMux()
+	for i, delay := range []time.Duration{150, 100, 50, 0} {
+		mux.HandleFunc(fmt.Sprintf("/%d.jpg", i+1), func(w http.ResponseWriter, r *http.Request) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for m := maxActive.Load(); n > m && !maxActive.CompareAndSwap(m, n); m = maxActive.Load() {
+			}
+			time.Sleep(delay * time.Millisecond)
+			serveFile("image/jpeg", jpegData)(w, r)
+		})
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/1.jpg", srv.URL + "/2.jpg", srv.URL + "/3.jpg", srv.URL + "/4.jpg", srv.URL + "/missing"}
+
+	t.Run("parallel", func(t *testing.T) {
+		maxActive.Store(0)
+		ldr := newTestLoader(config.Loader{DownloadConcurrency: 4, SHA256Sums: true})
+
+		files, zr := download(t, ldr, urls, Archive{})
+		be.True(t, maxActive.Load() > 1)
+
+		// порядок записей и имена совпадают с последовательной загрузкой
+		want := []string{"unnamed-1.jpg", "unnamed-2.jpg", "unnamed-3.jpg", "unnamed-4.jpg", sha256SumsName, "status.json"}
+		be.Equal(t, len(zr.File), len(want))
+		for i, f := range zr.File {
+			be.Equal(t, f.Name, want[i])
+		}
+		for i := range 4 {
+			be.Equal(t, files[i].Status, http.StatusOK)
+			be.Equal(t, readEntry(t, zr.File[i]), string(jpegData))
+		}
+		be.Equal(t, files[4].Status, http.StatusNotFound)
+
+		sums := strings.Split(strings.TrimSpace(readEntry(t, zr.File[4])), "\n")
+		be.Equal(t, len(sums), 4)
+		for i, line := range sums {
+			be.True(t, strings.HasSuffix(line, "  "+want[i]))
+		}
+	})
+
+	t.Run("sequential", func(t *testing.T) {
+		maxActive.Store(0)
+		download(t, newTestLoader(config.Loader{}), urls, Archive{})
+		be.Equal(t, maxActive.Load(), int32(1))
+	})
+}
+
+// failWriter принимает limit байт, затем возвращает ошибку.
+type failWriter struct{ limit int }
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.limit = 0
+		return 0, errWriteFailed
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestDownload_ConcurrencyWriteError(t *testing.T) {
+	// несжимаемые данные, чтобы запись в out началась до конца файла
+	big := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 1<<20)...)
+	rand.Read(big[4:])
+	cancelled := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/big.jpg", serveFile("image/jpeg", big))
+	mux.HandleFunc("/hang.jpg", func(w http.ResponseWriter, r *http.Request) {
+		// отвечает только после отмены запроса
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{DownloadConcurrency: 2})
+	files, err := ldr.Download(context.Background(), []string{srv.URL + "/big.jpg", srv.URL + "/hang.jpg"}, &failWriter{limit: 1024}, Archive{})
+	be.Err(t, err, errWriteFailed)
+	be.Equal(t, len(files), 1)
+
+	// ошибка записи отменила запрос второго файла
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("second download was not cancelled")
+	}
+}
+
+func TestDownload_ZipLevel(t *testing.T) {
+	// сжимаемые данные с повторами на разных расстояниях
+	var data bytes.Buffer
+	data.Write(jpegData[:4])
+	for i := range 20000 {
+		fmt.Fprintf(&data, "line %d: %s\n", i%997, strings.Repeat("ab", i%13))
+	}
+	srv := httptest.NewServer(serveFile("image/jpeg", data.Bytes()))
+	defer srv.Close()
+
+	compressed := func(level int) uint64 {
+		ldr := newTestLoader(config.Loader{ZipLevel: level, ZipCompression: ZipCompressionDeflate})
+		_, zr := download(t, ldr, []string{srv.URL}, Archive{})
+		be.Equal(t, readEntry(t, zr.File[0]), data.String())
+		return zr.File[0].CompressedSize64
+	}
+
+	fast, def, best := compressed(flate.BestSpeed), compressed(0), compressed(flate.BestCompression)
+	be.True(t, best <= def)
+	be.True(t, def <= fast)
+	be.True(t, best < fast)
+}
+
+func TestDownload_ZipCompression(t *testing.T) {
+	tiffData := append([]byte{0x49, 0x49, 0x2A, 0x00}, make([]byte, 100)...)
+	mux := http.NewServeMux()
+	mux.Handle("/a.jpg", serveFile("image/jpeg", jpegData))
+	mux.Handle("/b.pdf", serveFile("application/pdf", pdfData))
+	mux.Handle("/c.tif", serveFile("image/tiff", tiffData))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	urls := []string{srv.URL + "/a.jpg", srv.URL + "/b.pdf", srv.URL + "/c.tif"}
+
+	tiff := FileType{MIMEType: "image/tiff", Magic: tiffData[:4], Extensions: []string{".tif"}}
+
+	tests := []struct {
+		mode string
+		want map[string]uint16 // имя записи -> метод
+	}{
+		{"", map[string]uint16{"unnamed-1.jpg": zip.Store, "unnamed-2.pdf": zip.Store, "unnamed-3.tif": zip.Deflate, "status.json": zip.Deflate}},
+		{ZipCompressionAuto, map[string]uint16{"unnamed-1.jpg": zip.Store, "unnamed-2.pdf": zip.Store, "unnamed-3.tif": zip.Deflate, "status.json": zip.Deflate}},
+		{ZipCompressionStore, map[string]uint16{"unnamed-1.jpg": zip.Store, "unnamed-2.pdf": zip.Store, "unnamed-3.tif": zip.Store, "status.json": zip.Deflate}},
+		{ZipCompressionDeflate, map[string]uint16{"unnamed-1.jpg": zip.Deflate, "unnamed-2.pdf": zip.Deflate, "unnamed-3.tif": zip.Deflate, "status.json": zip.Deflate}},
+	}
+
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.mode, "default"), func(t *testing.T) {
+			ldr := newTestLoader(config.Loader{
+				AllowMIMETypes: []string{"image/jpeg", "application/pdf", "image/tiff"},
+				ExtraTypes:     []FileType{tiff},
+				ZipCompression: tt.mode,
+			})
+			_, zr := download(t, ldr, urls, Archive{})
+			got := make(map[string]uint16, len(zr.File))
+			for _, f := range zr.File {
+				got[f.Name] = f.Method
+				readEntry(t, f) // содержимое читается при любом методе
+			}
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestNewFileTypes_KeepsCompressed(t *testing.T) {
+	jpeg := FileType{MIMEType: "image/jpeg", Magic: []byte{0xFF, 0xD8}, Extensions: []string{".jpe"}}
+	ft, err := getFileTypeByMIME(newFileTypes([]FileType{jpeg}), "image/jpeg")
+	be.Err(t, err, nil)
+	be.Equal(t, ft.Extension(), ".jpe")
+	be.True(t, ft.Compressed)
+}
+
+func TestDownload_RedirectToPrivate(t *testing.T) {
+	// перенаправление на адрес метаданных облака
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: protect.RedirectPolicy{MaxRedirects: 5, BlockPrivate: true}.CheckRedirect}
+	ldr := New(client, config.Loader{AllowMIMETypes: []string{"image/jpeg"}})
+
+	file, err := ldr.CheckFile(context.Background(), srv.URL+"/a.jpg")
+	be.Err(t, err, nil)
+	be.Equal(t, file.Status, http.StatusForbidden)
+
+	files, zr := download(t, ldr, []string{srv.URL + "/a.jpg"}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusForbidden)
+	be.Equal(t, len(zr.File), 1) // только status.json
+}
+
+func TestCheckFirst(t *testing.T) {
+	var slowStarted, slowCancelled atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle("/ok.pdf", serveFile("application/pdf", pdfData))
+	mux.Handle("/text.txt", serveFile("text/plain", []byte("text")))
+	mux.HandleFunc("/delayed.pdf", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // быстрые ответы других зеркал успевают прийти
+		serveFile("application/pdf", pdfData)(w, r)
+	})
+	mux.HandleFunc("/slow.pdf", func(w http.ResponseWriter, r *http.Request) {
+		slowStarted.Add(1)
+		select {
+		case <-r.Context().Done():
+			slowCancelled.Add(1)
+		case <-time.After(5 * time.Second):
+			serveFile("application/pdf", pdfData)(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{})
+
+	t.Run("early_mirror_wins", func(t *testing.T) {
+		slowStarted.Store(0)
+		slowCancelled.Store(0)
+		urls := []string{srv.URL + "/slow.pdf", srv.URL + "/missing.pdf", srv.URL + "/delayed.pdf", srv.URL + "/slow.pdf"}
+
+		start := time.Now()
+		files, winner, err := ldr.CheckFirst(context.Background(), urls)
+		be.Err(t, err, nil)
+		be.True(t, time.Since(start) < 5*time.Second)
+		be.Equal(t, winner, 2)
+		be.Equal(t, files[2].Status, http.StatusOK)
+		be.Equal(t, files[1].Status, http.StatusNotFound)
+		for _, i := range []int{0, 3} {
+			be.Equal(t, files[i].URL, urls[i])
+			be.True(t, files[i].Interrupted)
+			be.Equal(t, files[i].Status, http.StatusGatewayTimeout)
+		}
+
+		// отменённые проверки закрыли соединения
+		be.Equal(t, slowStarted.Load(), int32(2))
+		deadline := time.Now().Add(time.Second)
+		for slowCancelled.Load() < 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		be.Equal(t, slowCancelled.Load(), int32(2))
+	})
+
+	t.Run("later_mirrors_not_started", func(t *testing.T) {
+		slowStarted.Store(0)
+		ldr := newTestLoader(config.Loader{Concurrency: 1})
+		urls := []string{srv.URL + "/text.txt", srv.URL + "/ok.pdf", srv.URL + "/slow.pdf"}
+
+		files, winner, err := ldr.CheckFirst(context.Background(), urls)
+		be.Err(t, err, nil)
+		be.Equal(t, winner, 1)
+		be.Equal(t, files[0].Status, http.StatusForbidden) // тип не разрешён
+		be.True(t, files[2].Interrupted)
+		be.Equal(t, slowStarted.Load(), int32(0))
+	})
+
+	t.Run("no_winner", func(t *testing.T) {
+		urls := []string{srv.URL + "/text.txt", srv.URL + "/missing.pdf"}
+		files, winner, err := ldr.CheckFirst(context.Background(), urls)
+		be.Err(t, err, nil)
+		be.Equal(t, winner, -1)
+		be.Equal(t, files[0].Status, http.StatusForbidden)
+		be.Equal(t, files[1].Status, http.StatusNotFound)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		files, winner, err := ldr.CheckFirst(context.Background(), nil)
+		be.Err(t, err, nil)
+		be.Equal(t, len(files), 0)
+		be.Equal(t, winner, -1)
+	})
+}
+
+// recordSink запоминает события загрузчика.
+type recordSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordSink) Emit(_ context.Context, ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+}
+
+// kinds возвращает события в виде "вид статус путь".
+func (s *recordSink) kinds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kinds []string
+	for _, ev := range s.events {
+		u, _ := url.Parse(ev.URL)
+		kinds = append(kinds, fmt.Sprintf("%s %d %s", ev.Kind, ev.Status, u.Path))
+	}
+	return kinds
+}
+
+func TestEventSink(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a.pdf", serveFile("application/pdf", pdfData))
+	mux.Handle("/b.txt", serveFile("text/plain", []byte("plain text")))
+	mux.HandleFunc("/c.jpg", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: protect.RedirectPolicy{MaxRedirects: 5, BlockPrivate: true}.CheckRedirect}
+	ldr := New(client, config.Loader{AllowMIMETypes: []string{"application/pdf"}})
+	sink := &recordSink{}
+	ldr.SetEventSink(sink)
+
+	urls := []string{srv.URL + "/a.pdf", srv.URL + "/b.txt", srv.URL + "/c.jpg", srv.URL + "/d.pdf"}
+	download(t, ldr, urls, Archive{})
+	be.Equal(t, sink.kinds(), []string{
+		"file_downloaded 200 /a.pdf",
+		"type_rejected 403 /b.txt",
+		"file_downloaded 403 /b.txt",
+		"ssrf_blocked 403 /c.jpg",
+		"file_downloaded 403 /c.jpg",
+		"file_downloaded 404 /d.pdf",
+	})
+	be.Equal(t, sink.events[0].ContentType, "application/pdf")
+	be.Equal(t, sink.events[0].Size, int64(len(pdfData)))
+	be.Equal(t, sink.events[1].ContentType, "text/plain")
+
+	sink.events = nil
+	_, err := ldr.CheckFile(context.Background(), srv.URL+"/c.jpg")
+	be.Err(t, err, nil)
+	be.Equal(t, sink.kinds(), []string{"ssrf_blocked 403 /c.jpg", "file_checked 403 /c.jpg"})
+
+	// без приёмника события отбрасываются
+	ldr.SetEventSink(nil)
+	download(t, ldr, urls[:1], Archive{})
+}
+
+func TestDownload_RetryFailed(t *testing.T) {
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky.jpg", func(w http.ResponseWriter, r *http.Request) {
+		// первая попытка задачи завершается ошибкой источника
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		serveFile("image/jpeg", jpegData)(w, r)
+	})
+	mux.Handle("/stable.jpg", serveFile("image/jpeg", jpegData))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/flaky.jpg", srv.URL + "/stable.jpg", srv.URL + "/missing.jpg"}
+
+	t.Run("retry", func(t *testing.T) {
+		calls.Store(0)
+		var reported []File
+		files, zr := download(t, newTestLoader(config.Loader{RetryFailed: 2}), urls, Archive{
+			OnFile: func(f File) { reported = append(reported, f) }, // загрузка последовательная
+		})
+
+		be.Equal(t, calls.Load(), int32(2))
+
+		// о каждом файле сообщается один раз, с окончательным результатом
+		be.Equal(t, len(reported), len(urls))
+		for _, f := range reported {
+			be.Equal(t, f.Status != http.StatusBadGateway, true)
+		}
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.Equal(t, files[0].Name, "unnamed-1.jpg")
+		be.Equal(t, files[1].Status, http.StatusOK)
+		be.Equal(t, files[2].Status, http.StatusNotFound) // не временная ошибка
+
+		// повторно загруженный файл записан после остальных
+		names := make([]string, len(zr.File))
+		for i, f := range zr.File {
+			names[i] = f.Name
+		}
+		be.Equal(t, names, []string{"unnamed-2.jpg", "unnamed-1.jpg", "status.json"})
+		be.Equal(t, readEntry(t, zr.File[1]), string(jpegData))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		calls.Store(0)
+		files, _ := download(t, newTestLoader(config.Loader{}), urls, Archive{})
+
+		be.Equal(t, calls.Load(), int32(1))
+		be.Equal(t, files[0].Status, http.StatusBadGateway)
+	})
+}
+
+func TestDownload_DuplicateURLs(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/a.jpg":
+			serveFile("image/jpeg", jpegData)(w, r)
+		case "/b.pdf":
+			serveFile("application/pdf", pdfData)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	a, b, missing := srv.URL+"/a.jpg", srv.URL+"/b.pdf", srv.URL+"/missing"
+	urls := []string{a, b, a, missing, missing, a}
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			clear(requests)
+			ldr := newTestLoader(config.Loader{SHA256Sums: true, DownloadConcurrency: workers})
+			files, zr := download(t, ldr, urls, Archive{})
+
+			// каждый URL запрошен один раз
+			be.Equal(t, requests, map[string]int{"/a.jpg": 1, "/b.pdf": 1, "/missing": 1})
+
+			// у повторов общий результат, но свои имена (по позиции) и записи - после остальных
+			be.Equal(t, len(files), len(urls))
+			for i, want := range []string{"unnamed-1.jpg", "unnamed-2.pdf", "unnamed-3.jpg", "", "", "unnamed-6.jpg"} {
+				be.Equal(t, files[i].URL, urls[i])
+				be.Equal(t, files[i].Name, want)
+			}
+			be.Equal(t, files[2].Status, http.StatusOK)
+			be.Equal(t, files[2].SHA256, files[0].SHA256)
+			be.Equal(t, files[4].Status, http.StatusNotFound)
+
+			var names []string
+			entries := make(map[string]*zip.File)
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+				entries[f.Name] = f
+			}
+			be.Equal(t, names, []string{"unnamed-1.jpg", "unnamed-2.pdf", "unnamed-3.jpg", "unnamed-6.jpg", sha256SumsName, "status.json"})
+			be.Equal(t, readEntry(t, entries["unnamed-3.jpg"]), string(jpegData))
+			be.Equal(t, readEntry(t, entries["unnamed-6.jpg"]), string(jpegData))
+			be.True(t, strings.Contains(readEntry(t, entries[sha256SumsName]), files[0].SHA256+"  unnamed-6.jpg\n"))
+		})
+	}
+
+	t.Run("over budget", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{MaxTotalSize: int64(len(jpegData) + len(jpegData)/2)})
+		files, zr := download(t, ldr, []string{a, a}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusOK)
+		be.Equal(t, files[1].Status, http.StatusInsufficientStorage)
+		be.Equal(t, len(zr.File), 2) // запись повтора не поместилась
+	})
+}
+
+func TestDownloadSeq(t *testing.T) {
+	const total, batch = 10, 3
+
+	// URL отдаются последовательностью: считается, сколько прочитано к запросу каждого файла
+	var pulled, overrun atomic.Int32
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		first := requests[r.URL.Path] == 1
+		mu.Unlock()
+		i, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".jpg"))
+		if first && pulled.Load() > int32((i/batch+1)*batch) {
+			overrun.Add(1)
+		}
+		serveFile("image/jpeg", jpegData)(w, r)
+	}))
+	defer srv.Close()
+
+	urls := func(yield func(string) bool) {
+		for i := range total {
+			pulled.Add(1)
+			if !yield(fmt.Sprintf("%s/%d.jpg", srv.URL, i)) {
+				return
+			}
+		}
+		// повтор первого URL в другой пачке загружается заново
+		pulled.Add(1)
+		yield(srv.URL + "/0.jpg")
+	}
+
+	ldr := newTestLoader(config.Loader{DownloadConcurrency: 2})
+	var buf bytes.Buffer
+	files, err := ldr.DownloadSeq(context.Background(), urls, batch, &buf, Archive{})
+	be.Err(t, err, nil)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	be.Err(t, err, nil)
+
+	// пачка читается только после загрузки предыдущей
+	be.Equal(t, overrun.Load(), int32(0))
+	be.Equal(t, requests["/0.jpg"], 2)
+
+	// нумерация файлов сквозная
+	be.Equal(t, len(files), total+1)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	for i := range total + 1 {
+		be.Equal(t, files[i].Status, http.StatusOK)
+		be.Equal(t, names[i], fmt.Sprintf("unnamed-%d.jpg", i+1))
+	}
+	be.Equal(t, names[total+1:], []string{"status.json"})
+}
+
+func TestDownload_MaxTotalSize(t *testing.T) {
+	var requested sync.Map
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.Path, true)
+		switch r.URL.Path {
+		case "/chunked.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			for i := 0; i < len(jpegData); i += 100 {
+				w.Write(jpegData[i:min(i+100, len(jpegData))])
+				w.(http.Flusher).Flush() // без Content-Length
+			}
+		default:
+			serveFile("image/jpeg", jpegData)(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// помещается один файл
+	budget := int64(len(jpegData) + len(jpegData)/2)
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			for _, first := range []string{"/a.jpg", "/chunked.jpg"} {
+				requested.Clear()
+				ldr := newTestLoader(config.Loader{MaxTotalSize: budget, DownloadConcurrency: workers})
+				urls := []string{srv.URL + first, srv.URL + "/b.jpg", srv.URL + "/c.jpg", srv.URL + "/d.jpg"}
+
+				files, zr := download(t, ldr, urls, Archive{})
+				be.Equal(t, len(files), len(urls))
+				be.Equal(t, files[0].Status, http.StatusOK)
+				for _, f := range files[1:] {
+					be.Equal(t, f.Status, http.StatusInsufficientStorage)
+					be.True(t, strings.HasPrefix(f.ErrorMsg, errArchiveTooLarge.Error()))
+				}
+				be.True(t, strings.Contains(files[1].ErrorMsg, fmt.Sprintf("remaining %d", budget-int64(len(jpegData)))))
+
+				// архив завершён, в нём только поместившийся файл
+				be.Equal(t, len(zr.File), 2)
+				be.Equal(t, readEntry(t, zr.File[0]), string(jpegData))
+				be.Equal(t, zr.File[1].Name, "status.json")
+
+				// при последовательной загрузке после превышения файлы не запрашиваются
+				if workers == 1 {
+					_, ok := requested.Load("/d.jpg")
+					be.True(t, !ok)
+				}
+			}
+		})
+	}
+
+	t.Run("chunked over budget", func(t *testing.T) {
+		ldr := newTestLoader(config.Loader{MaxTotalSize: int64(len(jpegData) / 2)})
+		files, zr := download(t, ldr, []string{srv.URL + "/chunked.jpg"}, Archive{})
+		be.Equal(t, files[0].Status, http.StatusInsufficientStorage)
+		be.Equal(t, len(zr.File), 1) // обрезанной записи нет
+	})
+}
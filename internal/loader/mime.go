@@ -3,63 +3,136 @@ package loader
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"zipget/internal/model"
 )
 
-type FileType struct {
-	MIMEType   string
-	Magic      []byte // сигнатура файла
-	Extensions []string
-}
-
-func (f FileType) Extension() string {
-	if len(f.Extensions) == 0 {
-		return ""
-	}
-	return f.Extensions[0]
-}
+type FileType = model.FileType
 
+// fileTypes - встроенные типы файлов. Дополнительные типы задаются в LOADER_EXTRA_TYPES.
 var fileTypes = []FileType{
 	{
 		MIMEType:   "image/jpeg",
 		Magic:      []byte{0xFF, 0xD8, 0xFF}, // ÿØÿ
 		Extensions: []string{".jpg", ".jpeg"},
+		Compressed: true,
 	},
 	{
 		MIMEType:   "image/png",
 		Magic:      []byte{0x89, 0x50, 0x4E, 0x47}, // ‰PNG
 		Extensions: []string{".png"},
+		Compressed: true,
 	},
 	{
 		MIMEType:   "image/gif",
 		Magic:      []byte{0x47, 0x49, 0x46, 0x38}, // GIF8
 		Extensions: []string{".gif"},
+		Compressed: true,
 	},
 	{
 		MIMEType:   "application/pdf",
 		Magic:      []byte{0x25, 0x50, 0x44, 0x46}, // %PDF
 		Extensions: []string{".pdf"},
+		Compressed: true,
 	},
 	{
 		MIMEType:   "application/zip",
 		Magic:      []byte{0x50, 0x4B, 0x03, 0x04}, // PK
 		Extensions: []string{".zip"},
+		Compressed: true,
 	},
 	// ...
 }
 
 var ErrUnknownFileType = errors.New("unknown file type")
 
-func getFileTypeBySignature(magic []byte) (FileType, error) {
-	for _, ft := range fileTypes {
-		if bytes.HasPrefix(magic, ft.Magic) {
-			return ft, nil
+// Режимы проверки сигнатуры файла (config.Loader.SignatureCheck)
+const (
+	SignatureStrict  = "strict"  // файл с неизвестной сигнатурой отклоняется
+	SignatureLenient = "lenient" // файл с неизвестной сигнатурой принимается, если разрешён заявленный тип
+)
+
+// newFileTypes возвращает таблицу типов: дополнительные типы extra (в порядке объявления), затем
+// встроенные. Дополнительный тип с тем же MIME-типом, что и встроенный, заменяет его расширения,
+// а при совпадении сигнатур одинаковой длины побеждает (признак сжатого формата сохраняется).
+// Некорректные типы пропускаются с предупреждением.
+func newFileTypes(extra []FileType) []FileType {
+	types := make([]FileType, 0, len(extra)+len(fileTypes))
+	for _, ft := range extra {
+		if err := validateFileType(ft); err != nil {
+			slog.Warn("extra file type skipped", "mimeType", ft.MIMEType, "error", err)
+			continue
 		}
+		if builtin, err := getFileTypeByMIME(fileTypes, ft.MIMEType); err == nil {
+			ft.Compressed = ft.Compressed || builtin.Compressed
+		}
+		types = append(types, ft)
 	}
-	return FileType{}, ErrUnknownFileType
+	return append(types, fileTypes...)
+}
+
+func validateFileType(ft FileType) error {
+	switch {
+	case ft.MIMEType == "":
+		return errors.New("empty mime type")
+	case len(ft.Magic) == 0:
+		return errors.New("empty signature")
+	case len(ft.Magic) > magicLen:
+		return fmt.Errorf("signature is longer than %d bytes", magicLen)
+	}
+	return nil
+}
+
+// checkAllowedTypes предупреждает о разрешённых MIME-типах без известной сигнатуры: файлы таких
+// типов принимаются, только если их распознаёт http.DetectContentType или в режиме
+// LOADER_SIGNATURE_CHECK=lenient (по заявленному типу).
+func checkAllowedTypes(types []FileType, allowed []string, signatureCheck string) {
+	for _, mimeType := range allowed {
+		if _, err := getFileTypeByMIME(types, mimeType); err == nil {
+			continue
+		}
+		if signatureCheck == SignatureLenient {
+			slog.Warn("allowed type has no known signature, files will be unverified", "mimeType", mimeType)
+		} else {
+			slog.Warn("allowed type has no known signature, files will be accepted only if detected by content sniffing", "mimeType", mimeType)
+		}
+	}
+}
+
+// getFileTypeBySignature определяет тип файла по сигнатуре. Если подходят несколько сигнатур
+// (например, форматы на основе zip), выбирается самая длинная (наиболее точная), поэтому
+// результат не зависит от порядка объявления типов. Сигнатуры одинаковой длины не должны
+// пересекаться (при совпадении побеждает объявленная раньше), длина сигнатуры - не больше magicLen.
+func getFileTypeBySignature(types []FileType, magic []byte) (FileType, error) {
+	var found *FileType
+	for i := range types {
+		ft := &types[i]
+		if bytes.HasPrefix(magic, ft.Magic) && (found == nil || len(ft.Magic) > len(found.Magic)) {
+			found = ft
+		}
+	}
+	if found == nil {
+		return FileType{}, ErrUnknownFileType
+	}
+	return *found, nil
+}
+
+// sniffContentType определяет MIME-тип по началу файла стандартным http.DetectContentType
+// (параметры вроде charset отбрасываются). Неопознанные данные (application/octet-stream) дают "".
+func sniffContentType(data []byte) string {
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if mimeType == "application/octet-stream" {
+		return ""
+	}
+	return mimeType
 }
 
-func getFileTypeByMIME(mimeType string) (FileType, error) {
-	for _, ft := range fileTypes {
+func getFileTypeByMIME(types []FileType, mimeType string) (FileType, error) {
+	for _, ft := range types {
 		if ft.MIMEType == mimeType {
 			return ft, nil
 		}
@@ -0,0 +1,40 @@
+package loader
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestGetFileTypeBySignature(t *testing.T) {
+	types := []FileType{
+		{MIMEType: "application/zip", Magic: []byte("PK\x03\x04")},
+		{MIMEType: "application/x-test-short", Magic: []byte("PK")},
+		{MIMEType: "application/x-test-long", Magic: []byte("PK\x03\x04\x14\x00")},
+	}
+
+	tests := []struct {
+		magic []byte
+		want  string
+	}{
+		{[]byte("PK\x03\x04\x14\x00\x08\x00"), "application/x-test-long"},
+		{[]byte("PK\x03\x04\x0A\x00\x00\x00"), "application/zip"},
+		{[]byte("PK\x05\x06"), "application/x-test-short"},
+	}
+
+	// результат не зависит от порядка объявления
+	reversed := slices.Clone(types)
+	slices.Reverse(reversed)
+
+	for _, list := range [][]FileType{types, reversed} {
+		for _, tt := range tests {
+			ft, err := getFileTypeBySignature(list, tt.magic)
+			be.Err(t, err, nil)
+			be.Equal(t, ft.MIMEType, tt.want)
+		}
+
+		_, err := getFileTypeBySignature(list, []byte("%PDF"))
+		be.Err(t, err, ErrUnknownFileType)
+	}
+}
@@ -0,0 +1,72 @@
+package loader
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Обработка ответа 206 Partial Content на запрос без Range (config.Loader.PartialContent)
+const (
+	PartialReject = "reject" // 206 считается ошибкой
+	PartialFull   = "full"   // 206 принимается, если Content-Range покрывает весь файл
+	PartialAccept = "accept" // 206 принимается всегда
+)
+
+// responseStatus возвращает статус ответа с учётом режима PartialContent.
+// Первый запрос файла не содержит заголовок Range (он отправляется только при докачке,
+// см. rangeReader), поэтому 206 означает лишь, что источник (или CDN) отдаёт содержимое
+// нестандартно. Принятый ответ 206 считается ответом 200.
+func (ldr *Loader) responseStatus(resp *http.Response) int {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.StatusCode
+	}
+
+	switch ldr.cfg.PartialContent {
+	case PartialAccept:
+		return http.StatusOK
+	case PartialFull:
+		if isFullContentRange(resp.Header.Get("Content-Range")) {
+			return http.StatusOK
+		}
+	}
+	return resp.StatusCode
+}
+
+// isFullContentRange сообщает, что Content-Range ("bytes first-last/complete")
+// описывает всё содержимое: диапазон 0..complete-1. Длина "*" не позволяет это проверить.
+func isFullContentRange(s string) bool {
+	first, last, total, ok := parseContentRange(s)
+	return ok && first == 0 && last == total-1
+}
+
+// parseContentRange разбирает Content-Range ("bytes first-last/complete") и возвращает границы
+// диапазона и полный размер. Длина "*" не поддерживается.
+func parseContentRange(s string) (first, last, total int64, ok bool) {
+	rng, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	rng, complete, ok := strings.Cut(strings.TrimSpace(rng), "/")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	firstStr, lastStr, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	first, err := strconv.ParseInt(firstStr, 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, 0, false
+	}
+	last, err = strconv.ParseInt(lastStr, 10, 64)
+	if err != nil || last < first {
+		return 0, 0, 0, false
+	}
+	total, err = strconv.ParseInt(complete, 10, 64)
+	if err != nil || total <= last {
+		return 0, 0, 0, false
+	}
+	return first, last, total, true
+}
@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+
+	"zipget/internal/model"
+)
+
+// Размеры служебных структур ZIP-архива (без учёта имени файла)
+const (
+	zipLocalHeaderLen   = 30
+	zipDataDescriptor   = 24 // с учётом ZIP64
+	zipCentralHeaderLen = 46
+	zipEndRecordLen     = 22
+)
+
+// Plan по результатам проверки предсказывает содержимое архива, не скачивая файлы.
+//
+// Файлы берутся в том же порядке и по тем же правилам, что и при загрузке: в архив попадут
+// файлы со статусом 200. Имя строится по оригинальному имени и расширению заявленного MIME-типа,
+// размер - по заявленному Content-Length.
+//
+// Размер приблизительный: сжатие не учитывается (оценка сверху для несжимаемых данных),
+// реальный тип и размер файла станут известны только при загрузке.
+func (ldr *Loader) Plan(files []File) model.Preview {
+	var preview model.Preview
+
+	report := make([]File, 0, len(files)) // будущий status.json: только скачиваемые файлы
+	for i := range files {
+		file := files[i]
+		if file.Status != http.StatusOK {
+			continue
+		}
+
+		uniqueNum := len(report) + 1
+		ft, _ := getFileTypeByMIME(ldr.types, file.ContentType)
+		file.Name = constructFileName(file.OrigName, ft.Extension(), uniqueNum)
+		report = append(report, file)
+
+		preview.Entries = append(preview.Entries, file.Name)
+		preview.Size += zipEntrySize(file.Name, file.Size)
+		preview.Size += int64(len(ldr.entryComment(file.URL))) // комментарий хранится в центральном каталоге
+	}
+
+	if ldr.cfg.SHA256Sums {
+		var n int64
+		for _, name := range preview.Entries {
+			n += sha256.Size*2 + 2 + int64(len(name)) + 1
+		}
+		preview.Size += zipEntrySize(sha256SumsName, n)
+	}
+
+	// status.json пишется всегда
+	buf, _ := json.MarshalIndent(ldr.statusReport(report, nil), "", "    ")
+	preview.Size += zipEntrySize("status.json", int64(len(buf)+1))
+	preview.Size += zipEndRecordLen
+
+	return preview
+}
+
+func zipEntrySize(name string, size int64) int64 {
+	n := int64(len(name))
+	return zipLocalHeaderLen + n + size + zipDataDescriptor + zipCentralHeaderLen + n
+}
@@ -0,0 +1,57 @@
+package loader
+
+import (
+	"iter"
+	"sync"
+)
+
+// forEach вызывает fn(i) для каждого i из [0, n), используя не более workers параллельных потоков.
+// Если workers <= 0, для каждого i создаётся отдельный поток. Возвращается после завершения всех вызовов.
+func forEach(n, workers int, fn func(i int)) {
+	if workers <= 0 || workers > n {
+		workers = n
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	next := make(chan int)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := range n {
+		next <- i
+	}
+	close(next)
+
+	wg.Wait()
+}
+
+// batches разбивает последовательность seq на пачки не больше size (size <= 0 - одна пачка со
+// всеми элементами). Пустые пачки не отдаются. Срез пачки переиспользуется.
+func batches[T any](seq iter.Seq[T], size int) iter.Seq[[]T] {
+	return func(yield func([]T) bool) {
+		var batch []T
+		if size > 0 {
+			batch = make([]T, 0, size)
+		}
+		for v := range seq {
+			batch = append(batch, v)
+			if len(batch) == size {
+				if !yield(batch) {
+					return
+				}
+				batch = batch[:0]
+			}
+		}
+		if len(batch) > 0 {
+			yield(batch)
+		}
+	}
+}
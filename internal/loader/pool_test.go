@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+func TestForEach(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 100} {
+		var mu sync.Mutex
+		seen := make(map[int]int)
+		forEach(10, workers, func(i int) {
+			mu.Lock()
+			seen[i]++
+			mu.Unlock()
+		})
+		be.Equal(t, len(seen), 10)
+		for i := range 10 {
+			be.Equal(t, seen[i], 1)
+		}
+	}
+
+	forEach(0, 3, func(i int) { t.Fatal("must not be called") })
+}
+
+// concurrencyMeter считает максимальное количество одновременно обрабатываемых запросов.
+type concurrencyMeter struct {
+	cur, max atomic.Int32
+}
+
+func (m *concurrencyMeter) wrap(delay time.Duration, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := m.cur.Add(1)
+		defer m.cur.Add(-1)
+		for {
+			old := m.max.Load()
+			if n <= old || m.max.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		next.ServeHTTP(w, r)
+	}
+}
+
+func TestCheck_BoundedConcurrency(t *testing.T) {
+	var meter concurrencyMeter
+	srv := httptest.NewServer(meter.wrap(20*time.Millisecond, serveFile("image/jpeg", jpegData)))
+	defer srv.Close()
+
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = srv.URL
+	}
+
+	ldr := newTestLoader(config.Loader{Concurrency: 2})
+	files, err := ldr.Check(context.Background(), urls)
+	be.Err(t, err, nil)
+	be.Equal(t, len(files), len(urls))
+	for _, f := range files {
+		be.Equal(t, f.Status, http.StatusOK)
+	}
+	be.Equal(t, meter.max.Load(), int32(2))
+}
+
+func TestBatches(t *testing.T) {
+	for _, tt := range []struct {
+		size int
+		want [][]int
+	}{
+		{2, [][]int{{0, 1}, {2, 3}, {4}}},
+		{5, [][]int{{0, 1, 2, 3, 4}}},
+		{0, [][]int{{0, 1, 2, 3, 4}}},
+	} {
+		var got [][]int
+		for batch := range batches(slices.Values([]int{0, 1, 2, 3, 4}), tt.size) {
+			got = append(got, slices.Clone(batch))
+		}
+		be.Equal(t, got, tt.want)
+	}
+
+	for range batches(slices.Values([]int(nil)), 2) {
+		t.Fatal("empty sequence yields no batches")
+	}
+}
@@ -0,0 +1,98 @@
+package loader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxRangeResumes - сколько раз прерванная загрузка докачивается запросами Range.
+const maxRangeResumes = 3
+
+// errRangeNotSatisfied - источник не продолжил передачу с нужного байта (например, файл изменился).
+var errRangeNotSatisfied = errors.New("origin did not resume the download")
+
+// rangeResumable сообщает, что загрузку по ответу resp можно докачивать запросами Range
+// (режим LOADER_ENABLE_RANGE): источник поддерживает Accept-Ranges: bytes и сообщил размер файла.
+func (ldr *Loader) rangeResumable(resp *http.Response) bool {
+	return ldr.cfg.EnableRange &&
+		resp.StatusCode == http.StatusOK &&
+		resp.ContentLength > 0 &&
+		strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes")
+}
+
+// rangeReader читает тело ответа и при обрыве соединения продолжает чтение с последнего
+// полученного байта запросом Range (не больше maxRangeResumes раз). Чтобы источник не отдал
+// продолжение изменившегося файла, запрос содержит If-Range с ETag или Last-Modified.
+type rangeReader struct {
+	ldr     *Loader
+	ctx     context.Context
+	log     *slog.Logger
+	req     *http.Request // исходный запрос
+	body    io.ReadCloser
+	ifRange string
+	offset  int64 // получено байт
+	size    int64 // размер файла
+	resumes int
+}
+
+func (ldr *Loader) newRangeReader(ctx context.Context, log *slog.Logger, resp *http.Response) *rangeReader {
+	return &rangeReader{
+		ldr:     ldr,
+		ctx:     ctx,
+		log:     log,
+		req:     resp.Request,
+		body:    resp.Body,
+		ifRange: cmp.Or(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")),
+		size:    resp.ContentLength,
+	}
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	n, err := rr.body.Read(p)
+	rr.offset += int64(n)
+	if err == nil || err == io.EOF || rr.offset >= rr.size || rr.resumes >= maxRangeResumes || rr.ctx.Err() != nil {
+		return n, err
+	}
+
+	rr.resumes++
+	rr.log.Debug("resume download", "error", err, "offset", rr.offset, "attempt", rr.resumes)
+	if rerr := rr.resume(); rerr != nil {
+		return n, fmt.Errorf("%w: %w (resume at %d: %w)", err, errRangeNotSatisfied, rr.offset, rerr)
+	}
+	return n, nil
+}
+
+// resume запрашивает продолжение файла с байта offset.
+func (rr *rangeReader) resume() error {
+	rr.body.Close()
+	rr.body = http.NoBody
+
+	req := rr.req.Clone(rr.ctx)
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(rr.offset, 10)+"-")
+	if rr.ifRange != "" {
+		req.Header.Set("If-Range", rr.ifRange)
+	}
+
+	resp, err := rr.ldr.do(req)
+	if err != nil {
+		return err
+	}
+	first, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if resp.StatusCode != http.StatusPartialContent || !ok || first != rr.offset || total != rr.size {
+		resp.Body.Close()
+		return fmt.Errorf("unexpected response: %s, Content-Range %q", resp.Status, resp.Header.Get("Content-Range"))
+	}
+	rr.body = resp.Body
+	return nil
+}
+
+func (rr *rangeReader) Close() error {
+	return rr.body.Close()
+}
@@ -0,0 +1,145 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"zipget/internal/logger"
+	"zipget/internal/protect"
+)
+
+// do выполняет запрос и повторяет его при временной ошибке: сетевой ошибке или ответе
+// 500, 502, 503, 504. Задержка перед повтором - RetryBackoff, удваивающаяся с каждой попыткой;
+// если источник ответил 503 с заголовком Retry-After, используется его задержка (не более MaxRetryAfter).
+// Ответы 4xx, блокировки SSRF и ошибки перенаправлений не повторяются.
+//
+// Количество повторов ограничено Retries, общее время - дедлайном контекста:
+// если до дедлайна не дождаться, возвращается последний ответ или ошибка.
+// Каждая попытка учитывается в ограничении частоты запросов к хосту (LOADER_HOST_RPS).
+func (ldr *Loader) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	log := logger.FromContext(ctx)
+
+	host := req.URL.Host
+
+	for attempt := 0; ; attempt++ {
+		if !ldr.breaker.allow(host) {
+			return nil, fmt.Errorf("%w for host %s", ErrCircuitOpen, host)
+		}
+
+		if err := ldr.hosts.allow(ctx, req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+
+		resp, err := ldr.client.Do(req)
+		ldr.trackHealth(ctx, host, resp, err)
+		if attempt >= ldr.cfg.Retries || !transient(ctx, resp, err) {
+			return resp, err
+		}
+
+		delay := ldr.cfg.RetryBackoff << attempt
+		if err == nil {
+			if d, ok := ldr.retryAfter(resp); ok {
+				delay = d
+			}
+		}
+		if !canWait(ctx, delay) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Debug("retry after error", "error", err, "delay", delay.String(), "attempt", attempt+1)
+		} else {
+			resp.Body.Close()
+			log.Debug("retry after", "status", resp.StatusCode, "delay", delay.String(), "attempt", attempt+1)
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+
+		req = req.Clone(ctx)
+	}
+}
+
+// transient сообщает, что запрос завершился временной ошибкой и его можно повторить.
+// Отмена запроса вызывающей стороной, блокировка SSRF и ошибки перенаправлений временными не считаются.
+func transient(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil &&
+			!errors.Is(err, protect.ErrSSRF) &&
+			!errors.Is(err, protect.ErrTooManyRedirects) &&
+			!errors.Is(err, protect.ErrRedirectDowngrade)
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// trackHealth учитывает результат запроса в выключателе хоста.
+// Отмена запроса вызывающей стороной и блокировка SSRF не считаются неудачей источника
+// (в отличие от таймаута ожидания ответа).
+func (ldr *Loader) trackHealth(ctx context.Context, host string, resp *http.Response, err error) {
+	switch {
+	case err != nil && (isCallerCancel(ctx) || errors.Is(err, protect.ErrSSRF)):
+		return
+	case err != nil || resp.StatusCode >= 500:
+		ldr.breaker.failure(host)
+	default:
+		ldr.breaker.success(host)
+	}
+}
+
+// retryAfter возвращает задержку из заголовка Retry-After ответа 503.
+// Заголовок может содержать количество секунд или HTTP-дату.
+func (ldr *Loader) retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if secs, err := strconv.Atoi(s); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(s); err == nil {
+		delay = time.Until(t)
+	} else {
+		return 0, false
+	}
+
+	delay = max(delay, 0)
+	if ldr.cfg.MaxRetryAfter > 0 {
+		delay = min(delay, ldr.cfg.MaxRetryAfter)
+	}
+	return delay, true
+}
+
+// canWait сообщает, успеем ли мы подождать delay до дедлайна контекста.
+func canWait(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > delay
+}
+
+// sleep ждёт delay или отмены контекста.
+func sleep(ctx context.Context, delay time.Duration) error {
+	tm := time.NewTimer(delay)
+	defer tm.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tm.C:
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package loader
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// newBandwidthLimiter создаёт общий для всех загрузок ограничитель скорости чтения
+// bps байт в секунду (nil, если bps <= 0).
+func newBandwidthLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), int(min(bps, bufSize)))
+}
+
+// throttle возвращает r, чтение из которого ограничено общим лимитом LOADER_GLOBAL_MAX_BPS.
+func (ldr *Loader) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if ldr.bandwidth == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, lim: ldr.bandwidth}
+}
+
+// throttledReader после каждого чтения ждёт, пока общий ограничитель выдаст прочитанные байты.
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// за раз нельзя получить больше burst байт
+	if burst := tr.lim.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if werr := tr.lim.WaitN(tr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
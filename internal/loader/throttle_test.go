@@ -0,0 +1,54 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+func TestDownload_GlobalMaxBPS(t *testing.T) {
+	const (
+		bps       = 64 << 10
+		fileSize  = 16 << 10
+		downloads = 3
+	)
+	data := append(bytes.Clone(jpegData[:4]), make([]byte, fileSize-4)...)
+	srv := httptest.NewServer(serveFile("image/jpeg", data))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{GlobalMaxBPS: bps})
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files, err := ldr.Download(context.Background(), []string{srv.URL + "/a.jpg"}, io.Discard, Archive{})
+			be.Err(t, err, nil)
+			be.Equal(t, files[0].Status, http.StatusOK)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// сверх начального запаса (burst) общая скорость не превышает лимит
+	total := float64(downloads * fileSize)
+	minElapsed := time.Duration((total - bufSize) / bps * float64(time.Second))
+	be.True(t, elapsed >= minElapsed)
+}
+
+func TestThrottle_Disabled(t *testing.T) {
+	ldr := newTestLoader(config.Loader{})
+	r := bytes.NewReader(nil)
+	be.Equal(t, ldr.throttle(context.Background(), r), io.Reader(r))
+}
@@ -0,0 +1,41 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errResponseTimeout - причина отмены запроса по таймауту ожидания источника.
+// В отличие от отмены вызывающей стороной считается неудачей источника.
+var errResponseTimeout = errors.New("response timeout")
+
+// withTimeout ограничивает время выполнения запроса целиком (используется для проверки HEAD).
+// Если d <= 0, время не ограничивается.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, d, errResponseTimeout)
+}
+
+// withHeaderTimeout ограничивает только время ожидания заголовков ответа (используется для загрузки).
+// После получения заголовков нужно вызвать stop, чтобы чтение тела таймаутом не ограничивалось.
+// Если d <= 0, время не ограничивается.
+func withHeaderTimeout(ctx context.Context, d time.Duration) (_ context.Context, stop func(), cancel context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	if d <= 0 {
+		return ctx, func() {}, func() { cancelCause(nil) }
+	}
+
+	tm := time.AfterFunc(d, func() { cancelCause(errResponseTimeout) })
+	return ctx, func() { tm.Stop() }, func() {
+		tm.Stop()
+		cancelCause(nil)
+	}
+}
+
+// isCallerCancel сообщает, что запрос отменён вызывающей стороной, а не по таймауту источника.
+func isCallerCancel(ctx context.Context) bool {
+	return ctx.Err() != nil && !errors.Is(context.Cause(ctx), errResponseTimeout)
+}
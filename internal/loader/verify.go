@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+
+	"zipget/internal/logger"
+)
+
+var ErrCorruptArchive = errors.New("archive is corrupt")
+
+// downloadVerified собирает архив во временный файл, проверяет его и только затем
+// копирует в out. Если архив повреждён, в out ничего не пишется.
+func (ldr *Loader) downloadVerified(ctx context.Context, urls iter.Seq[string], batch int, out io.Writer, arch Archive) ([]File, error) {
+	log := logger.FromContext(ctx).With("op", "downloadVerified")
+
+	tmp, err := os.CreateTemp("", "zipget-*"+ArchiveExtension(arch.Format))
+	if err != nil {
+		return nil, fmt.Errorf("create temp file failed: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	files, err := ldr.download(ctx, urls, batch, tmp, arch)
+	if err != nil {
+		return files, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return files, err
+	}
+	verify := verifyArchive
+	if arch.Format == FormatTarGz {
+		verify = verifyTarGz
+	}
+	if err := verify(tmp, size); err != nil {
+		log.Error("archive verification failed", "error", err)
+		return files, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return files, err
+	}
+	ow := &outputWriter{w: out}
+	if _, err := io.Copy(ow, tmp); err != nil {
+		if ow.err != nil {
+			return files, fmt.Errorf("%w: %w", ErrWriteOutput, ow.err)
+		}
+		return files, err
+	}
+	return files, nil
+}
+
+// verifyArchive проверяет, что архив читается и каждая запись распаковывается
+// с совпадающей контрольной суммой (CRC-32 проверяет zip.Reader при чтении до конца).
+func verifyArchive(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+
+	for _, f := range zr.File {
+		if err := verifyEntry(f); err != nil {
+			return fmt.Errorf("%w: entry %q: %v", ErrCorruptArchive, f.Name, err)
+		}
+	}
+	return nil
+}
+
+// verifyTarGz проверяет, что архив tar.gz читается до конца (CRC-32 потока gzip
+// проверяет gzip.Reader) и каждая запись имеет заявленный размер.
+func verifyTarGz(r io.ReaderAt, size int64) error {
+	gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("%w: entry %q: %v", ErrCorruptArchive, th.Name, err)
+		}
+	}
+	// остаток потока gzip после конца tar (проверка CRC-32 и длины)
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	return nil
+}
+
+func verifyEntry(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
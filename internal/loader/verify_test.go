@@ -0,0 +1,61 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zipget/internal/config"
+
+	"github.com/nalgeon/be"
+)
+
+func TestDownload_VerifyArchive(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	ldr := newTestLoader(config.Loader{VerifyArchive: true})
+	files, zr := download(t, ldr, []string{srv.URL + "/a.jpg"}, Archive{})
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, readEntry(t, zr.File[0]), string(jpegData))
+
+	// временный файл удалён
+	tmp, err := filepath.Glob(filepath.Join(os.TempDir(), "zipget-*.zip"))
+	be.Err(t, err, nil)
+	be.Equal(t, len(tmp), 0)
+}
+
+func TestVerifyArchive(t *testing.T) {
+	srv := httptest.NewServer(serveFile("image/jpeg", jpegData))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	_, err := newTestLoader(config.Loader{}).Download(context.Background(), []string{srv.URL + "/a.jpg"}, &buf, Archive{})
+	be.Err(t, err, nil)
+	archive := buf.Bytes()
+
+	be.Err(t, verifyArchive(bytes.NewReader(archive), int64(len(archive))), nil)
+
+	t.Run("corrupt entry", func(t *testing.T) {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		be.Err(t, err, nil)
+		offset, err := zr.File[0].DataOffset()
+		be.Err(t, err, nil)
+
+		corrupt := bytes.Clone(archive)
+		corrupt[offset+10] ^= 0xFF
+		be.Err(t, verifyArchive(bytes.NewReader(corrupt), int64(len(corrupt))), ErrCorruptArchive)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := archive[:len(archive)/2]
+		be.Err(t, verifyArchive(bytes.NewReader(truncated), int64(len(truncated))), ErrCorruptArchive)
+	})
+}
@@ -1,24 +1,38 @@
 package logger
 
 import (
+	"cmp"
 	"log/slog"
 	"math/rand/v2"
 	"net/http"
 	"runtime/debug"
+	"time"
 )
 
 // HTTPLogging создает middleware для логирования HTTP-запросов. Принимает логгер
 // и следующий обработчик в цепочке, возвращает новый обработчик с логированием.
-func HTTPLogging(log *slog.Logger, h http.Handler) http.Handler {
+// При timing по завершении запроса пишется строка "request completed" со статусом
+// и длительностью обработки (атрибут duration типа time.Duration).
+func HTTPLogging(log *slog.Logger, timing bool, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Генерируем уникальный ID для запроса и добавляем в логгер
 		log := log.With("reqID", rand.Uint64(), "from", r.RemoteAddr, "method", r.Method, "url", r.URL.String())
 		log.Debug("request received")
 
 		// Заменяем ResponseWriter на наш с хуком для логирования
-		w = &statusInterceptor{
+		si := &statusInterceptor{
 			ResponseWriter: w,
 			log:            log,
+			start:          time.Now(),
+		}
+		w = si
+
+		if timing {
+			defer func() {
+				log.Info("request completed",
+					"status", cmp.Or(si.status, http.StatusOK),
+					slog.Duration("duration", si.duration()))
+			}()
 		}
 
 		// Добавляем логгер в контекст запроса
@@ -44,7 +58,13 @@ func HTTPLogging(log *slog.Logger, h http.Handler) http.Handler {
 type statusInterceptor struct {
 	http.ResponseWriter
 	log    *slog.Logger
-	status int // 0 = не установлен, 1xx = информационные, 2xx-5xx = основной статус
+	status int       // 0 = не установлен, 1xx = информационные, 2xx-5xx = основной статус
+	start  time.Time // начало обработки запроса
+}
+
+// duration возвращает время, прошедшее с начала обработки запроса.
+func (si *statusInterceptor) duration() time.Duration {
+	return time.Since(si.start)
 }
 
 func (si *statusInterceptor) WriteHeader(status int) {
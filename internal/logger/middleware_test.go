@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+)
+
+// recordHandler сохраняет записи лога для проверки в тестах.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+// find возвращает запись с сообщением msg и её атрибуты.
+func (h *recordHandler) find(msg string) (map[string]slog.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return attrs, true
+	}
+	return nil, false
+}
+
+func TestHTTPLogging_Timing(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		rec := &recordHandler{}
+		h := HTTPLogging(slog.New(rec), true, handler)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		attrs, ok := rec.find("request completed")
+		be.True(t, ok)
+		be.Equal(t, attrs["status"].Int64(), int64(http.StatusTeapot))
+		be.Equal(t, attrs["duration"].Kind(), slog.KindDuration)
+		be.True(t, attrs["duration"].Duration() >= 10*time.Millisecond)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		rec := &recordHandler{}
+		h := HTTPLogging(slog.New(rec), true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		attrs, ok := rec.find("request completed")
+		be.True(t, ok)
+		be.Equal(t, attrs["status"].Int64(), int64(http.StatusInternalServerError))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rec := &recordHandler{}
+		h := HTTPLogging(slog.New(rec), false, handler)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		_, ok := rec.find("request completed")
+		be.True(t, !ok)
+	})
+}
@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// errNoWaiters - все запросы, ожидавшие архив, ушли (например, клиенты отключились).
+var errNoWaiters = errors.New("archive build abandoned: no waiting requests")
+
+// sharedBuild - сборка архива задачи, общая для одновременных запросов архива (см. ProcessTask).
+//
+// Архив пишется во временный файл, из которого каждый запрос читает его по мере сборки: запрос,
+// присоединившийся к идущей сборке, получает архив с начала, а медленный или отключившийся клиент
+// не задерживает и не прерывает сборку для остальных. Сборка не зависит от контекста начавшего её
+// запроса и отменяется, только когда не остаётся ни одного ожидающего запроса (или задача удалена).
+type sharedBuild struct {
+	taskID int64
+	spool  *os.File
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	written   int64
+	changed   chan struct{} // закрывается при каждой записи и при завершении сборки
+	done      bool
+	err       error
+	abandoned bool   // ожидающих запросов не осталось: запись прекращена
+	files     []File // результаты файлов, о которых уже сообщено
+	waiters   map[*buildWaiter]struct{}
+	refs      int // сама сборка и ожидающие запросы; последний удаляет временный файл
+}
+
+// buildWaiter - запрос, ожидающий архив общей сборки.
+type buildWaiter struct {
+	onFile func(File) // nil - результаты файлов не нужны
+}
+
+func newSharedBuild(taskID int64) (*sharedBuild, error) {
+	spool, err := os.CreateTemp("", "zipget-build-*")
+	if err != nil {
+		return nil, err
+	}
+	return &sharedBuild{
+		taskID:  taskID,
+		spool:   spool,
+		changed: make(chan struct{}),
+		waiters: make(map[*buildWaiter]struct{}),
+		refs:    1,
+	}, nil
+}
+
+// Write дописывает архив во временный файл и будит ожидающие запросы.
+func (b *sharedBuild) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	abandoned := b.abandoned
+	b.mu.Unlock()
+	if abandoned {
+		return 0, errNoWaiters
+	}
+
+	n, err := b.spool.Write(p)
+
+	b.mu.Lock()
+	b.written += int64(n)
+	b.notify()
+	b.mu.Unlock()
+	return n, err
+}
+
+// report сообщает результат файла всем ожидающим запросам (Archive.OnFile загрузчика).
+func (b *sharedBuild) report(file File) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.files = append(b.files, file)
+	for w := range b.waiters {
+		if w.onFile != nil {
+			w.onFile(file)
+		}
+	}
+}
+
+// join добавляет ожидающий запрос и сообщает ему результаты уже загруженных файлов.
+// Возвращает false, если сборка уже прекращена.
+func (b *sharedBuild) join(onFile func(File)) (*buildWaiter, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.abandoned {
+		return nil, false
+	}
+	w := &buildWaiter{onFile: onFile}
+	if onFile != nil {
+		for i := range b.files {
+			onFile(b.files[i])
+		}
+	}
+	b.waiters[w] = struct{}{}
+	b.refs++
+	return w, true
+}
+
+// leave снимает ожидающий запрос. Если он был последним, а сборка не завершена, сборка отменяется.
+func (b *sharedBuild) leave(w *buildWaiter) {
+	b.mu.Lock()
+	delete(b.waiters, w)
+	if len(b.waiters) == 0 && !b.done {
+		b.abandoned = true
+		b.cancel(errNoWaiters)
+	}
+	b.mu.Unlock()
+	b.release()
+}
+
+// finish завершает сборку с ошибкой err.
+func (b *sharedBuild) finish(err error) {
+	b.mu.Lock()
+	b.done, b.err = true, err
+	b.notify()
+	b.mu.Unlock()
+	b.release()
+}
+
+// release снимает ссылку на временный файл; последняя удаляет его.
+func (b *sharedBuild) release() {
+	b.mu.Lock()
+	b.refs--
+	last := b.refs == 0
+	b.mu.Unlock()
+	if last {
+		b.spool.Close()
+		os.Remove(b.spool.Name())
+	}
+}
+
+// notify будит ожидающие запросы. Вызывается под b.mu.
+func (b *sharedBuild) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// size возвращает размер записанной части архива.
+func (b *sharedBuild) size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.written
+}
+
+// readAll читает собранный архив целиком (для кэша). Вызывается до finish.
+func (b *sharedBuild) readAll() ([]byte, error) {
+	data := make([]byte, b.size())
+	_, err := b.spool.ReadAt(data, 0)
+	return data, err
+}
+
+// copyTo пишет архив в out по мере сборки и возвращает ошибку сборки, записи в out или ctx
+// (запрос ушёл). Данные, записанные в out до ошибки сборки, остаются у клиента.
+func (b *sharedBuild) copyTo(ctx context.Context, out io.Writer) error {
+	buf := make([]byte, 32<<10)
+	var off int64
+	for {
+		b.mu.Lock()
+		written, done, err, changed := b.written, b.done, b.err, b.changed
+		b.mu.Unlock()
+
+		if off < written {
+			n, readErr := b.spool.ReadAt(buf[:min(int64(len(buf)), written-off)], off)
+			if n > 0 {
+				if _, err := out.Write(buf[:n]); err != nil {
+					return err
+				}
+				off += int64(n)
+			}
+			if readErr != nil && readErr != io.EOF {
+				return readErr
+			}
+			continue
+		}
+		if done {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
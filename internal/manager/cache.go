@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// archiveCache хранит собранные архивы, вытесняя давно не использованные (LRU), чтобы повторный
+// запрос архива неизменившейся задачи не загружал файлы заново. Объём ограничен суммарным размером
+// архивов. Архив действует, пока не изменилась задача (см. archiveCacheKey) и не истёк её срок.
+//
+// nil *archiveCache ничего не хранит.
+type archiveCache struct {
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64                    // суммарный размер хранимых архивов
+	lru     *list.List               // *cachedArchive, в начале - недавно использованные
+	entries map[string]*list.Element // по ключу
+}
+
+type cachedArchive struct {
+	key     string
+	taskID  int64
+	expires time.Time // срок задачи (нулевой - без срока)
+	built   builtArchive
+}
+
+func newArchiveCache(maxSize int64) *archiveCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	return &archiveCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// archiveCacheKey возвращает ключ архива задачи: хеш формата и ID, URL, статусов, имён
+// и контрольных сумм файлов. Ключ меняется при добавлении файла и при изменении результата
+// проверки или загрузки любого файла (в том числе содержимого по тому же URL).
+func archiveCacheKey(taskID int64, format string, files []File) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00", taskID, format)
+	for i := range files {
+		f := &files[i]
+		fmt.Fprintf(h, "%d\x00%s\x00%d\x00%s\x00%s\x00", f.ID, f.URL, f.Status, f.Name, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fits сообщает, что архив размера size может храниться в кэше.
+func (c *archiveCache) fits(size int64) bool {
+	return c != nil && size <= c.maxSize
+}
+
+// get возвращает архив по ключу. Архив задачи с истёкшим сроком удаляется.
+func (c *archiveCache) get(key string) (builtArchive, bool) {
+	if c == nil {
+		return builtArchive{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return builtArchive{}, false
+	}
+	ca := e.Value.(*cachedArchive)
+	if ca.expired(time.Now()) {
+		c.remove(e)
+		return builtArchive{}, false
+	}
+	c.lru.MoveToFront(e)
+	return ca.built, true
+}
+
+// put сохраняет архив задачи, вытесняя давно не использованные. Архив больше всего кэша
+// не сохраняется.
+func (c *archiveCache) put(key string, taskID int64, expires time.Time, built builtArchive) {
+	size := int64(len(built.data))
+	if !c.fits(size) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	// сначала освобождаем место от архивов задач с истёкшим сроком, затем - от давно не использованных
+	now := time.Now()
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cachedArchive).expired(now) {
+			c.remove(e)
+		}
+		e = next
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedArchive{key: key, taskID: taskID, expires: expires, built: built})
+	c.size += size
+}
+
+// invalidate удаляет архивы задачи.
+func (c *archiveCache) invalidate(taskID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cachedArchive).taskID == taskID {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+func (c *archiveCache) remove(e *list.Element) {
+	ca := c.lru.Remove(e).(*cachedArchive)
+	delete(c.entries, ca.key)
+	c.size -= int64(len(ca.built.data))
+}
+
+func (ca *cachedArchive) expired(now time.Time) bool {
+	return !ca.expires.IsZero() && !now.Before(ca.expires)
+}
@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+	"zipget/internal/loader"
+	"zipget/internal/model"
+
+	"github.com/nalgeon/be"
+)
+
+func TestProcessTask_ArchiveCache(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes: []string{"application/pdf"},
+		SignatureCheck: loader.SignatureStrict,
+	})
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1, ArchiveCacheSize: 1 << 20}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/1.pdf"), nil)
+
+	build := func(format string) ([]byte, int) {
+		var buf bytes.Buffer
+		var reported int
+		be.Err(t, m.ProcessTask(ctx, taskID, format, &buf, func(File) { reported++ }), nil)
+		return buf.Bytes(), reported
+	}
+
+	// первый запрос собирает архив, повторный отдаётся из кэша с теми же результатами файлов
+	first, _ := build("")
+	be.Equal(t, requests.Load(), int32(1))
+	cached, reported := build("")
+	be.Equal(t, requests.Load(), int32(1))
+	be.Equal(t, cached, first)
+	be.Equal(t, reported, 1)
+
+	// другой формат - другой архив
+	build(loader.FormatTarGz)
+	be.Equal(t, requests.Load(), int32(2))
+
+	// добавление файла удаляет архивы задачи
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/2.pdf"), nil)
+	be.Equal(t, m.archives.lru.Len(), 0)
+	rebuilt, _ := build("")
+	be.Equal(t, requests.Load(), int32(4))
+	be.True(t, !bytes.Equal(rebuilt, first))
+	build("")
+	be.Equal(t, requests.Load(), int32(4))
+
+	// удаление задачи удаляет архивы задачи
+	be.Err(t, m.DeleteTask(ctx, taskID), nil)
+	be.Equal(t, m.archives.lru.Len(), 0)
+	be.Equal(t, m.archives.size, int64(0))
+}
+
+func TestProcessTask_ArchiveCacheChangedFile(t *testing.T) {
+	ctx := context.Background()
+	ldr := &countingLoader{fakeLoader: fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK, Name: "unnamed-1.pdf", SHA256: "aaaa"},
+	}}}
+	m, stor := newTestManager(t, config.Manager{MaxActive: 1, ArchiveCacheSize: 1 << 20}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &bytes.Buffer{}, nil), nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &bytes.Buffer{}, nil), nil)
+	be.Equal(t, ldr.downloads.Load(), int32(1))
+
+	// результат файла изменился (например, при проверке статуса): архив собирается заново
+	_, err = stor.UpdateTaskFiles(taskID, []File{{ID: 0, URL: "http://example.com/1.pdf", Status: http.StatusOK, Name: "unnamed-1.pdf", SHA256: "bbbb"}})
+	be.Err(t, err, nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &bytes.Buffer{}, nil), nil)
+	be.Equal(t, ldr.downloads.Load(), int32(2))
+}
+
+// countingLoader считает сборки архивов.
+type countingLoader struct {
+	fakeLoader
+	downloads atomic.Int32
+}
+
+func (l *countingLoader) Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]File, error) {
+	l.downloads.Add(1)
+	out.Write([]byte("archive"))
+	return l.fakeLoader.Download(ctx, urls, out, arch)
+}
+
+func TestArchiveCache(t *testing.T) {
+	archive := func(size int) builtArchive {
+		return builtArchive{data: make([]byte, size)}
+	}
+
+	c := newArchiveCache(10)
+	c.put("a", 1, time.Time{}, archive(4))
+	c.put("b", 2, time.Time{}, archive(4))
+
+	// a использован недавно: при нехватке места вытесняется b
+	_, ok := c.get("a")
+	be.True(t, ok)
+	c.put("c", 3, time.Time{}, archive(4))
+	_, ok = c.get("b")
+	be.True(t, !ok)
+	_, ok = c.get("a")
+	be.True(t, ok)
+	_, ok = c.get("c")
+	be.True(t, ok)
+	be.Equal(t, c.size, int64(8))
+
+	// архив больше кэша не сохраняется и ничего не вытесняет
+	c.put("big", 4, time.Time{}, archive(11))
+	_, ok = c.get("big")
+	be.True(t, !ok)
+	be.Equal(t, c.lru.Len(), 2)
+
+	// архив задачи с истёкшим сроком не отдаётся
+	c.put("expired", 5, time.Now().Add(-time.Second), archive(1))
+	_, ok = c.get("expired")
+	be.True(t, !ok)
+	be.Equal(t, c.size, int64(8))
+
+	c.invalidate(1)
+	_, ok = c.get("a")
+	be.True(t, !ok)
+	be.Equal(t, c.size, int64(4))
+
+	// кэш отключён
+	c = newArchiveCache(0)
+	be.True(t, c == nil)
+	c.put("a", 1, time.Time{}, archive(1))
+	_, ok = c.get("a")
+	be.True(t, !ok)
+}
@@ -2,15 +2,23 @@ package manager
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"zipget/internal/config"
 	"zipget/internal/logger"
+	"zipget/internal/metrics"
 	"zipget/internal/model"
+	"zipget/internal/taskid"
+	"zipget/internal/urlutil"
 )
 
 type (
@@ -20,20 +28,37 @@ type (
 
 type Loader interface {
 	Check(ctx context.Context, urls []string) ([]File, error)
-	Download(ctx context.Context, urls []string, out io.Writer) ([]File, error)
+	Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]File, error)
+	Plan(files []File) model.Preview
+}
+
+// Notifier доставляет уведомления (webhooks).
+type Notifier interface {
+	Notify(url string, payload any)
 }
 
 type Storage interface {
-	CreateTask(ctx context.Context) (int64, error)
+	CreateTask(ctx context.Context, opts model.TaskOptions) (int64, error)
 	DeleteTask(ctx context.Context, taskID int64) error
 	AddFileToTask(ctx context.Context, taskID int64, url string) error
+	GetTask(taskID int64) (Task, error)
 	GetTaskFiles(taskID int64) ([]File, error)
 	UpdateTaskFiles(taskID int64, files []File) (Task, error)
+	SetTaskPreview(taskID int64, preview model.Preview) (Task, error)
+	MarkTaskNotified(taskID int64) (bool, error)
+	FindTasks(ctx context.Context, filter model.TaskFilter, limit, offset int) ([]Task, int, error)
+	ExportTasks(ctx context.Context) ([]Task, error)
+	ImportTasks(ctx context.Context, tasks []Task) (int, error)
+	CleanExpiredTasks(ctx context.Context) (int, error)
 }
 
 var (
 	ErrTaskNotFound     = model.ErrTaskNotFound
 	ErrMaxFilesExceeded = model.ErrMaxFilesExceeded
+	ErrDuplicateURL     = model.ErrDuplicateURL
+	ErrNotEnoughFiles   = model.ErrNotEnoughFiles
+	ErrNoFiles          = model.ErrNoFiles
+	ErrInvalidCallback  = model.ErrInvalidCallback
 	ErrServerBusy       = model.ErrServerBusy
 	ErrServerCancelled  = model.ErrServerCancelled
 )
@@ -42,30 +67,124 @@ type Manager struct {
 	cfg      config.Manager
 	stor     Storage
 	loader   Loader
+	notifier Notifier      // nil - уведомления отключены
+	ids      *taskid.Codec // формат ID задачи в уведомлениях (nil - числовой)
 	muActive sync.Mutex
 	active   int // количество активных загрузок
+	muBuilds sync.Mutex
+	builds   map[string]*sharedBuild // идущие сборки архивов по ID задачи и формату
+	archives *archiveCache           // собранные архивы (nil - кэш отключён)
 }
 
-func New(cfg config.Manager, stor Storage, ldr Loader) *Manager {
+func New(cfg config.Manager, stor Storage, ldr Loader, ntf Notifier) *Manager {
 	slog.Debug("new manager", "cfg", cfg)
+
+	// MaxActive: <0 - без ограничений, 0 - загрузки выполняются по одной
+	if cfg.MaxActive == 0 {
+		cfg.MaxActive = 1
+	}
+
 	m := &Manager{
-		cfg:    cfg,
-		stor:   stor,
-		loader: ldr,
+		cfg:      cfg,
+		stor:     stor,
+		loader:   ldr,
+		notifier: ntf,
+		builds:   make(map[string]*sharedBuild),
+		archives: newArchiveCache(cfg.ArchiveCacheSize),
 	}
 	return m
 }
 
-func (m *Manager) CreateTask(ctx context.Context) (int64, error) {
-	return m.stor.CreateTask(ctx)
+func (m *Manager) CreateTask(ctx context.Context, opts model.TaskOptions) (int64, error) {
+	if opts.CallbackURL != "" {
+		if err := m.validateCallback(opts.CallbackURL); err != nil {
+			return 0, err
+		}
+	}
+	taskID, err := m.stor.CreateTask(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	metrics.TasksCreated.Inc()
+	return taskID, nil
+}
+
+// SetTaskIDs задаёт формат ID задачи в уведомлениях, тот же, что и в API.
+// Вызывается до начала работы менеджера.
+func (m *Manager) SetTaskIDs(ids *taskid.Codec) {
+	m.ids = ids
 }
 
+// validateCallback проверяет URL уведомления. Доступность адреса (SSRF) проверяется при доставке.
+func (m *Manager) validateCallback(callbackURL string) error {
+	if !m.cfg.Webhooks || m.notifier == nil {
+		return fmt.Errorf("%w: webhooks are disabled", ErrInvalidCallback)
+	}
+	u, err := url.ParseRequestURI(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: absolute http(s) url is required", ErrInvalidCallback)
+	}
+	return nil
+}
+
+// errTaskDeleted - задача удалена во время сборки её архива.
+var errTaskDeleted = fmt.Errorf("%w: deleted during download", ErrTaskNotFound)
+
+// DeleteTask удаляет задачу и прерывает активную сборку её архива: загрузка прекращается,
+// запись архива останавливается, слот освобождается.
 func (m *Manager) DeleteTask(ctx context.Context, taskID int64) error {
-	return m.stor.DeleteTask(ctx, taskID)
+	if err := m.stor.DeleteTask(ctx, taskID); err != nil {
+		return err
+	}
+	metrics.TasksDeleted.Inc()
+	m.archives.invalidate(taskID)
+
+	// Сборка регистрируется (m.builds) до чтения задачи из хранилища, поэтому сборка, начатая
+	// одновременно с удалением, либо отменяется здесь, либо не находит задачу
+	m.muBuilds.Lock()
+	for _, b := range m.builds {
+		if b.taskID == taskID {
+			b.cancel(errTaskDeleted)
+		}
+	}
+	m.muBuilds.Unlock()
+	return nil
+}
+
+// deletedWriter прекращает запись архива, как только задача удалена: архив удалённой задачи
+// не завершается, даже если загрузчик завершает архив при отмене (LOADER_FINALIZE_ON_CANCEL).
+type deletedWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w deletedWriter) Write(p []byte) (int, error) {
+	if err := context.Cause(w.ctx); errors.Is(err, errTaskDeleted) {
+		return 0, err
+	}
+	return w.w.Write(p)
 }
 
 func (m *Manager) AddFileToTask(ctx context.Context, taskID int64, url string) error {
-	return m.stor.AddFileToTask(ctx, taskID, url)
+	if m.cfg.NormalizeURLs {
+		url = urlutil.Normalize(url)
+	}
+	if err := m.stor.AddFileToTask(ctx, taskID, url); err != nil {
+		return err
+	}
+	m.archives.invalidate(taskID)
+	return nil
+}
+
+// GetTask возвращает сохранённое состояние задачи без проверки файлов (как его вернул бы
+// GetTaskStatus, если проверка ничего не изменит).
+func (m *Manager) GetTask(ctx context.Context, taskID int64) (Task, error) {
+	task, err := m.stor.GetTask(taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	m.checkBudget(&task)
+	return task, nil
 }
 
 func (m *Manager) GetTaskStatus(ctx context.Context, taskID int64) (Task, error) {
@@ -74,85 +193,364 @@ func (m *Manager) GetTaskStatus(ctx context.Context, taskID int64) (Task, error)
 		return Task{}, err
 	}
 
-	// составляем список URLs требующих проверки (еще не проверяли или BadGateway на прошлой проверке)
+	// составляем список URLs требующих проверки (еще не проверяли, временная ошибка на прошлой
+	// проверке, в т.ч. ограничение запросов к хосту, или загрузка была прервана отменой)
 	urls := make([]string, 0, len(files))
-	ids := make([]int64, 0, len(files))
+	idxs := make([]int, 0, len(files))
 
-	// Запоминае ID
+	// Запоминаем индексы
 	for i := range files {
-		if s := files[i].Status; s == 0 || s == http.StatusBadGateway {
+		if files[i].Status == 0 || files[i].Transient() || files[i].Interrupted {
 			urls = append(urls, files[i].URL)
-			ids = append(ids, files[i].ID)
+			idxs = append(idxs, i)
 		}
 	}
 
-	// чекаем URLs
+	// чекаем URLs (если проверять нечего, задача не обновляется)
+	var checked []File
 	if len(urls) > 0 {
-		files, err = m.loader.Check(ctx, urls)
+		checked, err = m.loader.Check(ctx, urls)
 		if err != nil {
 			return Task{}, err
 		}
 	}
 
-	// Востанавливаем ID
-	for i, id := range ids {
-		files[i].ID = id
+	for i, idx := range idxs {
+		checked[i] = rechecked(files[idx], checked[i])
+	}
+
+	task, err := m.stor.UpdateTaskFiles(taskID, checked)
+	if err != nil {
+		return Task{}, err
 	}
 
-	return m.stor.UpdateTaskFiles(taskID, files)
+	m.checkBudget(&task)
+	return task, nil
 }
 
-func (m *Manager) getDownloadSlot() bool {
+// rechecked возвращает запись файла после повторной проверки. Запись заменяется результатом
+// проверки целиком (статус, тип, размер, сообщение об ошибке и т.д.), чтобы восстановившийся файл
+// не сохранял ошибку прошлой проверки; от прежней записи остаются только ID и URL.
+func rechecked(prev, checked File) File {
+	checked.ID = prev.ID
+	checked.URL = prev.URL
+	return checked
+}
+
+// CheckURL проверяет один URL (как при получении статуса задачи), не создавая задачу.
+func (m *Manager) CheckURL(ctx context.Context, url string) (File, error) {
+	files, err := m.loader.Check(ctx, []string{url})
+	if err != nil {
+		return File{}, err
+	}
+	return files[0], nil
+}
+
+// FindTasks возвращает страницу задач, подходящих под фильтр, и их общее количество.
+// Файлы не проверяются: возвращается сохранённое состояние.
+func (m *Manager) FindTasks(ctx context.Context, filter model.TaskFilter, limit, offset int) ([]Task, int, error) {
+	return m.stor.FindTasks(ctx, filter, limit, offset)
+}
+
+// CleanExpiredTasks немедленно удаляет устаревшие задачи и возвращает их количество.
+func (m *Manager) CleanExpiredTasks(ctx context.Context) (int, error) {
+	return m.stor.CleanExpiredTasks(ctx)
+}
+
+// ExportTasks возвращает состояние всех задач для резервного копирования.
+func (m *Manager) ExportTasks(ctx context.Context) ([]Task, error) {
+	return m.stor.ExportTasks(ctx)
+}
+
+// ImportTasks восстанавливает задачи из резервной копии и возвращает их количество.
+func (m *Manager) ImportTasks(ctx context.Context, tasks []Task) (int, error) {
+	// задачи с существующими ID заменяются
+	for i := range tasks {
+		m.archives.invalidate(tasks[i].ID)
+	}
+	return m.stor.ImportTasks(ctx, tasks)
+}
+
+// PrepareTask проверяет файлы задачи и сохраняет оценку будущего архива (имена файлов и размер).
+// Файлы не скачиваются и слот загрузки не занимается.
+func (m *Manager) PrepareTask(ctx context.Context, taskID int64) (Task, error) {
+	task, err := m.GetTaskStatus(ctx, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+
+	preview := m.loader.Plan(task.Files)
+	preview.PreparedAt = time.Now()
+
+	task, err = m.stor.SetTaskPreview(taskID, preview)
+	if err != nil {
+		return Task{}, err
+	}
+
+	m.checkBudget(&task)
+	return task, nil
+}
+
+// EstimateArchiveSize возвращает приблизительный размер архива задачи, не проверяя и не скачивая
+// файлы: учитываются файлы, доступные (статус 200) по последней проверке, с заявленным размером,
+// а также служебные записи (status.json и др.) и структуры ZIP.
+//
+// Оценка приблизительная: сжатие и реальные размеры файлов станут известны только при сборке,
+// а непроверенные файлы не учитываются. Для задачи, архив которой не будет собран
+// (MinFiles, EmptyTask), возвращается та же ошибка, что и при сборке.
+func (m *Manager) EstimateArchiveSize(ctx context.Context, taskID int64) (int64, error) {
+	files, err := m.stor.GetTaskFiles(taskID)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.checkFileCount(files); err != nil {
+		return 0, err
+	}
+	return m.loader.Plan(files).Size, nil
+}
+
+// checkBudget считает суммарный заявленный размер доступных файлов задачи и отмечает
+// превышение бюджета MaxTotalSize, чтобы клиент узнал о проблеме до скачивания архива.
+func (m *Manager) checkBudget(task *Task) {
+	task.AdvertisedSize = 0
+	for i := range task.Files {
+		if task.Files[i].Status == http.StatusOK {
+			task.AdvertisedSize += task.Files[i].Size
+		}
+	}
+	task.OverBudget = m.cfg.MaxTotalSize > 0 && task.AdvertisedSize > m.cfg.MaxTotalSize
+}
+
+// Политики стоимости загрузки (config.Manager.SlotWeight)
+const (
+	SlotWeightNone  = "none"  // любая загрузка занимает один слот
+	SlotWeightFiles = "files" // слот на каждый загружаемый файл
+	SlotWeightSize  = "size"  // слот на каждые SlotSize байт заявленного размера файлов
+)
+
+// slotCost возвращает количество слотов, которое займёт загрузка файлов.
+// Стоимость не меньше 1 и не больше MaxActive, чтобы любая задача могла выполниться
+// хотя бы на свободном сервере. Без ограничения MaxActive стоимость не важна.
+func (m *Manager) slotCost(files []File) int {
+	cost := 1
+	if m.cfg.MaxActive < 0 {
+		return cost
+	}
+
+	switch m.cfg.SlotWeight {
+	case SlotWeightFiles:
+		cost = len(files)
+	case SlotWeightSize:
+		var size int64
+		for i := range files {
+			size += files[i].Size
+		}
+		if m.cfg.SlotSize > 0 {
+			cost = int(1 + size/m.cfg.SlotSize)
+		}
+	}
+
+	return max(1, min(cost, m.cfg.MaxActive))
+}
+
+func (m *Manager) getDownloadSlot(cost int) bool {
 	m.muActive.Lock()
 	defer m.muActive.Unlock()
 
-	if m.active < m.cfg.MaxActive {
-		m.active++
+	if m.cfg.MaxActive < 0 || m.active+cost <= m.cfg.MaxActive {
+		m.active += cost
+		metrics.ActiveSlots.Add(float64(cost))
 		return true
 	}
 
 	return false
 }
 
-func (m *Manager) freeDownloadSlot() {
+func (m *Manager) freeDownloadSlot(cost int) {
 	m.muActive.Lock()
 	defer m.muActive.Unlock()
-	m.active--
+	m.active -= cost
+	metrics.ActiveSlots.Sub(float64(cost))
 }
 
-func (m *Manager) ProcessTask(ctx context.Context, taskID int64, out io.Writer) error {
-	if !m.getDownloadSlot() {
-		return ErrServerBusy
+// ProcessTask собирает архив задачи в формате format (loader.FormatZip, loader.FormatTarGz;
+// "" - ZIP) и пишет его в out.
+//
+// Одновременные запросы архива одной задачи в одном формате объединяются: архив собирается один раз
+// (и занимает один слот), каждый запрос получает его потоком с начала (см. sharedBuild). Ошибка записи
+// в out или отмена ctx прекращают только этот запрос; сборка прекращается, когда не остаётся ни
+// одного запроса. onFile (если задан) получает окончательный результат каждого загружаемого файла
+// по мере загрузки (возможно, одновременно из нескольких горутин).
+func (m *Manager) ProcessTask(ctx context.Context, taskID int64, format string, out io.Writer, onFile func(File)) error {
+	// архив неизменившейся задачи отдаётся из кэша (ошибку чтения задачи вернёт сборка)
+	if m.archives != nil {
+		if files, err := m.stor.GetTaskFiles(taskID); err == nil {
+			if built, ok := m.archives.get(archiveCacheKey(taskID, format, files)); ok {
+				logger.FromContext(ctx).Debug("archive served from cache", "taskID", taskID, "size", len(built.data))
+				return built.writeTo(out, onFile)
+			}
+		}
 	}
-	defer m.freeDownloadSlot()
 
-	// ТОЛЬКО ДЛЯ ТЕСТОВ создаем задержку, чтобы можно было отследить активные задачи
-	if m.cfg.ProcessDelay > 0 {
-		logger.FromContext(ctx).Debug("process delay", "delay", m.cfg.ProcessDelay.String())
-		time.Sleep(m.cfg.ProcessDelay)
+	b, w, err := m.joinBuild(ctx, taskID, format, onFile)
+	if err != nil {
+		return err
+	}
+	defer b.leave(w)
+	return b.copyTo(ctx, out)
+}
+
+// joinBuild присоединяет запрос к идущей сборке архива или начинает новую.
+func (m *Manager) joinBuild(ctx context.Context, taskID int64, format string, onFile func(File)) (*sharedBuild, *buildWaiter, error) {
+	key := strconv.FormatInt(taskID, 10) + "/" + format
+
+	m.muBuilds.Lock()
+	defer m.muBuilds.Unlock()
+
+	if b := m.builds[key]; b != nil {
+		if w, ok := b.join(onFile); ok {
+			return b, w, nil
+		}
+		// сборка брошена всеми запросами и завершается: начинаем новую
 	}
 
-	files, err := m.stor.GetTaskFiles(taskID)
+	b, err := newSharedBuild(taskID)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("create build spool failed: %w", err)
 	}
+	w, _ := b.join(onFile)
 
-	// составляем список URLs для загрузки (еще не проверяли или OK на прошлой проверке)
-	urls := make([]string, 0, len(files))
-	ids := make([]int64, 0, len(files))
+	// сборка не зависит от запроса, который её начал (значения контекста, например логгер, сохраняются)
+	var buildCtx context.Context
+	buildCtx, b.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
+	m.builds[key] = b
+	go m.runBuild(buildCtx, key, b, taskID, format)
+	return b, w, nil
+}
 
-	// Запоминаем ID
+// runBuild собирает архив общей сборки и сохраняет его в кэше.
+func (m *Manager) runBuild(ctx context.Context, key string, b *sharedBuild, taskID int64, format string) {
+	defer b.cancel(nil)
+
+	files, cached, err := m.processTask(ctx, taskID, format, b, b.report)
+	if err == nil && cached != nil && m.archives.fits(b.size()) {
+		if data, readErr := b.readAll(); readErr == nil {
+			built := builtArchive{data: data, files: files}
+			m.archives.put(archiveCacheKey(taskID, format, cached.Files), taskID, cached.ExpiresAt, built)
+		}
+	}
+
+	// после завершения новые запросы собирают архив заново (или получают его из кэша)
+	m.muBuilds.Lock()
+	if m.builds[key] == b {
+		delete(m.builds, key)
+	}
+	m.muBuilds.Unlock()
+	b.finish(err)
+}
+
+// builtArchive - собранный архив и результаты загрузки его файлов (кэш архивов).
+type builtArchive struct {
+	data  []byte
+	files []File
+}
+
+// writeTo отдаёт собранный архив: сообщает результаты файлов и пишет архив в out.
+func (b builtArchive) writeTo(out io.Writer, onFile func(File)) error {
+	if onFile != nil {
+		for i := range b.files {
+			onFile(b.files[i])
+		}
+	}
+	_, err := out.Write(b.data)
+	return err
+}
+
+// Ответ на запрос архива задачи без файлов (config.Manager.EmptyTask)
+const (
+	EmptyTaskBuild         = "build"         // архив из одного status.json (MinFiles не проверяется)
+	EmptyTaskNotFound      = "not_found"     // ErrNoFiles (404)
+	EmptyTaskUnprocessable = "unprocessable" // ErrNotEnoughFiles (422)
+)
+
+// checkFileCount проверяет, что из файлов задачи можно собрать архив (EmptyTask, MinFiles).
+// MinFiles относится только к задачам с файлами: ответ для пустой задачи определяет EmptyTask.
+func (m *Manager) checkFileCount(files []File) error {
+	if len(files) == 0 {
+		switch m.cfg.EmptyTask {
+		case EmptyTaskNotFound:
+			return ErrNoFiles
+		case EmptyTaskUnprocessable:
+			return fmt.Errorf("%w: task has no files", ErrNotEnoughFiles)
+		}
+		// по умолчанию собирается архив из одного status.json
+		return nil
+	}
+
+	if len(files) < m.cfg.MinFiles {
+		return fmt.Errorf("%w: task has %d, minimum is %d", ErrNotEnoughFiles, len(files), m.cfg.MinFiles)
+	}
+	return nil
+}
+
+// processTask собирает архив задачи. Кроме результатов файлов возвращает задачу после сборки,
+// если архив можно сохранить в кэше (nil - кэш отключён или архив неполный).
+func (m *Manager) processTask(ctx context.Context, taskID int64, format string, out io.Writer, onFile func(File)) ([]File, *Task, error) {
+	out = deletedWriter{ctx: ctx, w: out}
+
+	files, err := m.stor.GetTaskFiles(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.checkFileCount(files); err != nil {
+		return nil, nil, err
+	}
+
+	// составляем список файлов для загрузки (еще не проверяли, OK или временная ошибка на прошлой
+	// проверке, или загрузка была прервана отменой)
+	toLoad := make([]File, 0, len(files))
 	for i := range files {
-		if s := files[i].Status; s == 0 || s == http.StatusOK {
-			urls = append(urls, files[i].URL)
-			ids = append(ids, files[i].ID)
+		if s := files[i].Status; s == 0 || s == http.StatusOK || files[i].Transient() || files[i].Interrupted {
+			toLoad = append(toLoad, files[i])
 		}
 	}
 
-	// загружаем
-	files, err = m.loader.Download(ctx, urls, out)
+	// одинаковые URL загрузчик загружает один раз
+	cost := m.slotCost(uniqueFiles(toLoad))
+	if !m.getDownloadSlot(cost) {
+		return nil, nil, ErrServerBusy
+	}
+	defer m.freeDownloadSlot(cost)
+
+	// время сборки ограничено, чтобы одна задача не занимала слот надолго: по истечении
+	// оставшиеся файлы получают статус 504, а архив завершается (LOADER_FINALIZE_ON_CANCEL)
+	if m.cfg.MaxBuildTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.cfg.MaxBuildTime)
+		defer cancel()
+	}
+
+	// ТОЛЬКО ДЛЯ ТЕСТОВ создаем задержку, чтобы можно было отследить активные задачи
+	if m.cfg.ProcessDelay > 0 {
+		logger.FromContext(ctx).Debug("process delay", "delay", m.cfg.ProcessDelay.String())
+		time.Sleep(m.cfg.ProcessDelay)
+	}
+
+	// Запоминаем ID
+	urls := make([]string, len(toLoad))
+	ids := make([]int64, len(toLoad))
+	for i := range toLoad {
+		urls[i] = toLoad[i].URL
+		ids[i] = toLoad[i].ID
+	}
+
+	// загружаем (у повторов URL общий результат загрузки, но свои записи архива)
+	files, err = m.loader.Download(ctx, urls, out, model.Archive{TaskID: taskID, Format: format, OnFile: onFile})
+	if cause := context.Cause(ctx); errors.Is(cause, errTaskDeleted) {
+		return nil, nil, cause
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Востанавливаем ID
@@ -160,8 +558,63 @@ func (m *Manager) ProcessTask(ctx context.Context, taskID int64, out io.Writer)
 		files[i].ID = id
 	}
 
-	// игнорируем возвращаемые значения (мы свою работу *по загрузке* сделали)
-	_, _ = m.stor.UpdateTaskFiles(taskID, files)
+	// ошибки игнорируем (мы свою работу *по загрузке* сделали)
+	task, err := m.stor.UpdateTaskFiles(taskID, files)
+	if err != nil {
+		return files, nil, nil
+	}
+	if task.CallbackURL != "" {
+		m.notifyArchiveBuilt(ctx, task)
+	}
 
-	return nil
+	// архив, прерванный отменой (например, по MANAGER_MAX_BUILD_TIME), не кэшируется
+	if m.archives == nil || slices.ContainsFunc(files, func(f File) bool { return f.Interrupted }) {
+		return files, nil, nil
+	}
+	return files, &task, nil
+}
+
+// uniqueFiles возвращает файлы без повторов URL (первые вхождения).
+func uniqueFiles(files []File) []File {
+	seen := make(map[string]bool, len(files))
+	unique := make([]File, 0, len(files))
+	for i := range files {
+		if !seen[files[i].URL] {
+			seen[files[i].URL] = true
+			unique = append(unique, files[i])
+		}
+	}
+	return unique
+}
+
+// notifyArchiveBuilt отправляет уведомление о первой сборке архива задачи.
+func (m *Manager) notifyArchiveBuilt(ctx context.Context, task Task) {
+	log := logger.FromContext(ctx).With("op", "notifyArchiveBuilt", "taskID", task.ID)
+
+	if m.notifier == nil {
+		return
+	}
+
+	first, err := m.stor.MarkTaskNotified(task.ID)
+	if err != nil || !first {
+		return
+	}
+
+	event := model.ArchiveEvent{
+		Event:   model.EventArchiveBuilt,
+		TaskID:  m.ids.Ref(task.ID),
+		Total:   len(task.Files),
+		BuiltAt: time.Now().UTC(),
+	}
+	for i := range task.Files {
+		if task.Files[i].Status == http.StatusOK {
+			event.Archived++
+			event.ArchivedBytes += task.Files[i].Size
+		} else {
+			event.Failed++
+		}
+	}
+
+	log.Debug("send webhook", "url", task.CallbackURL)
+	m.notifier.Notify(task.CallbackURL, event)
 }
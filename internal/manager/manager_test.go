@@ -0,0 +1,935 @@
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zipget/internal/config"
+	"zipget/internal/loader"
+	"zipget/internal/memstor"
+	"zipget/internal/metrics"
+	"zipget/internal/model"
+	"zipget/internal/protect"
+	"zipget/internal/webhook"
+
+	"github.com/nalgeon/be"
+)
+
+// fakeLoader возвращает заранее заданные результаты по URL.
+type fakeLoader struct {
+	files map[string]File
+}
+
+func (l *fakeLoader) Check(ctx context.Context, urls []string) ([]File, error) {
+	files := make([]File, len(urls))
+	for i, url := range urls {
+		files[i] = l.files[url]
+		files[i].URL = url
+	}
+	return files, nil
+}
+
+func (l *fakeLoader) Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]File, error) {
+	return l.Check(ctx, urls)
+}
+
+func (l *fakeLoader) Plan(files []File) model.Preview {
+	return model.Preview{}
+}
+
+func newTestManager(t *testing.T, cfg config.Manager, ldr Loader) (*Manager, *memstor.Memstor) {
+	t.Helper()
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+	t.Cleanup(stor.Cancel)
+	return New(cfg, stor, ldr, nil), stor
+}
+
+func TestGetTaskStatus_OverBudget(t *testing.T) {
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK, Size: 400},
+		"http://example.com/2.pdf": {Status: http.StatusOK, Size: 400},
+		"http://example.com/3.pdf": {Status: http.StatusOK, Size: 400},
+		"http://example.com/4.pdf": {Status: http.StatusNotFound, Size: 10000},
+	}}
+
+	tests := []struct {
+		name     string
+		budget   int64
+		urls     []string
+		wantSize int64
+		want     bool
+	}{
+		{"unlimited", 0, []string{"http://example.com/1.pdf", "http://example.com/2.pdf", "http://example.com/3.pdf"}, 1200, false},
+		{"exceeded", 1000, []string{"http://example.com/1.pdf", "http://example.com/2.pdf", "http://example.com/3.pdf"}, 1200, true},
+		{"within", 1200, []string{"http://example.com/1.pdf", "http://example.com/2.pdf", "http://example.com/3.pdf"}, 1200, false},
+		{"failed_not_counted", 1000, []string{"http://example.com/1.pdf", "http://example.com/4.pdf"}, 400, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m, _ := newTestManager(t, config.Manager{MaxTotalSize: tt.budget}, ldr)
+
+			taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+			be.Err(t, err, nil)
+			for _, url := range tt.urls {
+				be.Err(t, m.AddFileToTask(ctx, taskID, url), nil)
+			}
+
+			task, err := m.GetTaskStatus(ctx, taskID)
+			be.Err(t, err, nil)
+			be.Equal(t, task.OverBudget, tt.want)
+			be.Equal(t, task.AdvertisedSize, tt.wantSize)
+		})
+	}
+}
+
+func TestGetTaskStatus_Unchanged(t *testing.T) {
+	ctx := context.Background()
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK, Size: 100},
+	}}
+	m, _ := newTestManager(t, config.Manager{}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	first, err := m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+
+	// проверять нечего: задача не обновляется
+	second, err := m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, second.UpdatedAt, first.UpdatedAt)
+	be.Equal(t, second.Files, first.Files)
+}
+
+func TestGetTaskStatus_Recovered(t *testing.T) {
+	ctx := context.Background()
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusBadGateway, ErrorMsg: "connection refused"},
+	}}
+	m, _ := newTestManager(t, config.Manager{}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	task, err := m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, task.Files[0].Status, http.StatusBadGateway)
+	be.Equal(t, task.Files[0].ErrorMsg, "connection refused")
+
+	// источник восстановился: ошибка прошлой проверки не сохраняется
+	ldr.files["http://example.com/1.pdf"] = File{Status: http.StatusOK, ContentType: "application/pdf", Size: 100}
+	task, err = m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, task.Files[0], File{
+		URL:         "http://example.com/1.pdf",
+		Status:      http.StatusOK,
+		ContentType: "application/pdf",
+		Size:        100,
+	})
+}
+
+func TestGetTaskStatus_HostLimitNotFinal(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes:  []string{"application/pdf"},
+		HostConcurrency: 1,
+		HostLimitWait:   20 * time.Millisecond,
+	})
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	for _, path := range []string{"/a.pdf", "/b.pdf", "/c.pdf"} {
+		be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+path), nil)
+	}
+
+	// очередь к хосту не дождалась: часть файлов получает 429
+	task, err := m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	var limited int
+	for _, f := range task.Files {
+		if f.Status == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	be.True(t, limited > 0)
+
+	// хост освободился: 429 - не окончательный результат, файлы проверяются повторно
+	slow.Store(false)
+	task, err = m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	for _, f := range task.Files {
+		be.Equal(t, f.Status, http.StatusOK)
+	}
+
+	// и попадают в архив
+	var buf bytes.Buffer
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &buf, nil), nil)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	be.Err(t, err, nil)
+	be.Equal(t, len(zr.File), 4) // три файла и status.json
+}
+
+func TestGetTaskStatus_CircuitOpenNotFinal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad.pdf" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes:   []string{"application/pdf"},
+		BreakerThreshold: 1,
+		BreakerWindow:    time.Minute,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	// ошибка источника размыкает выключатель хоста
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/bad.pdf"), nil)
+	task, err := m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, task.Files[0].Status, http.StatusInternalServerError)
+
+	// пока выключатель разомкнут, файл хоста получает 503 без запроса к источнику
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/a.pdf"), nil)
+	task, err = m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, task.Files[1].Status, http.StatusServiceUnavailable)
+
+	// после паузы выключателя 503 не окончательный результат: файл проверяется повторно
+	time.Sleep(100 * time.Millisecond)
+	task, err = m.GetTaskStatus(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, task.Files[0].Status, http.StatusInternalServerError)
+	be.Equal(t, task.Files[1].Status, http.StatusOK)
+}
+
+func TestProcessTask_RetryInterrupted(t *testing.T) {
+	ctx := context.Background()
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK},
+	}}
+	m, stor := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	// прошлая сборка прервана по дедлайну
+	_, err = stor.UpdateTaskFiles(taskID, []File{{ID: 0, URL: "http://example.com/1.pdf", Status: http.StatusGatewayTimeout, Interrupted: true}})
+	be.Err(t, err, nil)
+
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+	files, err := stor.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.True(t, !files[0].Interrupted)
+}
+
+func TestProcessTask_MaxBuildTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes:   []string{"application/pdf"},
+		SignatureCheck:   loader.SignatureStrict,
+		FinalizeOnCancel: true,
+	})
+	m, stor := newTestManager(t, config.Manager{MaxActive: 1, MaxBuildTime: 100 * time.Millisecond}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/fast"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/slow"), nil)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &buf, nil), nil)
+	be.True(t, time.Since(start) < time.Second)
+
+	// архив завершён: загруженный файл и отчёт
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	be.Err(t, err, nil)
+	be.Equal(t, len(zr.File), 2)
+
+	files, err := stor.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, files[0].Status, http.StatusOK)
+	be.Equal(t, files[1].Status, http.StatusGatewayTimeout)
+	be.True(t, files[1].Interrupted)
+
+	// слот освобождён
+	be.True(t, m.getDownloadSlot(1))
+	m.freeDownloadSlot(1)
+}
+
+// blockingLoader пишет начало архива и ждёт отмены загрузки, после чего пытается завершить архив.
+type cancelLoader struct {
+	fakeLoader
+	started chan struct{}
+}
+
+func (l *cancelLoader) Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]File, error) {
+	if _, err := out.Write([]byte("head")); err != nil {
+		return nil, err
+	}
+	close(l.started)
+	<-ctx.Done()
+	if _, err := out.Write([]byte("tail")); err != nil {
+		return nil, err
+	}
+	return make([]File, len(urls)), nil
+}
+
+func TestProcessTask_DuplicateURLs(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes: []string{"application/pdf"},
+		SignatureCheck: loader.SignatureStrict,
+	})
+	m, stor := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	for _, path := range []string{"/a.pdf", "/b.pdf", "/a.pdf", "/c.pdf", "/a.pdf"} {
+		be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+path), nil)
+	}
+
+	var buf bytes.Buffer
+	var reported atomic.Int32
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &buf, func(File) { reported.Add(1) }), nil)
+
+	// каждый URL загружен один раз, о каждом файле задачи сообщено
+	be.Equal(t, requests, map[string]int{"/a.pdf": 1, "/b.pdf": 1, "/c.pdf": 1})
+	be.Equal(t, reported.Load(), int32(5))
+
+	// у каждого файла задачи своя запись (номер - позиция в задаче), повторы - после остальных
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	be.Err(t, err, nil)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	be.Equal(t, names, []string{"unnamed-1.pdf", "unnamed-2.pdf", "unnamed-4.pdf", "unnamed-3.pdf", "unnamed-5.pdf", "status.json"})
+
+	// повторы получили результат первого файла со своим ID и своим именем
+	files, err := stor.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, len(files), 5)
+	for i, want := range []string{"unnamed-1.pdf", "unnamed-2.pdf", "unnamed-3.pdf", "unnamed-4.pdf", "unnamed-5.pdf"} {
+		be.Equal(t, files[i].ID, int64(i))
+		be.Equal(t, files[i].Status, http.StatusOK)
+		be.Equal(t, files[i].Name, want)
+	}
+	be.Equal(t, files[2].Size, files[0].Size)
+}
+
+func TestProcessTask_DeleteCancels(t *testing.T) {
+	ctx := context.Background()
+	ldr := &cancelLoader{started: make(chan struct{})}
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- m.ProcessTask(ctx, taskID, "", &buf, nil) }()
+
+	<-ldr.started
+	be.Err(t, m.DeleteTask(ctx, taskID), nil)
+
+	select {
+	case err := <-done:
+		be.Err(t, err, ErrTaskNotFound)
+	case <-time.After(time.Second):
+		t.Fatal("download was not cancelled by delete")
+	}
+	be.Equal(t, buf.String(), "head") // архив удалённой задачи не дописывается
+	be.Equal(t, m.active, 0)
+	be.Equal(t, len(m.builds), 0)
+}
+
+// brokenWriter - клиент, отключившийся до получения архива.
+type brokenWriter struct{}
+
+var errClientGone = errors.New("client gone")
+
+func (brokenWriter) Write(p []byte) (int, error) { return 0, errClientGone }
+
+func TestProcessTask_ClientWriteError(t *testing.T) {
+	// источник отдаёт большой файл медленно, пока его читают
+	var sent atomic.Int64
+	handlerDone := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4\n"))
+		// случайные данные больше окна deflate: архив растёт вместе с загруженным
+		chunk := make([]byte, 64<<10)
+		rand.Read(chunk)
+		for range 1 << 10 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			sent.Add(int64(len(chunk)))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{
+		AllowMIMETypes: []string{"application/pdf"},
+		SignatureCheck: loader.SignatureStrict,
+	})
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/big.pdf"), nil)
+
+	be.Err(t, m.ProcessTask(ctx, taskID, "", brokenWriter{}, nil), errClientGone)
+
+	// единственный клиент ушёл: загрузка прекращена, слот освобождён
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download continues after the client is gone")
+	}
+	be.True(t, sent.Load() < 8<<20)
+	var builds int
+	for range 100 {
+		m.muBuilds.Lock()
+		builds = len(m.builds)
+		m.muBuilds.Unlock()
+		if builds == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	be.Equal(t, builds, 0)
+}
+
+func TestProcessTask_DeleteRace(t *testing.T) {
+	ctx := context.Background()
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK},
+	}}
+	m, _ := newTestManager(t, config.Manager{MaxActive: -1}, ldr)
+
+	for range 100 {
+		taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+		be.Err(t, err, nil)
+		be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+		// удаление приходит одновременно с началом сборки: сборка либо успевает,
+		// либо прерывается, либо не находит задачу
+		var wg sync.WaitGroup
+		var processErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			processErr = m.ProcessTask(ctx, taskID, "", io.Discard, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			be.Err(t, m.DeleteTask(ctx, taskID), nil)
+		}()
+		wg.Wait()
+
+		if processErr != nil {
+			be.Err(t, processErr, ErrTaskNotFound)
+		}
+	}
+	be.Equal(t, m.active, 0)
+	be.Equal(t, len(m.builds), 0)
+}
+
+// scrapeMetrics получает метрики с обработчика /metrics (строки "имя{метки} значение").
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	be.Equal(t, rec.Code, http.StatusOK)
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		be.Err(t, err, nil)
+		values[name] = v
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b.txt" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("plain text"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{AllowMIMETypes: []string{"application/pdf"}})
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	before := scrapeMetrics(t)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/a.pdf"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/b.txt"), nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+
+	otherID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.DeleteTask(ctx, otherID), nil)
+
+	_, err = protect.ReplaceHostToIP("127.0.0.1:80", protect.FamilyAny)
+	be.Err(t, err, protect.ErrSSRF)
+
+	after := scrapeMetrics(t)
+	delta := func(name string) float64 { return after[name] - before[name] }
+	be.Equal(t, delta("zipget_tasks_created_total"), 2.0)
+	be.Equal(t, delta("zipget_tasks_deleted_total"), 1.0)
+	be.Equal(t, delta(`zipget_downloads_total{status="200"}`), 1.0)
+	be.Equal(t, delta(`zipget_downloads_total{status="403"}`), 1.0)
+	be.Equal(t, delta("zipget_downloaded_bytes_total"), 8.0)
+	be.Equal(t, delta("zipget_ssrf_blocked_total"), 1.0)
+	be.Equal(t, after["zipget_download_slots_active"], before["zipget_download_slots_active"]) // слот освобождён
+}
+
+func TestPrepareTask(t *testing.T) {
+	var heads, gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		} else {
+			gets.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+		w.Header().Set("Content-Length", "1000")
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ldr := loader.New(http.DefaultClient, config.Loader{AllowMIMETypes: []string{"application/pdf"}})
+	m, stor := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	// единственный слот занят - prepare слоты не занимает
+	be.True(t, m.getDownloadSlot(1))
+	defer m.freeDownloadSlot(1)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/a"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "not a url"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/b"), nil)
+
+	task, err := m.PrepareTask(ctx, taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, gets.Load(), int32(0))
+	be.Equal(t, heads.Load(), int32(2))
+
+	be.True(t, task.Preview != nil)
+	be.Equal(t, task.Preview.Entries, []string{"report-1.pdf", "report-2.pdf"})
+	be.True(t, task.Preview.Size > 2000)
+	be.True(t, !task.Preview.PreparedAt.IsZero())
+
+	// оценка сохранена в хранилище и сбрасывается при добавлении файла
+	task, err = stor.UpdateTaskFiles(taskID, nil)
+	be.Err(t, err, nil)
+	be.True(t, task.Preview != nil)
+
+	be.Err(t, m.AddFileToTask(ctx, taskID, srv.URL+"/c"), nil)
+	task, err = stor.UpdateTaskFiles(taskID, nil)
+	be.Err(t, err, nil)
+	be.True(t, task.Preview == nil)
+}
+
+func TestAddFileToTask_Normalize(t *testing.T) {
+	ctx := context.Background()
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute, DedupURLs: memstor.DedupReject})
+	t.Cleanup(stor.Cancel)
+	m := New(config.Manager{NormalizeURLs: true}, stor, &fakeLoader{}, nil)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://Example.com:80/x"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/x#frag"), ErrDuplicateURL)
+
+	files, err := stor.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, len(files), 1)
+	be.Equal(t, files[0].URL, "http://example.com/x")
+}
+
+func TestSlotCost(t *testing.T) {
+	files := []File{{Size: 150}, {Size: 50}, {Size: 0}, {Size: 300}}
+
+	tests := []struct {
+		policy string
+		files  []File
+		want   int
+	}{
+		{SlotWeightNone, files, 1},
+		{SlotWeightFiles, files[:2], 2},
+		{SlotWeightFiles, files, 3}, // не больше MaxActive
+		{SlotWeightFiles, nil, 1},   // не меньше 1
+		{SlotWeightSize, files[:1], 2},
+		{SlotWeightSize, files[1:3], 1},
+		{SlotWeightSize, files, 3},
+	}
+
+	for _, tt := range tests {
+		m := New(config.Manager{MaxActive: 3, SlotWeight: tt.policy, SlotSize: 100}, nil, nil, nil)
+		be.Equal(t, m.slotCost(tt.files), tt.want)
+	}
+}
+
+func TestGetDownloadSlot_MaxActive(t *testing.T) {
+	tests := []struct {
+		maxActive int
+		admitted  int // сколько загрузок допускается одновременно (из 5)
+	}{
+		{-1, 5},
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.maxActive), func(t *testing.T) {
+			m, _ := newTestManager(t, config.Manager{MaxActive: tt.maxActive}, &fakeLoader{})
+
+			admitted := 0
+			for range 5 {
+				if m.getDownloadSlot(m.slotCost(nil)) {
+					admitted++
+				}
+			}
+			be.Equal(t, admitted, tt.admitted)
+		})
+	}
+}
+
+func TestProcessTask_WeightedAdmission(t *testing.T) {
+	ldr := &fakeLoader{}
+	tests := []struct {
+		policy string
+		want   error
+	}{
+		{SlotWeightNone, nil},
+		{SlotWeightFiles, ErrServerBusy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+			m, _ := newTestManager(t, config.Manager{MaxActive: 3, SlotWeight: tt.policy}, ldr)
+
+			// большая задача уже загружается и занимает 2 слота из 3 (при взвешивании по файлам)
+			big := m.slotCost(make([]File, 2))
+			be.True(t, m.getDownloadSlot(big))
+			defer m.freeDownloadSlot(big)
+
+			// задача из двух файлов не помещается в оставшийся слот
+			taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+			be.Err(t, err, nil)
+			be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+			be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/2.pdf"), nil)
+			be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), tt.want)
+
+			// задача из одного файла помещается
+			taskID, err = m.CreateTask(ctx, model.TaskOptions{})
+			be.Err(t, err, nil)
+			be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/3.pdf"), nil)
+			be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+		})
+	}
+}
+
+func TestProcessTask_MinFiles(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1, MinFiles: 2}, &fakeLoader{})
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	// пустая задача собирается (EmptyTaskBuild), задача с файлом меньше минимума - нет
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), ErrNotEnoughFiles)
+
+	// минимум достигнут
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/2.pdf"), nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+}
+
+// blockingLoader пишет в архив data после освобождения release и считает сборки.
+type blockingLoader struct {
+	fakeLoader
+	data    string
+	started chan struct{}
+	release chan struct{}
+	builds  atomic.Int32
+}
+
+func (l *blockingLoader) Download(ctx context.Context, urls []string, out io.Writer, arch model.Archive) ([]File, error) {
+	l.builds.Add(1)
+	l.started <- struct{}{}
+	<-l.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	io.WriteString(out, l.data)
+	files, err := l.Check(ctx, urls)
+	for i := range files {
+		if arch.OnFile != nil {
+			arch.OnFile(files[i])
+		}
+	}
+	return files, err
+}
+
+func TestProcessTask_Coalesced(t *testing.T) {
+	ctx := context.Background()
+	ldr := &blockingLoader{
+		data:    "archive data",
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	var outs [2]strings.Builder
+	var errs [2]error
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[0] = m.ProcessTask(ctx, taskID, "", &outs[0], nil)
+	}()
+	<-ldr.started
+
+	// второй запрос присоединяется к идущей сборке (отдельного слота для него нет: MaxActive=1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[1] = m.ProcessTask(ctx, taskID, "", &outs[1], nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	close(ldr.release)
+	wg.Wait()
+
+	be.Equal(t, ldr.builds.Load(), int32(1))
+	for i := range outs {
+		be.Err(t, errs[i], nil)
+		be.Equal(t, outs[i].String(), ldr.data)
+	}
+
+	// после завершения сборки следующий запрос собирает архив заново
+	var out strings.Builder
+	be.Err(t, m.ProcessTask(ctx, taskID, "", &out, nil), nil)
+	be.Equal(t, ldr.builds.Load(), int32(2))
+}
+
+func TestProcessTask_CoalescedFirstLeaves(t *testing.T) {
+	ctx := context.Background()
+	ldr := &blockingLoader{
+		data:    "archive data",
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	m, _ := newTestManager(t, config.Manager{MaxActive: 1}, ldr)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+
+	// первый запрос начинает сборку и уходит (клиент отключился)
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() { first <- m.ProcessTask(firstCtx, taskID, "", io.Discard, nil) }()
+	<-ldr.started
+
+	var out strings.Builder
+	var reported atomic.Int32
+	second := make(chan error, 1)
+	go func() { second <- m.ProcessTask(ctx, taskID, "", &out, func(File) { reported.Add(1) }) }()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	be.Err(t, <-first, context.Canceled)
+
+	// сборка продолжается для оставшегося запроса
+	close(ldr.release)
+	be.Err(t, <-second, nil)
+	be.Equal(t, out.String(), ldr.data)
+	be.Equal(t, reported.Load(), int32(1))
+	be.Equal(t, ldr.builds.Load(), int32(1))
+}
+
+func TestProcessTask_Webhook(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var attempts int
+	var events []model.ArchiveEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError) // первая попытка неудачна
+			return
+		}
+		var event model.ArchiveEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		events = append(events, event)
+	}))
+	defer receiver.Close()
+
+	ldr := &fakeLoader{files: map[string]File{
+		"http://example.com/1.pdf": {Status: http.StatusOK, Size: 100},
+		"http://example.com/2.pdf": {Status: http.StatusOK, Size: 200},
+		"http://example.com/3.pdf": {Status: http.StatusNotFound},
+	}}
+	ntf := webhook.New(http.DefaultClient, 2, 10*time.Millisecond, 0)
+	stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+	t.Cleanup(stor.Cancel)
+	m := New(config.Manager{MaxActive: 1, Webhooks: true}, stor, ldr, ntf)
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{CallbackURL: receiver.URL})
+	be.Err(t, err, nil)
+	for url := range ldr.files {
+		be.Err(t, m.AddFileToTask(ctx, taskID, url), nil)
+	}
+
+	// уведомление отправляется только при первой сборке
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+	be.Err(t, m.ProcessTask(ctx, taskID, "", io.Discard, nil), nil)
+	ntf.Wait()
+
+	be.Equal(t, attempts, 2)
+	be.Equal(t, len(events), 1)
+	event := events[0]
+	be.Equal(t, event.Event, model.EventArchiveBuilt)
+	be.Equal(t, event.TaskID.String(), strconv.FormatInt(taskID, 10))
+	be.Equal(t, event.Total, 3)
+	be.Equal(t, event.Archived, 2)
+	be.Equal(t, event.Failed, 1)
+	be.Equal(t, event.ArchivedBytes, int64(300))
+}
+
+func TestCreateTask_Callback(t *testing.T) {
+	ctx := context.Background()
+	ntf := webhook.New(http.DefaultClient, 0, 0, 0)
+
+	tests := []struct {
+		name     string
+		webhooks bool
+		url      string
+		want     error
+	}{
+		{"disabled", false, "http://example.com/hook", ErrInvalidCallback},
+		{"relative", true, "/hook", ErrInvalidCallback},
+		{"scheme", true, "ftp://example.com/hook", ErrInvalidCallback},
+		{"valid", true, "https://example.com/hook", nil},
+		{"none", false, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stor := memstor.New(memstor.Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Minute})
+			t.Cleanup(stor.Cancel)
+			m := New(config.Manager{Webhooks: tt.webhooks}, stor, &fakeLoader{}, ntf)
+
+			_, err := m.CreateTask(ctx, model.TaskOptions{CallbackURL: tt.url})
+			be.Err(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package memstor
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryItem - момент устаревания задачи.
+type expiryItem struct {
+	at time.Time
+	id int64
+}
+
+// expiryQueue - очередь с приоритетом (min-heap) моментов устаревания задач.
+//
+// Удаление ленивое: элементы удалённых задач и задач с изменённым ExpiresAt остаются в очереди
+// и пропускаются при извлечении, поэтому каждое присваивание ExpiresAt должно сопровождаться push.
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiryItem)) }
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// push добавляет момент устаревания задачи и сообщает, стал ли он ближайшим.
+func (q *expiryQueue) push(id int64, at time.Time) bool {
+	heap.Push(q, expiryItem{at: at, id: id})
+	return (*q)[0].id == id && (*q)[0].at.Equal(at)
+}
+
+// next возвращает ближайший момент устаревания (false - очередь пуста).
+func (q expiryQueue) next() (time.Time, bool) {
+	if len(q) == 0 {
+		return time.Time{}, false
+	}
+	return q[0].at, true
+}
+
+// popExpired извлекает ближайший элемент, если он устарел к моменту now.
+func (q *expiryQueue) popExpired(now time.Time) (expiryItem, bool) {
+	if len(*q) == 0 || (*q)[0].at.After(now) {
+		return expiryItem{}, false
+	}
+	return heap.Pop(q).(expiryItem), true
+}
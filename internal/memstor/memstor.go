@@ -1,7 +1,9 @@
 package memstor
 
 import (
+	"cmp"
 	"context"
+	"log/slog"
 	"math/rand/v2"
 	"slices"
 	"sync"
@@ -19,37 +21,71 @@ type (
 	File = model.File
 )
 
+// Режимы обработки повторного добавления URL в задачу
+const (
+	DedupAllow  = "allow"  // дубликаты разрешены (по умолчанию)
+	DedupReject = "reject" // повторное добавление возвращает ErrDuplicateURL
+	DedupIgnore = "ignore" // повторное добавление молча игнорируется
+)
+
 type Config struct {
-	MaxTotal int
-	MaxFiles int
-	TaskTTL  time.Duration
+	MaxTotal  int
+	MaxFiles  int
+	TaskTTL   time.Duration
+	DedupURLs string // DedupAllow (или пусто), DedupReject, DedupIgnore
+
+	CleanInterval time.Duration // максимальный интервал между очистками устаревших задач (0 - 1 минута)
+	Metrics       bool          // сообщать метрики хранилища после каждой очистки
 }
 
 var (
 	ErrTaskNotFound     = model.ErrTaskNotFound
 	ErrMaxFilesExceeded = model.ErrMaxFilesExceeded
+	ErrDuplicateURL     = model.ErrDuplicateURL
 	ErrServerBusy       = model.ErrServerBusy
 	ErrServerCancelled  = model.ErrServerCancelled
 )
 
 type Memstor struct {
-	cfg       Config
-	mu        sync.RWMutex
-	tasks     map[int64]*model.Task
-	cancel    context.CancelFunc
-	cancelled bool
+	cfg         Config
+	mu          sync.RWMutex
+	tasks       map[int64]*model.Task
+	expiry      expiryQueue   // моменты устаревания задач для очистки
+	wake        chan struct{} // будит чистильщик, если ближайший момент устаревания изменился
+	cancel      context.CancelFunc
+	cleanerDone chan struct{} // закрывается при завершении чистильщика
+	cancelled   bool
+
+	beforeClean func()      // ТОЛЬКО ДЛЯ ТЕСТОВ: вызывается перед каждой очисткой
+	onMetrics   func(Stats) // ТОЛЬКО ДЛЯ ТЕСТОВ: получает метрики вместо записи в лог
 }
 
 func New(cfg Config) *Memstor {
-	m := &Memstor{
+	m := newMemstor(cfg)
+	m.startTaskCleaner()
+	return m
+}
+
+func newMemstor(cfg Config) *Memstor {
+	return &Memstor{
 		cfg:   cfg,
 		tasks: make(map[int64]*model.Task),
+		wake:  make(chan struct{}, 1),
 	}
-	m.startTaskCleaner()
-	return m
 }
 
-func (m *Memstor) CreateTask(ctx context.Context) (int64, error) {
+// scheduleExpiry ставит задачу в очередь на удаление по ExpiresAt. Вызывается под m.mu
+// при каждом присваивании ExpiresAt.
+func (m *Memstor) scheduleExpiry(task *Task) {
+	if m.expiry.push(task.ID, task.ExpiresAt) {
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *Memstor) CreateTask(ctx context.Context, opts model.TaskOptions) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -62,12 +98,17 @@ func (m *Memstor) CreateTask(ctx context.Context) (int64, error) {
 	}
 
 	id := rand.Int64()
-	m.tasks[id] = &model.Task{
+	task := &model.Task{
 		ID:        id,
 		Files:     make([]model.File, 0),
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(m.cfg.TaskTTL),
+		Name:      opts.Name,
+
+		CallbackURL: opts.CallbackURL,
 	}
+	m.tasks[id] = task
+	m.scheduleExpiry(task)
 
 	return id, nil
 }
@@ -81,6 +122,7 @@ func (m *Memstor) DeleteTask(ctx context.Context, taskID int64) error {
 	}
 
 	// не проверяем наличие задачи для обеспечения идемпотентности
+	// (из очереди устаревания задача удаляется лениво, при очистке)
 	delete(m.tasks, taskID)
 	return nil
 }
@@ -98,15 +140,45 @@ func (m *Memstor) AddFileToTask(ctx context.Context, taskID int64, url string) e
 		return ErrTaskNotFound
 	}
 
+	if m.cfg.DedupURLs == DedupReject || m.cfg.DedupURLs == DedupIgnore {
+		for i := range task.Files {
+			if task.Files[i].URL != url {
+				continue
+			}
+			if m.cfg.DedupURLs == DedupReject {
+				return ErrDuplicateURL
+			}
+			return nil
+		}
+	}
+
 	if m.cfg.MaxFiles >= 0 && len(task.Files) >= m.cfg.MaxFiles { // если m.cfg.MaxFiles < 0, то неограничено, если 0 - запрешено
 		return ErrMaxFilesExceeded
 	}
-	
+
 	idx := int64(len(task.Files))
 	task.Files = append(task.Files, File{ID: idx, URL: url})
+	task.Preview = nil // состав задачи изменился, оценка архива устарела
 	return nil
 }
 
+// GetTask возвращает сохранённое состояние задачи.
+func (m *Memstor) GetTask(taskID int64) (Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cancelled {
+		return Task{}, ErrServerCancelled
+	}
+
+	task, exists := m.tasks[taskID]
+	if !exists {
+		return Task{}, ErrTaskNotFound
+	}
+
+	return task.Clone(), nil
+}
+
 func (m *Memstor) GetTaskFiles(taskID int64) ([]File, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -136,70 +208,313 @@ func (m *Memstor) UpdateTaskFiles(taskID int64, files []File) (Task, error) {
 		return Task{}, ErrTaskNotFound
 	}
 
-	if len(files) > 0 {
-		for i := range files {
-			idx := files[i].ID
+	// время изменения обновляется, только если запись файла действительно изменилась
+	// (повторная проверка с тем же результатом задачу не меняет)
+	var changed bool
+	for i := range files {
+		idx := fileIndex(task.Files, files[i].ID, files[i].URL)
+		if idx == -1 {
+			// файл исчез или задача заменена (ImportTasks), пока выполнялась проверка или загрузка:
+			// не затираем чужую запись
+			slog.Warn("update of missing file skipped", "taskID", taskID, "fileID", files[i].ID, "url", files[i].URL)
+			continue
+		}
+		if task.Files[idx] != files[i] {
 			task.Files[idx] = files[i]
+			changed = true
 		}
+	}
+	if changed {
 		task.UpdatedAt = time.Now()
 	}
 
 	return task.Clone(), nil
 }
 
-func (m *Memstor) cleanExpiredTasks() {
-	// FIXME: для перформанса нужно использовать PriorityQueue по ExpiresAt
+// fileIndex возвращает индекс файла с заданными ID и URL или -1.
+// Обычно ID совпадает с индексом, но это не гарантируется, поэтому ID сверяется. URL сверяется,
+// потому что ID назначаются по порядку заново (ImportTasks) и могут достаться другому файлу.
+func fileIndex(files []File, id int64, url string) int {
+	if id >= 0 && id < int64(len(files)) && files[id].ID == id {
+		if files[id].URL == url {
+			return int(id)
+		}
+		return -1
+	}
+	for i := range files {
+		if files[i].ID == id && files[i].URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// MarkTaskNotified отмечает отправку уведомления о сборке архива.
+// Возвращает true, только если отметка сделана этим вызовом (уведомление ещё не отправлялось).
+func (m *Memstor) MarkTaskNotified(taskID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelled {
+		return false, ErrServerCancelled
+	}
+
+	task, exists := m.tasks[taskID]
+	if !exists {
+		return false, ErrTaskNotFound
+	}
+
+	if !task.NotifiedAt.IsZero() {
+		return false, nil
+	}
+	task.NotifiedAt = time.Now()
+	return true, nil
+}
+
+func (m *Memstor) SetTaskPreview(taskID int64, preview model.Preview) (Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelled {
+		return Task{}, ErrServerCancelled
+	}
+
+	task, exists := m.tasks[taskID]
+	if !exists {
+		return Task{}, ErrTaskNotFound
+	}
+
+	task.Preview = preview.Clone()
+	return task.Clone(), nil
+}
+
+// FindTasks возвращает страницу задач, подходящих под фильтр, в порядке убывания времени создания,
+// и общее количество подходящих задач.
+func (m *Memstor) FindTasks(ctx context.Context, filter model.TaskFilter, limit, offset int) ([]Task, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cancelled {
+		return nil, 0, ErrServerCancelled
+	}
+
+	matched := make([]*Task, 0)
+	for _, task := range m.tasks {
+		if filter.Match(task) {
+			matched = append(matched, task)
+		}
+	}
+
+	slices.SortFunc(matched, func(a, b *Task) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	total := len(matched)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit >= 0 {
+		end = min(offset+limit, total)
+	}
+
+	tasks := make([]Task, 0, end-offset)
+	for _, task := range matched[offset:end] {
+		tasks = append(tasks, task.Clone())
+	}
+
+	return tasks, total, nil
+}
+
+// ExportTasks возвращает копии всех задач в порядке возрастания времени создания
+// (для резервного копирования и переноса на другой экземпляр).
+func (m *Memstor) ExportTasks(ctx context.Context) ([]Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	var expiredTasks []int64
-	func() {
-		m.mu.RLock()
-		defer m.mu.RUnlock()
+	if m.cancelled {
+		return nil, ErrServerCancelled
+	}
+
+	tasks := make([]Task, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		tasks = append(tasks, task.Clone())
+	}
+
+	slices.SortFunc(tasks, func(a, b Task) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return tasks, nil
+}
+
+// ImportTasks восстанавливает задачи, полученные из ExportTasks, и возвращает количество
+// восстановленных задач. Задача с уже существующим ID заменяется, устаревшие задачи пропускаются,
+// задаче без ID присваивается новый. ID файлов назначаются заново по порядку (в экспорт не попадают).
+// Если восстановленных задач не хватает места (MaxTotal), ничего не импортируется и возвращается ErrServerBusy.
+func (m *Memstor) ImportTasks(ctx context.Context, tasks []Task) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelled {
+		return 0, ErrServerCancelled
+	}
 
-		now := time.Now()
-		for _, task := range m.tasks {
-			if task.ExpiresAt.Before(now) {
-				expiredTasks = append(expiredTasks, task.ID)
+	now := time.Now()
+	added := 0
+	for i := range tasks {
+		if !tasks[i].ExpiresAt.After(now) {
+			continue
+		}
+		if _, exists := m.tasks[tasks[i].ID]; !exists || tasks[i].ID == 0 {
+			added++
+		}
+	}
+	if m.cfg.MaxTotal >= 0 && len(m.tasks)+added > m.cfg.MaxTotal {
+		return 0, ErrServerBusy
+	}
+
+	n := 0
+	for i := range tasks {
+		if !tasks[i].ExpiresAt.After(now) {
+			continue
+		}
+		task := tasks[i].Clone()
+		for task.ID == 0 {
+			if id := rand.Int64(); m.tasks[id] == nil {
+				task.ID = id
 			}
 		}
-	}()
+		if task.Files == nil {
+			task.Files = make([]File, 0)
+		}
+		for j := range task.Files {
+			task.Files[j].ID = int64(j)
+		}
+		task.AdvertisedSize, task.OverBudget = 0, false // вычисляемые поля не хранятся
+		m.tasks[task.ID] = &task
+		m.scheduleExpiry(&task)
+		n++
+	}
+
+	return n, nil
+}
+
+// CleanExpiredTasks немедленно (не дожидаясь очистки по таймеру) удаляет устаревшие задачи
+// и возвращает их количество.
+func (m *Memstor) CleanExpiredTasks(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelled {
+		return 0, ErrServerCancelled
+	}
+
+	return m.removeExpired(), nil
+}
 
-	if len(expiredTasks) > 0 {
-		m.mu.Lock()
-		defer m.mu.Unlock()
+// cleanExpiredTasks удаляет устаревшие задачи по таймеру и возвращает их количество.
+func (m *Memstor) cleanExpiredTasks() int {
+	if m.beforeClean != nil {
+		m.beforeClean()
+	}
 
-		for _, taskID := range expiredTasks {
-			delete(m.tasks, taskID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.removeExpired()
+}
+
+// removeExpired удаляет устаревшие задачи под m.mu и возвращает их количество.
+// Просматриваются только устаревшие элементы очереди, а не все задачи.
+func (m *Memstor) removeExpired() int {
+	removed := 0
+	now := time.Now()
+	for {
+		item, ok := m.expiry.popExpired(now)
+		if !ok {
+			break
+		}
+		// элемент устарел лениво: задача удалена или её ExpiresAt изменился (есть другой элемент)
+		task, exists := m.tasks[item.id]
+		if !exists || task.ExpiresAt.After(now) {
+			continue
 		}
+		delete(m.tasks, item.id)
+		removed++
+	}
+	return removed
+}
+
+// nextClean возвращает время до следующей очистки: до ближайшего устаревания задачи,
+// но не больше интервала очистки (чтобы метрики сообщались регулярно).
+func (m *Memstor) nextClean() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d := m.cleanInterval()
+	if at, ok := m.expiry.next(); ok {
+		d = min(d, max(time.Until(at), 0))
 	}
+	return d
 }
 
 func (m *Memstor) startTaskCleaner() {
 	ctx, cancel := context.WithCancel(context.Background())
 	m.cancel = cancel
+	m.cleanerDone = make(chan struct{})
 
 	go func() {
-		tm := time.NewTimer(cleanTimeout)
+		defer close(m.cleanerDone)
+
+		tm := time.NewTimer(m.nextClean())
 		defer tm.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-m.wake:
+				// ближайший момент устаревания стал раньше: переводим таймер
+				tm.Reset(m.nextClean())
 			case <-tm.C:
-				m.cleanExpiredTasks()
-				tm.Reset(cleanTimeout)
+				removed := m.cleanExpiredTasks()
+				if m.cfg.Metrics {
+					m.emitMetrics(removed)
+				}
+				tm.Reset(m.nextClean())
 			}
 		}
 	}()
 }
 
+func (m *Memstor) cleanInterval() time.Duration {
+	if m.cfg.CleanInterval > 0 {
+		return m.cfg.CleanInterval
+	}
+	return cleanTimeout
+}
+
+// Cancel останавливает хранилище: дожидается завершения текущей очистки,
+// останавливает чистильщик и удаляет все задачи. Повторный вызов ничего не делает.
+//
+// NOTE: хранилище в памяти ничего не сохраняет; персистентной реализации здесь
+// следует сбросить несохранённое состояние, а не удалять его.
 func (m *Memstor) Cancel() {
+	// Ждём чистильщик без блокировки: текущая очистка может ожидать m.mu
+	m.cancel()
+	<-m.cleanerDone
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if !m.cancelled {
-		m.cancel()
 		clear(m.tasks)
+		m.expiry = nil
 		m.cancelled = true
 	}
 }
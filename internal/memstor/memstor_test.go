@@ -0,0 +1,474 @@
+package memstor
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"zipget/internal/model"
+
+	"github.com/nalgeon/be"
+)
+
+func newTestMemstor(t *testing.T, cfg Config) *Memstor {
+	t.Helper()
+	if cfg.TaskTTL == 0 {
+		cfg.TaskTTL = time.Minute
+	}
+	m := New(cfg)
+	t.Cleanup(m.Cancel)
+	return m
+}
+
+func TestAddFileToTask_Dedup(t *testing.T) {
+	const url = "http://example.com/file.pdf"
+
+	tests := []struct {
+		mode      string
+		wantErr   error
+		wantFiles int
+	}{
+		{mode: "", wantErr: nil, wantFiles: 2},
+		{mode: DedupAllow, wantErr: nil, wantFiles: 2},
+		{mode: DedupReject, wantErr: ErrDuplicateURL, wantFiles: 1},
+		{mode: DedupIgnore, wantErr: nil, wantFiles: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode_"+tt.mode, func(t *testing.T) {
+			ctx := context.Background()
+			m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1, DedupURLs: tt.mode})
+
+			taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+			be.Err(t, err, nil)
+
+			be.Err(t, m.AddFileToTask(ctx, taskID, url), nil)
+			be.Err(t, m.AddFileToTask(ctx, taskID, url), tt.wantErr)
+
+			files, err := m.GetTaskFiles(taskID)
+			be.Err(t, err, nil)
+			be.Equal(t, len(files), tt.wantFiles)
+		})
+	}
+}
+
+func TestAddFileToTask_DedupFullTask(t *testing.T) {
+	const url = "http://example.com/file.pdf"
+	ctx := context.Background()
+	m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: 1, DedupURLs: DedupIgnore})
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	be.Err(t, m.AddFileToTask(ctx, taskID, url), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, url), nil) // игнорируется, хотя задача заполнена
+	be.Err(t, m.AddFileToTask(ctx, taskID, url+"?2"), ErrMaxFilesExceeded)
+}
+
+func TestFindTasks(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1})
+
+	now := time.Now()
+	ids := make([]int64, 4)
+	for i := range ids {
+		id, err := m.CreateTask(ctx, model.TaskOptions{})
+		be.Err(t, err, nil)
+		be.Err(t, m.AddFileToTask(ctx, id, "http://example.com/file.pdf"), nil)
+		m.tasks[id].CreatedAt = now.Add(-time.Duration(i) * time.Hour) // ids[0] - самая новая
+		ids[i] = id
+	}
+
+	// задачи 1 и 3 с ошибкой
+	for _, id := range []int64{ids[1], ids[3]} {
+		_, err := m.UpdateTaskFiles(id, []File{{ID: 0, URL: "http://example.com/file.pdf", Status: 404}})
+		be.Err(t, err, nil)
+	}
+
+	taskIDs := func(tasks []Task) []int64 {
+		var ids []int64
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+
+	t.Run("all", func(t *testing.T) {
+		tasks, total, err := m.FindTasks(ctx, model.TaskFilter{}, -1, 0)
+		be.Err(t, err, nil)
+		be.Equal(t, total, 4)
+		be.Equal(t, taskIDs(tasks), ids)
+	})
+
+	t.Run("has_failed", func(t *testing.T) {
+		tasks, total, err := m.FindTasks(ctx, model.TaskFilter{HasFailed: true}, -1, 0)
+		be.Err(t, err, nil)
+		be.Equal(t, total, 2)
+		be.Equal(t, taskIDs(tasks), []int64{ids[1], ids[3]})
+	})
+
+	t.Run("by_age", func(t *testing.T) {
+		filter := model.TaskFilter{CreatedBefore: now.Add(-90 * time.Minute)}
+		tasks, total, err := m.FindTasks(ctx, filter, -1, 0)
+		be.Err(t, err, nil)
+		be.Equal(t, total, 2)
+		be.Equal(t, taskIDs(tasks), []int64{ids[2], ids[3]})
+
+		filter = model.TaskFilter{CreatedAfter: now.Add(-90 * time.Minute), HasFailed: true}
+		tasks, _, err = m.FindTasks(ctx, filter, -1, 0)
+		be.Err(t, err, nil)
+		be.Equal(t, taskIDs(tasks), []int64{ids[1]})
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		tasks, total, err := m.FindTasks(ctx, model.TaskFilter{}, 2, 1)
+		be.Err(t, err, nil)
+		be.Equal(t, total, 4)
+		be.Equal(t, taskIDs(tasks), []int64{ids[1], ids[2]})
+
+		tasks, total, err = m.FindTasks(ctx, model.TaskFilter{}, 2, 10)
+		be.Err(t, err, nil)
+		be.Equal(t, total, 4)
+		be.Equal(t, len(tasks), 0)
+	})
+}
+
+func TestCancel_DuringSweep(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var once sync.Once
+	m := newMemstor(Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Nanosecond, CleanInterval: time.Millisecond})
+	m.beforeClean = func() {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+	}
+	m.startTaskCleaner()
+
+	_, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	<-started // очистка началась и ждёт
+
+	cancelled := make(chan struct{})
+	go func() {
+		m.Cancel()
+		close(cancelled)
+	}()
+
+	select {
+	case <-cancelled:
+		t.Fatal("Cancel returned during active sweep")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Cancel did not return after sweep finished")
+	}
+
+	_, err = m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, ErrServerCancelled)
+
+	m.Cancel() // повторный вызов безопасен
+}
+
+func TestUpdateTaskFiles_UpdatedAt(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1})
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/0"), nil)
+
+	files, err := m.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+	files[0].Status = 502
+	task, err := m.UpdateTaskFiles(taskID, files)
+	be.Err(t, err, nil)
+	updated := task.UpdatedAt
+	be.True(t, !updated.IsZero())
+
+	// повторная проверка с тем же результатом задачу не меняет
+	time.Sleep(time.Millisecond)
+	task, err = m.UpdateTaskFiles(taskID, files)
+	be.Err(t, err, nil)
+	be.Equal(t, task.UpdatedAt, updated)
+
+	files[0].Status = 200
+	task, err = m.UpdateTaskFiles(taskID, files)
+	be.Err(t, err, nil)
+	be.True(t, task.UpdatedAt.After(updated))
+
+	stored, err := m.GetTask(taskID)
+	be.Err(t, err, nil)
+	be.Equal(t, stored.UpdatedAt, task.UpdatedAt)
+	be.Equal(t, stored.Files[0].Status, 200)
+}
+
+func TestUpdateTaskFiles_MissingFile(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1})
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	for _, url := range []string{"http://example.com/0", "http://example.com/1", "http://example.com/2"} {
+		be.Err(t, m.AddFileToTask(ctx, taskID, url), nil)
+	}
+
+	// начали обработку
+	files, err := m.GetTaskFiles(taskID)
+	be.Err(t, err, nil)
+
+	// пока шла обработка, задача заменена импортом без файла 1: ID файлов назначены заново,
+	// и ID 1 теперь у файла 2
+	tasks, err := m.ExportTasks(ctx)
+	be.Err(t, err, nil)
+	tasks[0].Files = slices.Delete(tasks[0].Files, 1, 2)
+	_, err = m.ImportTasks(ctx, tasks)
+	be.Err(t, err, nil)
+
+	for i := range files {
+		files[i].Status = 200 + i
+	}
+	task, err := m.UpdateTaskFiles(taskID, files)
+	be.Err(t, err, nil)
+
+	// обновлён только файл с совпадающими ID и URL, чужие записи не затёрты
+	be.Equal(t, len(task.Files), 2)
+	be.Equal(t, task.Files[0].URL, "http://example.com/0")
+	be.Equal(t, task.Files[0].Status, 200)
+	be.Equal(t, task.Files[1].URL, "http://example.com/2")
+	be.Equal(t, task.Files[1].Status, 0)
+}
+
+func TestImportTasks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tasks := []Task{
+		{ID: 1, Files: []File{{URL: "http://example.com/0"}, {URL: "http://example.com/1"}}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: 2, CreatedAt: now, ExpiresAt: now.Add(-time.Second)}, // устарела
+		{CreatedAt: now, ExpiresAt: now.Add(time.Hour)},           // без ID
+	}
+
+	t.Run("round_trip", func(t *testing.T) {
+		m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1})
+		n, err := m.ImportTasks(ctx, tasks)
+		be.Err(t, err, nil)
+		be.Equal(t, n, 2)
+
+		exported, err := m.ExportTasks(ctx)
+		be.Err(t, err, nil)
+		be.Equal(t, len(exported), 2)
+
+		files, err := m.GetTaskFiles(1)
+		be.Err(t, err, nil)
+		be.Equal(t, files[1].ID, int64(1))
+		be.Equal(t, files[1].URL, "http://example.com/1")
+
+		// повторный импорт заменяет задачи с теми же ID
+		m2 := newTestMemstor(t, Config{MaxTotal: 2, MaxFiles: -1})
+		n, err = m2.ImportTasks(ctx, exported)
+		be.Err(t, err, nil)
+		be.Equal(t, n, 2)
+		n, err = m2.ImportTasks(ctx, exported)
+		be.Err(t, err, nil)
+		be.Equal(t, n, 2)
+	})
+
+	t.Run("max_total", func(t *testing.T) {
+		m := newTestMemstor(t, Config{MaxTotal: 1, MaxFiles: -1})
+		_, err := m.ImportTasks(ctx, tasks)
+		be.Err(t, err, ErrServerBusy)
+
+		exported, err := m.ExportTasks(ctx)
+		be.Err(t, err, nil)
+		be.Equal(t, len(exported), 0)
+	})
+}
+
+func TestCleaner_Metrics(t *testing.T) {
+	ctx := context.Background()
+	metrics := make(chan Stats, 1)
+
+	m := newMemstor(Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Hour, CleanInterval: time.Millisecond, Metrics: true})
+	m.onMetrics = func(st Stats) {
+		select {
+		case metrics <- st:
+		default:
+		}
+	}
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/1.pdf"), nil)
+	be.Err(t, m.AddFileToTask(ctx, taskID, "http://example.com/2.pdf"), nil)
+
+	// устаревшая задача удаляется очисткой
+	expiredID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	m.tasks[expiredID].ExpiresAt = time.Now().Add(-time.Second)
+	m.scheduleExpiry(m.tasks[expiredID])
+
+	m.startTaskCleaner()
+	defer m.Cancel()
+
+	select {
+	case st := <-metrics:
+		be.Equal(t, st.Tasks, 1)
+		be.Equal(t, st.Files, 2)
+		be.Equal(t, st.Removed, 1)
+		be.True(t, st.ApproxBytes > 0)
+	case <-time.After(time.Second):
+		t.Fatal("metrics were not emitted")
+	}
+}
+
+func TestCleanExpiredTasks(t *testing.T) {
+	ctx := context.Background()
+	m := newMemstor(Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Hour})
+
+	keepID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	expiredID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+	deletedID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	past := time.Now().Add(-time.Second)
+	m.tasks[expiredID].ExpiresAt = past
+	m.scheduleExpiry(m.tasks[expiredID])
+	m.tasks[deletedID].ExpiresAt = past
+	m.scheduleExpiry(m.tasks[deletedID])
+	be.Err(t, m.DeleteTask(ctx, deletedID), nil)
+
+	// задача заменена импортом с продлённым сроком: прежний элемент очереди пропускается
+	task := m.tasks[keepID].Clone()
+	m.tasks[keepID].ExpiresAt = past
+	m.scheduleExpiry(m.tasks[keepID])
+	_, err = m.ImportTasks(ctx, []Task{task})
+	be.Err(t, err, nil)
+
+	be.Equal(t, m.cleanExpiredTasks(), 1)
+	be.Equal(t, len(m.tasks), 1)
+	be.True(t, m.tasks[keepID] != nil)
+	be.Equal(t, len(m.expiry), 4) // элементы, созданные с задачами, и элемент импорта ещё не устарели
+}
+
+func TestCleanExpiredTasks_OnDemand(t *testing.T) {
+	ctx := context.Background()
+
+	// чистильщик не запущен: задачи удаляются только по запросу
+	m := newMemstor(Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: -time.Second})
+	for range 3 {
+		_, err := m.CreateTask(ctx, model.TaskOptions{})
+		be.Err(t, err, nil)
+	}
+	m.cfg.TaskTTL = time.Hour
+	keepID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	n, err := m.CleanExpiredTasks(ctx)
+	be.Err(t, err, nil)
+	be.Equal(t, n, 3)
+	be.Equal(t, len(m.tasks), 1)
+	be.True(t, m.tasks[keepID] != nil)
+
+	n, err = m.CleanExpiredTasks(ctx)
+	be.Err(t, err, nil)
+	be.Equal(t, n, 0)
+
+	m.cancelled = true
+	_, err = m.CleanExpiredTasks(ctx)
+	be.Err(t, err, ErrServerCancelled)
+}
+
+func TestCleaner_WakesAtExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	// интервал очистки большой: задача удаляется по моменту устаревания, а не по интервалу
+	m := newTestMemstor(t, Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: 20 * time.Millisecond, CleanInterval: time.Hour})
+
+	taskID, err := m.CreateTask(ctx, model.TaskOptions{})
+	be.Err(t, err, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := m.GetTaskFiles(taskID)
+		if err == ErrTaskNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired task was not removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// scanExpiredTasks - прежняя очистка полным просмотром задач (для сравнения в бенчмарке).
+func (m *Memstor) scanExpiredTasks() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for id, task := range m.tasks {
+		if task.ExpiresAt.Before(now) {
+			delete(m.tasks, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+func BenchmarkCleanExpiredTasks(b *testing.B) {
+	const (
+		total   = 10000
+		expired = 10
+	)
+
+	benchmarks := []struct {
+		name  string
+		clean func(m *Memstor) int
+	}{
+		{"scan", (*Memstor).scanExpiredTasks},
+		{"queue", (*Memstor).cleanExpiredTasks},
+	}
+
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			ctx := context.Background()
+			m := newMemstor(Config{MaxTotal: -1, MaxFiles: -1, TaskTTL: time.Hour})
+			for range total {
+				if _, err := m.CreateTask(ctx, model.TaskOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			for b.Loop() {
+				b.StopTimer()
+				m.cfg.TaskTTL = -time.Second
+				for range expired {
+					if _, err := m.CreateTask(ctx, model.TaskOptions{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				m.cfg.TaskTTL = time.Hour
+				b.StartTimer()
+
+				if n := bb.clean(m); n != expired {
+					b.Fatalf("removed %d tasks, want %d", n, expired)
+				}
+			}
+		})
+	}
+}
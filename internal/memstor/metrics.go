@@ -0,0 +1,58 @@
+package memstor
+
+import (
+	"log/slog"
+	"unsafe"
+
+	"zipget/internal/model"
+)
+
+// Stats - метрики хранилища.
+type Stats struct {
+	Tasks       int   // количество задач
+	Files       int   // количество файлов во всех задачах
+	Removed     int   // количество задач, удалённых последней очисткой
+	ApproxBytes int64 // примерный объём памяти, занятой задачами и файлами
+}
+
+// Примерный размер структур без строк (строки учитываются по длине).
+const (
+	taskOverhead = int64(unsafe.Sizeof(model.Task{})) + 8 // + ключ в map
+	fileOverhead = int64(unsafe.Sizeof(model.File{}))
+)
+
+// Stats возвращает текущие метрики хранилища (Removed всегда 0).
+func (m *Memstor) Stats() Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var st Stats
+	st.Tasks = len(m.tasks)
+	for _, task := range m.tasks {
+		st.Files += len(task.Files)
+		st.ApproxBytes += taskOverhead + int64(len(task.CallbackURL)+len(task.Name))
+		for i := range task.Files {
+			f := &task.Files[i]
+			st.ApproxBytes += fileOverhead + int64(len(f.URL)+len(f.ContentType)+len(f.RealType)+
+				len(f.Extension)+len(f.OrigName)+len(f.Name)+len(f.ErrorMsg))
+		}
+	}
+	return st
+}
+
+// emitMetrics сообщает метрики хранилища после очистки (режим Config.Metrics).
+func (m *Memstor) emitMetrics(removed int) {
+	st := m.Stats()
+	st.Removed = removed
+
+	if m.onMetrics != nil {
+		m.onMetrics(st)
+		return
+	}
+	slog.Info("storage metrics",
+		"tasks", st.Tasks,
+		"files", st.Files,
+		"removed", st.Removed,
+		"approxBytes", st.ApproxBytes,
+	)
+}
@@ -0,0 +1,87 @@
+// Package metrics содержит метрики сервера в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "zipget"
+
+var (
+	// TasksCreated - количество созданных задач.
+	TasksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Total number of created tasks.",
+	})
+
+	// TasksDeleted - количество задач, удалённых клиентами (устаревшие задачи не учитываются).
+	TasksDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_deleted_total",
+		Help:      "Total number of tasks deleted by clients.",
+	})
+
+	// ActiveSlots - занятые слоты загрузки (MANAGER_MAX_ACTIVE).
+	ActiveSlots = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "download_slots_active",
+		Help:      "Number of download slots in use.",
+	})
+
+	// Downloads - количество загрузок файлов архивов по статусу.
+	Downloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Total number of file downloads by status code.",
+	}, []string{"status"})
+
+	// DownloadedBytes - объём загруженных файлов архивов.
+	DownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Total size of successfully downloaded files in bytes.",
+	})
+
+	// SSRFBlocked - количество соединений и перенаправлений, заблокированных защитой от SSRF.
+	SSRFBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssrf_blocked_total",
+		Help:      "Total number of connections and redirects blocked by SSRF protection.",
+	})
+)
+
+// registry - реестр метрик сервера (вместе с метриками процесса и среды выполнения Go).
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+		TasksCreated,
+		TasksDeleted,
+		ActiveSlots,
+		Downloads,
+		DownloadedBytes,
+		SSRFBlocked,
+	)
+}
+
+// Download учитывает загрузку файла со статусом status и размером size (размер учитывается
+// только для загруженного файла).
+func Download(status int, size int64) {
+	Downloads.WithLabelValues(strconv.Itoa(status)).Inc()
+	if status == http.StatusOK {
+		DownloadedBytes.Add(float64(size))
+	}
+}
+
+// Handler возвращает обработчик, отдающий метрики в формате Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,12 @@
+package model
+
+// Archive описывает параметры формируемого архива.
+type Archive struct {
+	TaskID int64  // ID задачи (0 - архив формируется вне задачи, например в CLI)
+	Format string // формат архива: zip (по умолчанию) или targz
+
+	// OnFile, если задан, вызывается один раз для каждого файла, когда результат его загрузки
+	// окончателен (файл записан в архив или отклонён). Может вызываться одновременно из нескольких
+	// горутин (параллельная загрузка).
+	OnFile func(file File)
+}
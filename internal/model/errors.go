@@ -5,6 +5,11 @@ import "errors"
 var (
 	ErrTaskNotFound     = errors.New("task not found")
 	ErrMaxFilesExceeded = errors.New("maximum files exceeded")
+	ErrDuplicateURL     = errors.New("url already added to task")
+	ErrNotEnoughFiles   = errors.New("not enough files to build archive")
+	ErrNoFiles          = errors.New("task has no files")
+	ErrInvalidCallback  = errors.New("invalid callback url")
 	ErrServerBusy       = errors.New("server busy")
+	ErrRateLimited      = errors.New("too many requests")
 	ErrServerCancelled  = errors.New("server has been cancelled")
 )
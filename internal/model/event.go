@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"zipget/internal/taskid"
+)
+
+// EventArchiveBuilt - тип уведомления о первой сборке архива задачи.
+const EventArchiveBuilt = "archive_built"
+
+// ArchiveEvent - тело уведомления (webhook) о сборке архива задачи.
+type ArchiveEvent struct {
+	Event         string     `json:"event"`
+	TaskID        taskid.Ref `json:"task_id"`        // в формате ID задачи для клиентов API
+	Total         int        `json:"total"`          // количество файлов задачи
+	Archived      int        `json:"archived"`       // количество файлов в архиве
+	Failed        int        `json:"failed"`         // количество файлов с ошибкой
+	ArchivedBytes int64      `json:"archived_bytes"` // объём файлов в архиве
+	BuiltAt       time.Time  `json:"built_at"`
+}
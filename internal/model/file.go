@@ -1,5 +1,7 @@
 package model
 
+import "net/http"
+
 // File представляет файл в задаче.
 //
 // Гарантируется, что ID уникален в пределах одной задачи.
@@ -10,9 +12,27 @@ type File struct {
 	URL         string `json:"url,omitempty"`
 	ContentType string `json:"content_type,omitempty"`
 	RealType    string `json:"real_type,omitempty"`
+	Extension   string `json:"extension,omitempty"` // каноническое расширение реального типа (например, ".jpg")
 	OrigName    string `json:"orig_name,omitempty"`
 	Name        string `json:"name,omitempty"`
 	Size        int64  `json:"size,omitempty"`
 	Status      int    `json:"status,omitempty"`
 	ErrorMsg    string `json:"error_msg,omitempty"`
+	SHA256      string `json:"sha256,omitempty"` // контрольная сумма содержимого (только для загруженных файлов)
+
+	TypeMismatch bool `json:"type_mismatch,omitempty"` // заявленный тип запрещён, файл принят по реальному типу
+	Unverified   bool `json:"unverified,omitempty"`    // сигнатура неизвестна, файл принят по заявленному типу
+	Interrupted  bool `json:"interrupted,omitempty"`   // загрузка прервана отменой (например, по дедлайну запроса)
+}
+
+// Transient сообщает, что статус файла - временная ошибка (408, 429, 502, 503, 504): источника
+// или ограничений самого загрузчика (очередь к хосту, разомкнутый выключатель). Такой результат
+// не окончательный: файл проверяется и загружается повторно.
+func (f *File) Transient() bool {
+	switch f.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
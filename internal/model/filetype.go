@@ -0,0 +1,16 @@
+package model
+
+// FileType описывает тип файла: MIME-тип, сигнатуру (первые байты содержимого) и расширения.
+type FileType struct {
+	MIMEType   string
+	Magic      []byte   // сигнатура файла
+	Extensions []string // первое расширение - каноническое
+	Compressed bool     // содержимое уже сжато: повторное сжатие в архиве бесполезно
+}
+
+func (f FileType) Extension() string {
+	if len(f.Extensions) == 0 {
+		return ""
+	}
+	return f.Extensions[0]
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+// TaskFilter - условия отбора задач. Нулевые поля выборку не ограничивают.
+type TaskFilter struct {
+	HasFailed     bool      // в задаче есть файлы, завершившиеся ошибкой
+	CreatedBefore time.Time // задача создана раньше
+	CreatedAfter  time.Time // задача создана позже
+}
+
+func (f TaskFilter) Match(t *Task) bool {
+	if !f.CreatedBefore.IsZero() && !t.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !t.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if f.HasFailed && !hasFailedFiles(t.Files) {
+		return false
+	}
+	return true
+}
+
+func hasFailedFiles(files []File) bool {
+	for i := range files {
+		if s := files[i].Status; s != 0 && s != http.StatusOK {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// Preview - предварительная оценка архива задачи, полученная без скачивания файлов.
+type Preview struct {
+	Entries    []string  `json:"entries"`     // предполагаемые имена файлов в архиве
+	Size       int64     `json:"size"`        // приблизительный размер архива в байтах
+	PreparedAt time.Time `json:"prepared_at"` // время подготовки
+}
+
+func (p *Preview) Clone() *Preview {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Entries = slices.Clone(p.Entries)
+	return &c
+}
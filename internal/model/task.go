@@ -1,16 +1,45 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"slices"
 	"time"
 )
 
 type Task struct {
 	ID        int64     `json:"id,omitempty"`
-	Files     []File    `json:"files,omitempty"`
+	Files     []File    `json:"files"` // всегда сериализуется, пустой список - [] (см. MarshalJSON)
 	CreatedAt time.Time `json:"created_at,omitzero"`
 	UpdatedAt time.Time `json:"updated_at,omitzero"`
 	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Preview   *Preview  `json:"preview,omitempty"` // оценка архива (сбрасывается при добавлении файла)
+	Name      string    `json:"name,omitempty"`    // базовое имя архива при скачивании (пусто - task_{id})
+
+	CallbackURL string    `json:"callback_url,omitempty"` // URL для уведомления о сборке архива
+	NotifiedAt  time.Time `json:"notified_at,omitzero"`   // время первой сборки архива (отправки уведомления)
+
+	// Вычисляемые при проверке поля (не хранятся)
+	AdvertisedSize int64 `json:"advertised_size,omitempty"` // сумма заявленных (Content-Length) размеров доступных файлов
+	OverBudget     bool  `json:"over_budget,omitempty"`     // AdvertisedSize превышает бюджет задачи
+}
+
+// MarshalJSON сериализует задачу, заменяя отсутствующий список файлов на пустой:
+// клиенты всегда получают "files": [], а не null или отсутствующее поле.
+func (t Task) MarshalJSON() ([]byte, error) {
+	type task Task // без методов, чтобы избежать рекурсии
+	if t.Files == nil {
+		t.Files = []File{}
+	}
+	return json.Marshal(task(t))
+}
+
+// TaskOptions - параметры, задаваемые при создании задачи.
+type TaskOptions struct {
+	CallbackURL string // URL для уведомления о сборке архива (пусто - без уведомления)
+	Name        string // безопасное базовое имя архива без расширения (пусто - task_{id})
 }
 
 // Clone создает полную копию задачи, включая глубокое копирование слайса Files.
@@ -22,5 +51,23 @@ func (t Task) Clone() Task {
 		CreatedAt: t.CreatedAt,
 		UpdatedAt: t.UpdatedAt,
 		ExpiresAt: t.ExpiresAt,
+		Preview:   t.Preview.Clone(),
+		Name:      t.Name,
+
+		CallbackURL: t.CallbackURL,
+		NotifiedAt:  t.NotifiedAt,
+
+		AdvertisedSize: t.AdvertisedSize,
+		OverBudget:     t.OverBudget,
+	}
+}
+
+// ContentVersion возвращает короткий детерминированный идентификатор содержимого задачи - хеш
+// ID и URL её файлов. Не зависит от времени и результатов проверки, меняется при добавлении файла.
+func (t Task) ContentVersion() string {
+	h := sha256.New()
+	for i := range t.Files {
+		fmt.Fprintf(h, "%d\x00%s\x00", t.Files[i].ID, t.Files[i].URL)
 	}
+	return hex.EncodeToString(h.Sum(nil)[:4])
 }
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+
+	"zipget/internal/metrics"
 )
 
 var privateIPBlocks []*net.IPNet
@@ -35,25 +37,71 @@ func IsPrivateIP(ip net.IP) bool {
 
 var ErrSSRF = errors.New("ssrf protection")
 
-// ReplaceHostToIP резолвит хост, проверяет ip, возвращает адрес в котором host заменен на ip.
-// Возвращает любые ошибки которые возникаю при разрешении хоста. Если ip локальный, возвращает ошибку ErrSSRF.
-func ReplaceHostToIP(host string) (string, error) {
+// Семейства адресов для соединения (SSRF_IP_FAMILY)
+const (
+	FamilyAny  = "any"  // первый адрес из ответа DNS (по умолчанию)
+	FamilyIPv4 = "ipv4" // только IPv4
+	FamilyIPv6 = "ipv6" // только IPv6
+)
+
+// lookupIP резолвит хост (подменяется в тестах).
+var lookupIP = net.LookupIP
+
+// ReplaceHostToIP резолвит хост, проверяет ip, возвращает адрес в котором host заменен на ip
+// семейства family (FamilyAny, FamilyIPv4, FamilyIPv6; пусто - FamilyAny).
+// Проверяются все адреса хоста, а не только выбранный. Возвращает любые ошибки которые возникаю
+// при разрешении хоста. Если ip локальный, возвращает ошибку ErrSSRF.
+func ReplaceHostToIP(host, family string) (string, error) {
 	host, port, _ := net.SplitHostPort(host)
 
-	// Резолвим DNS
-	ips, err := net.LookupIP(host)
+	ips, err := lookupPublicIP(host)
 	if err != nil {
 		return "", err
 	}
+
+	ip, err := pickIP(ips, family)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", host, err)
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// pickIP выбирает первый адрес семейства family.
+func pickIP(ips []net.IP, family string) (net.IP, error) {
+	for _, ip := range ips {
+		switch family {
+		case FamilyIPv4:
+			if ip.To4() == nil {
+				continue
+			}
+		case FamilyIPv6:
+			if ip.To4() != nil {
+				continue
+			}
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no %s address found", family)
+}
+
+// lookupPublicIP резолвит хост и проверяет, что ни один из его адресов не локальный (иначе ErrSSRF).
+func lookupPublicIP(host string) ([]net.IP, error) {
+	// Резолвим DNS
+	ips, err := lookupIP(host)
+	if err != nil {
+		return nil, err
+	}
 	if len(ips) == 0 {
-		return "", errors.New("no IP addresses found")
+		return nil, errors.New("no IP addresses found")
 	}
 
 	for _, ip := range ips {
 		if IsPrivateIP(ip) {
-			return "", fmt.Errorf("%w: private IP %s is not allowed", ErrSSRF, ip)
+			metrics.SSRFBlocked.Inc()
+			return nil, fmt.Errorf("%w: private IP %s is not allowed", ErrSSRF, ip)
 		}
 	}
 
-	return ips[0].String() + ":" + port, nil
+	return ips, nil
 }
@@ -0,0 +1,50 @@
+package protect
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestReplaceHostToIP_Family(t *testing.T) {
+	dualStack := []net.IP{
+		net.ParseIP("2606:4700::6810:85e5"),
+		net.ParseIP("93.184.216.34"),
+	}
+	lookup := func(ips []net.IP) func(string) ([]net.IP, error) {
+		return func(string) ([]net.IP, error) { return ips, nil }
+	}
+	t.Cleanup(func() { lookupIP = net.LookupIP })
+
+	tests := []struct {
+		name    string
+		ips     []net.IP
+		family  string
+		want    string
+		wantErr bool
+	}{
+		{"any", dualStack, FamilyAny, "[2606:4700::6810:85e5]:443", false},
+		{"default", dualStack, "", "[2606:4700::6810:85e5]:443", false},
+		{"ipv4", dualStack, FamilyIPv4, "93.184.216.34:443", false},
+		{"ipv6", dualStack, FamilyIPv6, "[2606:4700::6810:85e5]:443", false},
+		{"ipv6_missing", dualStack[1:], FamilyIPv6, "", true},
+		{"ipv4_missing", dualStack[:1], FamilyIPv4, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupIP = lookup(tt.ips)
+			got, err := ReplaceHostToIP("example.com:443", tt.family)
+			be.Equal(t, err != nil, tt.wantErr)
+			be.Equal(t, got, tt.want)
+		})
+	}
+
+	t.Run("private_other_family", func(t *testing.T) {
+		// проверяются все адреса, даже если выбранное семейство публичное
+		lookupIP = lookup([]net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("::1")})
+		_, err := ReplaceHostToIP("example.com:443", FamilyIPv4)
+		be.True(t, errors.Is(err, ErrSSRF))
+	})
+}
@@ -0,0 +1,48 @@
+package protect
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestSchemeProxy(t *testing.T) {
+	var got string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.String() // прокси получает абсолютный URL
+		io.WriteString(w, "ok")
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	t.Cleanup(func() { lookupIP = net.LookupIP })
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "internal.example" {
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		}
+		return []net.IP{net.ParseIP("93.184.216.34")}, nil
+	}
+
+	transport := &http.Transport{}
+	transport.RegisterProtocol("files", SchemeProxy(proxyURL, FamilyAny))
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get("files://example.com/a.pdf")
+	be.Err(t, err, nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	be.Equal(t, string(body), "ok")
+	be.Equal(t, got, "http://example.com/a.pdf")
+
+	// хост с локальным адресом не передаётся прокси
+	got = ""
+	_, err = client.Get("files://internal.example/a.pdf")
+	be.True(t, errors.Is(err, ErrSSRF))
+	be.Equal(t, got, "")
+}
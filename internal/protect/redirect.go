@@ -0,0 +1,42 @@
+package protect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrRedirectDowngrade = errors.New("redirect from https to http is not allowed")
+)
+
+// RedirectPolicy - политика следования перенаправлениям для http.Client.CheckRedirect.
+type RedirectPolicy struct {
+	MaxRedirects   int  // максимальное количество перенаправлений (0 - запрещены, <0 - без ограничений)
+	AllowDowngrade bool // разрешить перенаправление с https на http
+	BlockPrivate   bool // проверять хост каждого перехода на SSRF (локальные адреса запрещены)
+}
+
+// CheckRedirect вызывается клиентом перед каждым перенаправлением.
+// При BlockPrivate хост перехода резолвится и проверяется до запроса, поэтому перенаправление
+// на локальный адрес завершается ошибкой ErrSSRF, даже если соединение с ним уже открыто
+// (проверка при установке соединения, см. ReplaceHostToIP, остаётся вторым рубежом).
+func (p RedirectPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if p.MaxRedirects >= 0 && len(via) > p.MaxRedirects {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyRedirects, p.MaxRedirects)
+	}
+
+	// понижение протокола на любом переходе цепочки
+	if !p.AllowDowngrade && req.URL.Scheme == "http" && via[len(via)-1].URL.Scheme == "https" {
+		return fmt.Errorf("%w: %s", ErrRedirectDowngrade, req.URL.Redacted())
+	}
+
+	if p.BlockPrivate {
+		if _, err := lookupPublicIP(req.URL.Hostname()); err != nil {
+			return fmt.Errorf("redirect to %s: %w", req.URL.Redacted(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package protect
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestRedirectPolicy_BlockPrivate(t *testing.T) {
+	newReq := func(url string) *http.Request {
+		req, err := http.NewRequest("GET", url, nil)
+		be.Err(t, err, nil)
+		return req
+	}
+
+	// цепочка через публичные адреса (CDN), заканчивающаяся локальным
+	via := []*http.Request{
+		newReq("http://93.184.216.34/file.pdf"),
+		newReq("https://[2606:4700::6810:85e5]/file.pdf"),
+	}
+
+	tests := []struct {
+		url  string
+		ssrf bool
+	}{
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://10.1.2.3:8080/file.pdf", true},
+		{"http://127.0.0.1/file.pdf", true},
+		{"http://[::1]/file.pdf", true},
+		{"https://93.184.216.35/file.pdf", false},
+	}
+	for _, tt := range tests {
+		policy := RedirectPolicy{MaxRedirects: 5, AllowDowngrade: true, BlockPrivate: true}
+		err := policy.CheckRedirect(newReq(tt.url), via)
+		be.Equal(t, errors.Is(err, ErrSSRF), tt.ssrf)
+		if !tt.ssrf {
+			be.Err(t, err, nil)
+		}
+
+		// без BlockPrivate переход проверяется только при установке соединения
+		policy.BlockPrivate = false
+		be.Err(t, policy.CheckRedirect(newReq(tt.url), via), nil)
+	}
+}
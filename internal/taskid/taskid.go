@@ -0,0 +1,155 @@
+// Package taskid преобразует ID задачи в представление для клиентов API и обратно.
+//
+// В хранилище задача всегда имеет числовой ID. В числовом формате (по умолчанию) клиенты видят его
+// как есть. В непрозрачном формате клиенты получают строку из 22 символов base62 - зашифрованный
+// AES (ключ сервера) блок из ID и 64 нулевых бит. Такие ID нельзя перебрать или подобрать:
+// без ключа угадать строку, которая расшифруется в блок с нулевым хвостом, можно лишь с
+// вероятностью 2^-64, а числовой ID по строке не восстанавливается.
+package taskid
+
+import (
+	"cmp"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Форматы ID задачи (config.API.TaskIDFormat)
+const (
+	FormatNumeric = "numeric" // числовой ID хранилища
+	FormatOpaque  = "opaque"  // непрозрачная строка base62
+)
+
+const (
+	opaqueLen = 22 // длина 128-битного блока в base62
+	keyLen    = 16 // длина случайного ключа, если ключ не задан
+)
+
+var ErrInvalid = errors.New("invalid task id")
+
+// Codec преобразует ID задачи. Нулевой (и nil) Codec использует числовой формат.
+type Codec struct {
+	block cipher.Block // nil - числовой формат
+}
+
+// New создаёт Codec формата format. Для непрозрачного формата key - ключ AES в шестнадцатеричной
+// записи (16, 24 или 32 байта). Если ключ не задан, создаётся случайный: тогда ID, выданные
+// клиентам, перестают действовать после перезапуска сервера (в том числе для импортированных задач).
+func New(format, key string) (*Codec, error) {
+	switch format {
+	case "", FormatNumeric:
+		return &Codec{}, nil
+	case FormatOpaque:
+	default:
+		return nil, fmt.Errorf("unknown task id format %q", format)
+	}
+
+	var k []byte
+	if key == "" {
+		k = make([]byte, keyLen)
+		rand.Read(k)
+		slog.Warn("task id key is not set, opaque task ids will change after restart")
+	} else {
+		var err error
+		if k, err = hex.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid task id key: %w", err)
+		}
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, fmt.Errorf("invalid task id key: %w", err)
+	}
+	return &Codec{block: block}, nil
+}
+
+// Opaque сообщает, что используется непрозрачный формат.
+func (c *Codec) Opaque() bool {
+	return c != nil && c.block != nil
+}
+
+// Encode возвращает представление ID для клиентов.
+func (c *Codec) Encode(id int64) string {
+	if !c.Opaque() {
+		return strconv.FormatInt(id, 10)
+	}
+
+	var b [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(id))
+	c.block.Encrypt(b[:], b[:])
+
+	s := new(big.Int).SetBytes(b[:]).Text(62)
+	return strings.Repeat("0", opaqueLen-len(s)) + s
+}
+
+// Decode возвращает ID по его представлению для клиентов или ErrInvalid.
+func (c *Codec) Decode(s string) (int64, error) {
+	if !c.Opaque() {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: must be integer", ErrInvalid)
+		}
+		return id, nil
+	}
+
+	if len(s) != opaqueLen {
+		return 0, ErrInvalid
+	}
+	n, ok := new(big.Int).SetString(s, 62)
+	if !ok || n.Sign() < 0 || n.BitLen() > aes.BlockSize*8 {
+		return 0, ErrInvalid
+	}
+
+	var b [aes.BlockSize]byte
+	n.FillBytes(b[:])
+	c.block.Decrypt(b[:], b[:])
+	if binary.BigEndian.Uint64(b[8:]) != 0 {
+		return 0, ErrInvalid
+	}
+	return int64(binary.BigEndian.Uint64(b[:8])), nil
+}
+
+// Ref возвращает ссылку на задачу для ответа API.
+func (c *Codec) Ref(id int64) Ref {
+	return Ref{s: c.Encode(id), quoted: c.Opaque()}
+}
+
+// Ref - ID задачи в ответе API: в числовом формате сериализуется в JSON числом (как раньше),
+// в непрозрачном - строкой.
+type Ref struct {
+	s      string
+	quoted bool
+}
+
+func (r Ref) String() string {
+	return r.s
+}
+
+func (r Ref) MarshalJSON() ([]byte, error) {
+	if !r.quoted {
+		return []byte(cmp.Or(r.s, "0")), nil
+	}
+	return json.Marshal(r.s)
+}
+
+func (r *Ref) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		r.quoted = true
+		return json.Unmarshal(data, &r.s)
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*r = Ref{s: n.String()}
+	return nil
+}
@@ -0,0 +1,140 @@
+package taskid
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f"
+
+func TestNumeric(t *testing.T) {
+	for _, c := range []*Codec{nil, {}} {
+		be.Equal(t, c.Opaque(), false)
+		be.Equal(t, c.Encode(123), "123")
+
+		id, err := c.Decode("123")
+		be.Err(t, err, nil)
+		be.Equal(t, id, int64(123))
+
+		_, err = c.Decode("abc")
+		be.Err(t, err, ErrInvalid)
+	}
+}
+
+func TestOpaque(t *testing.T) {
+	c, err := New(FormatOpaque, testKey)
+	be.Err(t, err, nil)
+	be.True(t, c.Opaque())
+
+	for _, id := range []int64{0, 1, 123, math.MaxInt64} {
+		s := c.Encode(id)
+		be.Equal(t, len(s), opaqueLen)
+
+		got, err := c.Decode(s)
+		be.Err(t, err, nil)
+		be.Equal(t, got, id)
+	}
+
+	// соседние ID не похожи друг на друга
+	be.True(t, c.Encode(1)[:4] != c.Encode(2)[:4])
+
+	// другой ключ не принимает чужие ID
+	other, err := New(FormatOpaque, strings.Repeat("ff", 16))
+	be.Err(t, err, nil)
+	_, err = other.Decode(c.Encode(123))
+	be.Err(t, err, ErrInvalid)
+}
+
+func TestOpaque_Invalid(t *testing.T) {
+	c, err := New(FormatOpaque, testKey)
+	be.Err(t, err, nil)
+	valid := c.Encode(123)
+
+	// изменённый символ
+	tampered := []byte(valid)
+	tampered[10] ^= 1
+	if tampered[10] == valid[10] {
+		tampered[10]++
+	}
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"numeric", "123"},
+		{"empty", ""},
+		{"short", valid[1:]},
+		{"long", valid + "0"},
+		{"tampered", string(tampered)},
+		{"bad_chars", "-" + valid[1:]},
+		{"overflow", strings.Repeat("z", opaqueLen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.Decode(tt.s)
+			be.Err(t, err, ErrInvalid)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		key     string
+		opaque  bool
+		wantErr bool
+	}{
+		{"default", "", "", false, false},
+		{"numeric", FormatNumeric, testKey, false, false},
+		{"opaque", FormatOpaque, testKey, true, false},
+		{"opaque_random_key", FormatOpaque, "", true, false},
+		{"opaque_aes256", FormatOpaque, testKey + testKey, true, false},
+		{"bad_hex", FormatOpaque, "xyz", false, true},
+		{"bad_key_len", FormatOpaque, "0001", false, true},
+		{"unknown_format", "uuid", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.format, tt.key)
+			if tt.wantErr {
+				be.True(t, err != nil)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, c.Opaque(), tt.opaque)
+		})
+	}
+}
+
+func TestRef_JSON(t *testing.T) {
+	opaque, err := New(FormatOpaque, testKey)
+	be.Err(t, err, nil)
+
+	tests := []struct {
+		name  string
+		codec *Codec
+		want  string
+	}{
+		{"numeric", nil, `{"id":123}`},
+		{"opaque", opaque, `{"id":"` + opaque.Encode(123) + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type resp struct {
+				ID Ref `json:"id"`
+			}
+			data, err := json.Marshal(resp{ID: tt.codec.Ref(123)})
+			be.Err(t, err, nil)
+			be.Equal(t, string(data), tt.want)
+
+			var got resp
+			be.Err(t, json.Unmarshal(data, &got), nil)
+			be.Equal(t, got.ID, tt.codec.Ref(123))
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"math/rand/v2"
 	"net/http"
@@ -94,6 +96,7 @@ type File struct {
 	Status      int    `json:"status,omitempty"`
 	ContentType string `json:"content_type,omitempty"`
 	ErrorMsg    string `json:"error_msg,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
 }
 
 // TestCreateTask проверяет создание новой задачи.
@@ -177,13 +180,14 @@ func TestAddFileToTask(t *testing.T) {
 	deleteTask(t, taskID)
 }
 
-// TestGetTaskStatus_ArchiveLink проверяет, что при 3 файлах возвращается ссылка на архив.
+// TestGetTaskStatus_ArchiveLink проверяет, что при 3 файлах (хотя бы один доступен) возвращается
+// ссылка на архив.
 func TestGetTaskStatus_ArchiveLink(t *testing.T) {
 	taskID := createTask(t)
 
 	// Добавляем 3 файла
 	urls := []string{
-		httpbinBaseURL + "/bytes/1024",
+		httpbinBaseURL + "/image/jpeg",
 		httpbinBaseURL + "/bytes/2048",
 		httpbinBaseURL + "/bytes/4096",
 	}
@@ -435,18 +439,35 @@ func TestBlockDownloadFromLocalhost(t *testing.T) {
 			t.Errorf("Expected 403 for private URL %s, got %d", url, file.Status)
 		}
 	}
-	fileName := cmp.Or(file.Name, file.OrigName, "jpeg.jpeg")
+	if file.SHA256 != "" {
+		t.Errorf("Expected no checksum for blocked file, got %s", file.SHA256)
+	}
+
+	// Проверяем по содержимому, что в архиве нет файла с локального хоста
+	// (проверять, что архив пустой, нельзя: loader добавляет в архив файл отчёта)
+	data, err := fs.ReadFile(files.Static, "jpeg.jpeg")
+	if err != nil {
+		t.Fatalf("can't read local file: %v", err)
+	}
+	localSum := sha256.Sum256(data)
 
-	// Проверяем, что в архиве не файла с локального хоста
-	// FIXME: проверка по имени ненадежна нужно проверять хеш-сумму.
-	//  Проверять, что архив пустой нельзя, т.к. loader может (и добавляет) файл отчета в архив
 	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
 	if err != nil {
 		t.Fatalf("can't create zip reader: %v", err)
 	}
 	for _, file := range zr.File {
-		if file.Name == fileName {
-			t.Errorf("archive contains data file %s", fileName)
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("can't open archive entry %s: %v", file.Name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("can't read archive entry %s: %v", file.Name, err)
+		}
+		if bytes.Equal(h.Sum(nil), localSum[:]) {
+			t.Errorf("archive contains data file %s", file.Name)
 		}
 	}
 }
@@ -0,0 +1,90 @@
+// Package urlutil содержит общие для сервера и CLI преобразования URL файлов.
+package urlutil
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts - порты, подразумеваемые схемой.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize приводит URL к каноническому виду: удаляет фрагмент (#...), порт по умолчанию
+// для схемы (:80 для http, :443 для https), переводит схему и хост в нижний регистр.
+// Семантически одинаковые URL после нормализации совпадают.
+//
+// Некорректный или относительный URL возвращается без изменений - его отклонит валидация.
+func Normalize(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	switch {
+	case port != "" && port != defaultPorts[u.Scheme]:
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"): // IPv6
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	return u.String()
+}
+
+// Redact удаляет из URL учётные данные (user:password@).
+func Redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// MaskedValue заменяет значения скрытых параметров запроса.
+const MaskedValue = "REDACTED"
+
+// MaskQuery заменяет значения параметров запроса из params на MaskedValue (имена сравниваются
+// без учёта регистра, "*" скрывает все параметры). Порядок параметров сохраняется.
+// Используется для отображения URL (например, подписанных), запрос выполняется по исходному URL.
+func MaskQuery(raw string, params []string) string {
+	if len(params) == 0 {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if masked(name, params) {
+			pairs[i] = key + "=" + MaskedValue
+		}
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	return u.String()
+}
+
+func masked(name string, params []string) bool {
+	for _, p := range params {
+		if p == "*" || strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+// Package version хранит версию сборки.
+//
+// Значение задаётся при сборке:
+//
+//	go build -ldflags "-X zipget/internal/version.Version=v1.2.3" ./cmd/zipgetd
+package version
+
+var Version = "dev"
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Notifier доставляет уведомления POST-запросом с JSON-телом.
+//
+// Доставка выполняется в фоне. Неудачная попытка (ошибка сети или статус не 2xx) повторяется
+// до Retries раз с экспоненциально растущей задержкой (Backoff, 2*Backoff, ...).
+// Каждая попытка ограничена timeout (0 - без ограничений). Незавершённые доставки
+// прерываются Cancel или Shutdown. SSRF-защита обеспечивается переданным клиентом.
+type Notifier struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New(client *http.Client, retries int, backoff, timeout time.Duration) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		client:  client,
+		retries: retries,
+		backoff: backoff,
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Notify отправляет payload на url в фоне.
+func (n *Notifier) Notify(url string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("marshal webhook payload failed", "url", url, "error", err)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.send(n.ctx, url, body); err != nil {
+			slog.Warn("webhook delivery failed", "url", url, "error", err)
+		}
+	}()
+}
+
+// Wait ожидает завершения всех начатых доставок.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// Cancel прерывает незавершённые доставки (текущие попытки и ожидание повторов).
+func (n *Notifier) Cancel() {
+	n.cancel()
+}
+
+// Shutdown ожидает завершения начатых доставок, пока не истечёт ctx, после чего прерывает
+// оставшиеся и возвращает ошибку ctx.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.Cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	delay := n.backoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(ctx, url, body)
+		if err == nil || attempt >= n.retries {
+			return err
+		}
+
+		slog.Debug("webhook retry", "url", url, "attempt", attempt+1, "delay", delay.String(), "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
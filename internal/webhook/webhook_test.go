@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+)
+
+func TestNotify_Retries(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		be.Equal(t, r.Header.Get("Content-Type"), "application/json")
+		var payload map[string]int
+		be.Err(t, json.NewDecoder(r.Body).Decode(&payload), nil)
+		be.Equal(t, payload["n"], 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	n := New(http.DefaultClient, 2, time.Millisecond, 0)
+	n.Notify(srv.URL, map[string]int{"n": 1})
+	n.Wait()
+
+	be.Equal(t, attempts.Load(), int32(3)) // первая попытка и два повтора
+}
+
+func TestNotify_AttemptTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := New(http.DefaultClient, 1, time.Millisecond, 20*time.Millisecond)
+	n.Notify(srv.URL, map[string]int{"n": 1})
+
+	done := make(chan struct{})
+	go func() {
+		n.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery did not time out")
+	}
+	be.Equal(t, attempts.Load(), int32(2)) // зависшая попытка прервана и повторена
+}
+
+func TestShutdown_CancelsDeliveries(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	// повтор ожидался бы час
+	n := New(http.DefaultClient, 3, time.Hour, 0)
+	n.Notify(srv.URL, map[string]int{"n": 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := n.Shutdown(ctx)
+	be.Err(t, err, context.DeadlineExceeded)
+	be.True(t, time.Since(start) < 5*time.Second)
+	be.Equal(t, attempts.Load(), int32(1))
+}
@@ -0,0 +1,114 @@
+"use strict";
+
+// Веб-интерфейс zipget: создание задачи, добавление URL, опрос статуса и скачивание архива.
+// Все действия выполняются через публичный JSON API.
+
+const api = document.body.dataset.api;
+const pollInterval = 2000;
+
+const $ = (id) => document.getElementById(id);
+
+let taskID = null;
+let pollTimer = null;
+
+// request выполняет запрос к API и возвращает разобранный JSON;
+// ошибка API ({"error": "..."}) выбрасывается как исключение.
+async function request(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(api + path, opts);
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.status + " " + resp.statusText);
+  }
+  return data;
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+  $("error").hidden = !err;
+}
+
+function formatSize(n) {
+  if (!n) return "";
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i ? n.toFixed(1) : n) + " " + units[i];
+}
+
+function render(status) {
+  const tbody = $("files");
+  tbody.replaceChildren();
+  for (const f of status.task.files) {
+    const tr = document.createElement("tr");
+    const state = !f.status ? "ожидает проверки" : f.status === 200 ? "OK" : f.status + (f.error_msg ? ": " + f.error_msg : "");
+    for (const text of [f.url, f.name || f.orig_name || "", formatSize(f.size), state]) {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.append(td);
+    }
+    if (f.status) tr.lastChild.className = f.status === 200 ? "ok" : "fail";
+    tbody.append(tr);
+  }
+
+  $("summary").textContent = "Файлов: " + status.files_total + ", доступно: " + status.valid_files;
+  $("download").hidden = !status.valid_files;
+  $("download").href = api + "/tasks/" + encodeURIComponent(taskID) + "/archive";
+}
+
+async function poll() {
+  clearTimeout(pollTimer);
+  if (taskID === null) return;
+  try {
+    render(await request("GET", "/tasks/" + encodeURIComponent(taskID)));
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+  pollTimer = setTimeout(poll, pollInterval);
+}
+
+function openTask(id) {
+  taskID = String(id);
+  location.hash = taskID;
+  $("task-id").textContent = taskID;
+  $("task").hidden = false;
+  poll();
+}
+
+$("create").addEventListener("click", async () => {
+  try {
+    const resp = await request("POST", "/tasks");
+    openTask(resp.task_id);
+  } catch (err) {
+    showError(err);
+  }
+});
+
+$("open").addEventListener("submit", (e) => {
+  e.preventDefault();
+  openTask($("open-id").value.trim());
+});
+
+$("add").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  try {
+    await request("POST", "/tasks/" + encodeURIComponent(taskID) + "/files", { url: $("add-url").value });
+    $("add-url").value = "";
+    poll();
+  } catch (err) {
+    showError(err);
+  }
+});
+
+// ID задачи хранится в адресе страницы: после перезагрузки задача открывается снова
+if (location.hash.length > 1) {
+  openTask(decodeURIComponent(location.hash.slice(1)));
+}
@@ -0,0 +1,47 @@
+// Package webui - встроенный веб-интерфейс для создания задач и скачивания архивов.
+// Интерфейс работает только через публичный JSON API сервера.
+package webui
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler отдаёт файлы интерфейса. Путь запроса - относительно корня интерфейса
+// (префикс снимается http.StripPrefix); apiBasePath - путь JSON API, к которому обращается интерфейс.
+func Handler(apiBasePath string) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	// путь API подставляется в страницу один раз при запуске
+	tmpl := template.Must(template.ParseFS(files, "index.html"))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ APIBasePath string }{apiBasePath}); err != nil {
+		panic(err)
+	}
+	index := buf.Bytes()
+	modTime := time.Now()
+
+	fileServer := http.FileServerFS(files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "", "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.ServeContent(w, r, "index.html", modTime, bytes.NewReader(index))
+		case "/index.html":
+			// как http.FileServer: index.html доступен только как корень каталога
+			http.Redirect(w, r, "./", http.StatusMovedPermanently)
+		default:
+			fileServer.ServeHTTP(w, r)
+		}
+	})
+}